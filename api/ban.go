@@ -41,7 +41,7 @@ func handleBanImpl(w http.ResponseWriter, r *http.Request) string {
 		return "Bad request"
 	}
 
-	if apiSecret == "" || query.Get("secret") != apiSecret {
+	if !isAuthorized(r, query) {
 		return "Invalid API secret"
 	}
 
@@ -117,7 +117,7 @@ func handleBanImpl(w http.ResponseWriter, r *http.Request) string {
 	}
 
 	if tos {
-		gpcm.KickPlayer(uint32(pid), "banned")
+		gpcm.DisconnectProfile(uint32(pid), "banned")
 	} else {
 		gpcm.KickPlayer(uint32(pid), "restricted")
 	}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"wwfc/gpcm"
+)
+
+func HandleBroadcast(w http.ResponseWriter, r *http.Request) {
+	sent, errorString := handleBroadcastImpl(w, r)
+
+	var jsonData []byte
+	if errorString != "" {
+		jsonData, _ = json.Marshal(map[string]string{"error": errorString})
+	} else {
+		jsonData, _ = json.Marshal(map[string]any{"success": "true", "sent": sent})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Length", strconv.Itoa(len(jsonData)))
+	w.Write(jsonData)
+}
+
+func handleBroadcastImpl(w http.ResponseWriter, r *http.Request) (int, string) {
+	u, err := url.Parse(r.URL.String())
+	if err != nil {
+		return 0, "Bad request"
+	}
+
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return 0, "Bad request"
+	}
+
+	if !isAuthorized(r, query) {
+		return 0, "Invalid API secret"
+	}
+
+	message := query.Get("msg")
+	if message == "" {
+		return 0, "Missing msg in request"
+	}
+
+	return gpcm.BroadcastMessage(message, query.Get("game")), ""
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"wwfc/common"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+type controlContextKey struct{}
+
+// Requests through the control port are authorized by the client certificate
+// instead of the API secret
+func isAuthorized(r *http.Request, query url.Values) bool {
+	if authorized, ok := r.Context().Value(controlContextKey{}).(bool); ok && authorized {
+		return true
+	}
+
+	return apiSecret != "" && query.Get("secret") == apiSecret
+}
+
+func newControlTLSConfig(cert tls.Certificate, clientCAs *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+func controlHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/kick", HandleKick)
+	mux.HandleFunc("/ban", HandleBan)
+	mux.HandleFunc("/unban", HandleUnban)
+	mux.HandleFunc("/export", HandleExport)
+	mux.HandleFunc("/history", HandleHistory)
+	mux.HandleFunc("/broadcast", HandleBroadcast)
+	mux.HandleFunc("/sessions", HandleSessions)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The TLS config already refuses connections without a valid client
+		// certificate; this guards against the handler being served without it
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			http.Error(w, "Client certificate required", http.StatusUnauthorized)
+			return
+		}
+
+		logging.Notice("API-CONTROL", aurora.Yellow(r.Method), aurora.Cyan(r.URL.Path), "from", aurora.BrightCyan(r.TLS.PeerCertificates[0].Subject.CommonName))
+		mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), controlContextKey{}, true)))
+	})
+}
+
+func loadControlTLSConfig(config common.Config) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(config.ControlCertPath, config.ControlKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	caData, err := os.ReadFile(config.ControlClientCAPath)
+	if err != nil {
+		return nil, err
+	}
+
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caData) {
+		return nil, errors.New("no certificates found in " + config.ControlClientCAPath)
+	}
+
+	return newControlTLSConfig(cert, clientCAs), nil
+}
+
+// Serve the moderation endpoints on a separate port that requires a client
+// certificate, so they don't have to be exposed on the public NAS port
+func startControlServer(config common.Config) {
+	tlsConfig, err := loadControlTLSConfig(config)
+	if err != nil {
+		logging.Error("API-CONTROL", "Failed to load TLS config:", err.Error())
+		return
+	}
+
+	server := &http.Server{
+		Addr:      config.ControlAddress,
+		Handler:   controlHandler(),
+		TLSConfig: tlsConfig,
+	}
+
+	logging.Notice("API-CONTROL", "Starting control server on", aurora.BrightCyan(config.ControlAddress))
+	err = server.ListenAndServeTLS("", "")
+	if err != nil {
+		logging.Error("API-CONTROL", "Control server stopped:", err.Error())
+	}
+}
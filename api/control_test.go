@@ -0,0 +1,148 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func createTestCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestControlServerRequiresClientCertificate(t *testing.T) {
+	apiSecret = ""
+
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := time.Now().Add(time.Hour)
+
+	ca, caKey, _ := createTestCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+
+	_, _, serverCert := createTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "control"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+
+	_, _, clientCert := createTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "moderator"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	server := httptest.NewUnstartedServer(controlHandler())
+	server.TLS = newControlTLSConfig(serverCert, pool)
+	server.StartTLS()
+	defer server.Close()
+
+	newClient := func(certificates []tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      pool,
+			Certificates: certificates,
+		}}}
+	}
+
+	// Kicking with a valid client certificate works without the API secret
+	resp, err := newClient([]tls.Certificate{clientCert}).Get(server.URL + "/kick?pid=1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	var reply map[string]string
+	if err := json.Unmarshal(body, &reply); err != nil || reply["success"] != "true" {
+		t.Fatalf("kick through the control port failed: %s", body)
+	}
+
+	// Broadcasting and listing sessions work the same way
+	client := newClient([]tls.Certificate{clientCert})
+	resp, err = client.Get(server.URL + "/broadcast?msg=Maintenance+soon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	var broadcast map[string]any
+	if err := json.Unmarshal(body, &broadcast); err != nil || broadcast["success"] != "true" {
+		t.Fatalf("broadcast through the control port failed: %s", body)
+	}
+
+	resp, err = client.Get(server.URL + "/sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	var sessions []map[string]any
+	if err := json.Unmarshal(body, &sessions); err != nil {
+		t.Fatalf("session listing through the control port failed: %s", body)
+	}
+
+	resp, err = client.Get(server.URL + "/sessions?pid=1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	reply = nil
+	if err := json.Unmarshal(body, &reply); err != nil || reply["error"] != "Profile not online" {
+		t.Fatalf("expected an offline profile to be reported, got %s", body)
+	}
+
+	// A connection without a client certificate is refused
+	resp, err = newClient(nil).Get(server.URL + "/kick?pid=1000")
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("request without a client certificate was accepted")
+	}
+}
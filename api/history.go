@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"wwfc/gpcm"
+)
+
+func HandleHistory(w http.ResponseWriter, r *http.Request) {
+	jsonData, errorString := handleHistoryImpl(w, r)
+	if errorString != "" {
+		jsonData, _ = json.Marshal(map[string]string{"error": errorString})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Length", strconv.Itoa(len(jsonData)))
+	w.Write(jsonData)
+}
+
+func handleHistoryImpl(w http.ResponseWriter, r *http.Request) ([]byte, string) {
+	// TODO: Actual authentication rather than a fixed secret
+
+	u, err := url.Parse(r.URL.String())
+	if err != nil {
+		return nil, "Bad request"
+	}
+
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, "Bad request"
+	}
+
+	if !isAuthorized(r, query) {
+		return nil, "Invalid API secret"
+	}
+
+	pidStr := query.Get("pid")
+	if pidStr == "" {
+		return nil, "Missing pid in request"
+	}
+
+	pid, err := strconv.ParseUint(pidStr, 10, 32)
+	if err != nil {
+		return nil, "Invalid pid"
+	}
+
+	history, exists := gpcm.GetCommandHistory(uint32(pid))
+	if !exists {
+		return nil, "Profile not online"
+	}
+
+	jsonData, err := json.Marshal(history)
+	if err != nil {
+		return nil, "Internal server error"
+	}
+
+	return jsonData, ""
+}
@@ -31,4 +31,8 @@ func StartServer() {
 	if err != nil {
 		panic(err)
 	}
+
+	if config.ControlAddress != "" {
+		go startControlServer(config)
+	}
 }
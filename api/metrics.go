@@ -0,0 +1,17 @@
+package api
+
+import (
+	"net/http"
+	"wwfc/metrics"
+)
+
+// Serve the metrics if the configured sink can expose them
+func HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	handler, ok := metrics.GetSink().(http.Handler)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"wwfc/gpcm"
+)
+
+// List the live GPCM sessions, or inspect a single one by pid
+func HandleSessions(w http.ResponseWriter, r *http.Request) {
+	jsonData, errorString := handleSessionsImpl(w, r)
+	if errorString != "" {
+		jsonData, _ = json.Marshal(map[string]string{"error": errorString})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Length", strconv.Itoa(len(jsonData)))
+	w.Write(jsonData)
+}
+
+func handleSessionsImpl(w http.ResponseWriter, r *http.Request) ([]byte, string) {
+	u, err := url.Parse(r.URL.String())
+	if err != nil {
+		return nil, "Bad request"
+	}
+
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, "Bad request"
+	}
+
+	if !isAuthorized(r, query) {
+		return nil, "Invalid API secret"
+	}
+
+	var result any
+	if pidStr := query.Get("pid"); pidStr != "" {
+		pid, err := strconv.ParseUint(pidStr, 10, 32)
+		if err != nil {
+			return nil, "Invalid pid"
+		}
+
+		online, info := gpcm.IsOnline(uint32(pid))
+		if !online {
+			return nil, "Profile not online"
+		}
+		result = info
+	} else {
+		result = gpcm.ListSessions()
+	}
+
+	jsonData, err := json.Marshal(result)
+	if err != nil {
+		return nil, "Internal server error"
+	}
+
+	return jsonData, ""
+}
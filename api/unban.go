@@ -39,7 +39,7 @@ func handleUnbanImpl(w http.ResponseWriter, r *http.Request) string {
 		return "Bad request"
 	}
 
-	if apiSecret == "" || query.Get("secret") != apiSecret {
+	if !isAuthorized(r, query) {
 		return "Invalid API secret"
 	}
 
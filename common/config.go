@@ -20,6 +20,9 @@ type Config struct {
 	EnableHTTPSExploitWii   *bool   `xml:"enableHttpsExploitWii,omitempty"`
 	EnableHTTPSExploitDS    *bool   `xml:"enableHttpsExploitDS,omitempty"`
 	LogLevel                *int    `xml:"logLevel"`
+	LogFile                 string  `xml:"logFile,omitempty"`
+	LogMaxSize              int64   `xml:"logMaxSize,omitempty"`
+	LogMaxAge               int     `xml:"logMaxAge,omitempty"`
 	CertPath                string  `xml:"certPath"`
 	KeyPath                 string  `xml:"keyPath"`
 	CertPathWii             string  `xml:"certDerPathWii"`
@@ -30,6 +33,97 @@ type Config struct {
 	APISecret               string  `xml:"apiSecret"`
 	AllowDefaultDolphinKeys bool    `xml:"allowDefaultDolphinKeys"`
 	ServerName              string  `xml:"serverName,omitempty"`
+
+	RegionLockedGames          []string                `xml:"regionLockedGames>game,omitempty"`
+	NATNEGDeferInitAckGames    []string                `xml:"natnegDeferInitAckGames>game,omitempty"`
+	GPCMOfflineGracePeriod     int                     `xml:"gpcmOfflineGracePeriod,omitempty"`
+	GPCMMaxConnections         int                     `xml:"gpcmMaxConnections,omitempty"`
+	GPCMMaxConcurrentLogins    int                     `xml:"gpcmMaxConcurrentLogins,omitempty"`
+	GPCMKeepAliveTimestamp     bool                    `xml:"gpcmKeepAliveTimestamp,omitempty"`
+	DBSlowQueryThreshold       int                     `xml:"dbSlowQueryThreshold,omitempty"`
+	NATNEGProbePorts           []int                   `xml:"natnegProbePorts>port,omitempty"`
+	NATNEGReadTimeout          int                     `xml:"natnegReadTimeout,omitempty"`
+	ShiftJISNameGames          []string                `xml:"shiftJISNameGames>game,omitempty"`
+	ControlAddress             string                  `xml:"controlAddress,omitempty"`
+	ControlCertPath            string                  `xml:"controlCertPath,omitempty"`
+	ControlKeyPath             string                  `xml:"controlKeyPath,omitempty"`
+	ControlClientCAPath        string                  `xml:"controlClientCAPath,omitempty"`
+	MatchRules                 []MatchRules            `xml:"matchRules>game,omitempty"`
+	NATNEGMaxStrayBytes        int                     `xml:"natnegMaxStrayBytes,omitempty"`
+	GPCMBannedIPs              []string                `xml:"gpcmBannedIPs>ip,omitempty"`
+	GPCMRequireCommandHMAC     bool                    `xml:"gpcmRequireCommandHMAC,omitempty"`
+	GPCMMaxWriteBufferSize     int                     `xml:"gpcmMaxWriteBufferSize,omitempty"`
+	GPCMMinSDKRevision         int                     `xml:"gpcmMinSdkRevision,omitempty"`
+	GPCMRejectOutdatedSDK      bool                    `xml:"gpcmRejectOutdatedSdk,omitempty"`
+	NATNEGInitAckPayloads      []NATNEGInitAckPayload  `xml:"natnegInitAckPayloads>payload,omitempty"`
+	GPCMProfileUpdateInterval  int                     `xml:"gpcmProfileUpdateInterval,omitempty"`
+	NATNEGEndpointRewrites     []NATNEGEndpointRewrite `xml:"natnegEndpointRewrites>rewrite,omitempty"`
+	NATNEGMaxSessionsPerIP     int                     `xml:"natnegMaxSessionsPerIP,omitempty"`
+	GPCMIdleTimeout            int                     `xml:"gpcmIdleTimeout,omitempty"`
+	Features                   []FeatureFlag           `xml:"features>feature,omitempty"`
+	GPCMFlagDeviceMismatch     bool                    `xml:"gpcmFlagDeviceMismatch,omitempty"`
+	NATNEGGameClientLimits     []NATNEGGameClientLimit `xml:"natnegGameClientLimits>game,omitempty"`
+	NATNEGStatsFlushInterval   int                     `xml:"natnegStatsFlushInterval,omitempty"`
+	GPCMStatusBatchWindow      int                     `xml:"gpcmStatusBatchWindow,omitempty"`
+	GPCMRejectGameCodeMismatch bool                    `xml:"gpcmRejectGameCodeMismatch,omitempty"`
+	NATNEGMaxPortDelta         int                     `xml:"natnegMaxPortDelta,omitempty"`
+	MetricsSink                string                  `xml:"metricsSink,omitempty"`
+	NATNEGSessionAuditInterval int                     `xml:"natnegSessionAuditInterval,omitempty"`
+	GPCMMinPreLoginThroughput  int                     `xml:"gpcmMinPreLoginThroughput,omitempty"`
+	GPCMBlocks                 []GPCMBlock             `xml:"gpcmBlocks>block,omitempty"`
+	DatabaseRegions            []DatabaseRegion        `xml:"databaseRegions>database,omitempty"`
+	DangerousTestLogin         bool                    `xml:"dangerousTestLogin,omitempty"`
+	NATNEGReplyRateLimit       int                     `xml:"natnegReplyRateLimit,omitempty"`
+}
+
+// Reservation fields that must be equal for two players of a game to be paired
+type MatchRules struct {
+	GameName string           `xml:"name,attr"`
+	Fields   []MatchFieldRule `xml:"field"`
+}
+
+// A field in the game-defined user data of a reservation
+type MatchFieldRule struct {
+	Name   string `xml:"name,attr"`
+	Offset int    `xml:"offset,attr"`
+	Length int    `xml:"length,attr"`
+}
+
+// Hex encoded bytes sent after the port type and client index in a NATNEG init
+// ack for a protocol version
+type NATNEGInitAckPayload struct {
+	Version byte   `xml:"version,attr"`
+	Payload string `xml:",chardata"`
+}
+
+// Number of clients a NATNEG session for the game negotiates between. A session
+// is only complete with at least Min clients, and inits past Max are rejected.
+type NATNEGGameClientLimit struct {
+	GameName string `xml:"name,attr"`
+	Min      byte   `xml:"min,attr"`
+	Max      byte   `xml:"max,attr"`
+}
+
+// Endpoints in a network that NATNEG peers should be told to reach at another IP
+type NATNEGEndpointRewrite struct {
+	From string `xml:"from,attr"`
+	To   string `xml:"to,attr"`
+}
+
+// A game and/or region refused at GPCM login, with the message shown to the
+// client. An empty game or region matches any.
+type GPCMBlock struct {
+	GameName string `xml:"game,attr"`
+	Region   string `xml:"region,attr"`
+	Message  string `xml:",chardata"`
+}
+
+// A database used for GPCM sessions from a console region instead of the
+// default one. An empty name uses the default database name.
+type DatabaseRegion struct {
+	Region  byte   `xml:"region,attr"`
+	Address string `xml:"address,attr"`
+	Name    string `xml:"name,attr"`
 }
 
 func GetConfig() Config {
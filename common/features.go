@@ -0,0 +1,62 @@
+package common
+
+import (
+	"encoding/xml"
+	"os"
+	"sync"
+)
+
+// A named feature toggle from the config
+type FeatureFlag struct {
+	Name    string `xml:"name,attr"`
+	Enabled bool   `xml:",chardata"`
+}
+
+var (
+	features      = map[string]bool{}
+	featuresMutex = sync.RWMutex{}
+)
+
+// Check if a feature is enabled. Features not in the config are disabled.
+func FeatureEnabled(name string) bool {
+	featuresMutex.RLock()
+	defer featuresMutex.RUnlock()
+
+	return features[name]
+}
+
+func SetFeature(name string, enabled bool) {
+	featuresMutex.Lock()
+	defer featuresMutex.Unlock()
+
+	features[name] = enabled
+}
+
+// Replace all feature flags with the ones from the config
+func LoadFeatures(config Config) {
+	loaded := map[string]bool{}
+	for _, feature := range config.Features {
+		loaded[feature.Name] = feature.Enabled
+	}
+
+	featuresMutex.Lock()
+	features = loaded
+	featuresMutex.Unlock()
+}
+
+// Read the feature flags from the config file again, leaving the current ones
+// in place if it can't be parsed
+func ReloadFeatures(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var config Config
+	if err := xml.Unmarshal(data, &config); err != nil {
+		return err
+	}
+
+	LoadFeatures(config)
+	return nil
+}
@@ -0,0 +1,40 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFeatureFlags(t *testing.T) {
+	defer LoadFeatures(Config{})
+
+	if FeatureEnabled("test") {
+		t.Fatal("unknown feature should be disabled")
+	}
+
+	SetFeature("test", true)
+	if !FeatureEnabled("test") {
+		t.Fatal("feature should be enabled after setting it")
+	}
+
+	path := filepath.Join(t.TempDir(), "config.xml")
+	config := `<Config><features><feature name="other">true</feature><feature name="test">false</feature></features></Config>`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ReloadFeatures(path); err != nil {
+		t.Fatal(err)
+	}
+	if FeatureEnabled("test") || !FeatureEnabled("other") {
+		t.Fatal("reloaded flags were not applied")
+	}
+
+	if err := os.WriteFile(path, []byte(`<Config><features>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadFeatures(path); err == nil || !FeatureEnabled("other") {
+		t.Fatal("a broken config should leave the flags in place")
+	}
+}
@@ -130,3 +130,27 @@ func DoesGameNeedExploit(gameName string) bool {
 	// Exploit is only implemented for Mario Kart Wii and Mario Kart DS currently
 	return gameName == "mariokartwii" || gameName == "mariokartds"
 }
+
+// Product code prefixes of the games a game name can be sent with, without the
+// region letter. Games that aren't listed accept any code.
+var gameCodePrefixes = map[string][]string{
+	"mariokartwii":      {"RMC"},
+	"mariokartds":       {"AMC"},
+	"smashbrosxwii":     {"RSB"},
+	"animalcrossingwii": {"RUU"},
+}
+
+// Check that the game code from the login token belongs to the game name
+func IsGameCodeConsistent(gameName string, gameCode string) bool {
+	prefixes, exists := gameCodePrefixes[gameName]
+	if !exists {
+		return true
+	}
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(gameCode, prefix) {
+			return true
+		}
+	}
+	return false
+}
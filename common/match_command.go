@@ -178,112 +178,118 @@ func GetMatchCommandString(command byte) string {
 	return "UNKNOWN"
 }
 
-func DecodeMatchCommand(command byte, buffer []byte, version int) (MatchCommandData, bool) {
+// A reservation that is incomplete or has an invalid field
+type ReservationError struct {
+	Version int
+	Field   string
+	Reason  string
+}
+
+func (e ReservationError) Error() string {
+	return fmt.Sprintf("invalid version %d reservation: %s %s", e.Version, e.Field, e.Reason)
+}
+
+// Decode and validate the data of a reservation command
+func DecodeMatchReservation(buffer []byte, version int) (*MatchCommandDataReservation, error) {
 	if version != 3 && version != 11 && version != 90 {
-		return MatchCommandData{}, false
+		return nil, ReservationError{version, "version", "is not supported"}
 	}
 
 	// Match commands must be 4 byte aligned
 	if (len(buffer) & 3) != 0 {
-		return MatchCommandData{}, false
+		return nil, ReservationError{version, "data", "is not 4 byte aligned"}
 	}
 
-	switch command {
-	case MatchReservation:
-		if version == 3 && len(buffer) < 0x04 {
-			break
-		}
+	minLength := map[int]int{3: 0x04, 11: 0x14, 90: 0x24}[version]
+	if len(buffer) < minLength {
+		return nil, ReservationError{version, "data", fmt.Sprintf("is too short (%d < %d bytes)", len(buffer), minLength)}
+	}
 
-		if (version == 11 && len(buffer) < 0x14) || (version == 90 && len(buffer) < 0x24) {
-			break
-		}
+	matchType := binary.LittleEndian.Uint32(buffer[0x00:0x04])
+	if matchType > 3 {
+		return nil, ReservationError{version, "match type", fmt.Sprintf("%d is out of range", matchType)}
+	}
 
-		matchType := binary.LittleEndian.Uint32(buffer[0x00:0x04])
-		if matchType > 3 {
-			break
-		}
+	// Version 3 reservations may leave out the public address
+	if version == 3 && len(buffer) < 0x0C {
+		return &MatchCommandDataReservation{
+			MatchType:   byte(matchType),
+			HasPublicIP: false,
+		}, nil
+	}
 
-		if version == 3 && len(buffer) < 0x0C {
-			return MatchCommandData{
-				Version: version,
-				Command: command,
-				Reservation: &MatchCommandDataReservation{
-					MatchType:   byte(matchType),
-					HasPublicIP: false,
-				},
-			}, true
+	publicPort := binary.LittleEndian.Uint32(buffer[0x08:0x0C])
+	if publicPort > 0xffff {
+		return nil, ReservationError{version, "public port", fmt.Sprintf("%d is out of range", publicPort)}
+	}
+
+	reservation := &MatchCommandDataReservation{
+		MatchType:   byte(matchType),
+		HasPublicIP: true,
+		PublicIP:    binary.BigEndian.Uint32(buffer[0x04:0x08]),
+		PublicPort:  uint16(publicPort),
+	}
+
+	switch version {
+	case 3:
+		reservation.UserData = buffer[0x0C:]
+
+	case 11:
+		isFriendValue := binary.LittleEndian.Uint32(buffer[0x0C:0x10])
+		if isFriendValue > 1 {
+			return nil, ReservationError{version, "is friend", fmt.Sprintf("%d is not a boolean", isFriendValue)}
 		}
 
-		publicPort := binary.LittleEndian.Uint32(buffer[0x08:0x0C])
-		if publicPort > 0xffff {
-			break
+		reservation.IsFriend = isFriendValue != 0
+		reservation.LocalPlayerCount = binary.LittleEndian.Uint32(buffer[0x10:0x14])
+		reservation.UserData = buffer[0x14:]
+
+	case 90:
+		localPort := binary.LittleEndian.Uint32(buffer[0x10:0x14])
+		if localPort > 0xffff {
+			return nil, ReservationError{version, "local port", fmt.Sprintf("%d is out of range", localPort)}
 		}
 
-		switch version {
-		case 3:
-			return MatchCommandData{
-				Version: version,
-				Command: command,
-				Reservation: &MatchCommandDataReservation{
-					MatchType:   byte(matchType),
-					HasPublicIP: true,
-					PublicIP:    binary.BigEndian.Uint32(buffer[0x04:0x08]),
-					PublicPort:  uint16(publicPort),
-					UserData:    buffer[0x0C:],
-				},
-			}, true
+		isFriendValue := binary.LittleEndian.Uint32(buffer[0x18:0x1C])
+		if isFriendValue > 1 {
+			return nil, ReservationError{version, "is friend", fmt.Sprintf("%d is not a boolean", isFriendValue)}
+		}
 
-		case 11:
-			isFriendValue := binary.LittleEndian.Uint32(buffer[0x0C:0x10])
-			if isFriendValue > 1 {
-				break
-			}
-			isFriend := isFriendValue != 0
+		reservation.LocalIP = binary.BigEndian.Uint32(buffer[0x0C:0x10])
+		reservation.LocalPort = uint16(localPort)
+		reservation.Unknown = binary.LittleEndian.Uint32(buffer[0x14:0x18])
+		reservation.IsFriend = isFriendValue != 0
+		reservation.LocalPlayerCount = binary.LittleEndian.Uint32(buffer[0x1C:0x20])
+		reservation.ResvCheckValue = binary.LittleEndian.Uint32(buffer[0x20:0x24])
+		reservation.UserData = buffer[0x24:]
+	}
 
-			return MatchCommandData{
-				Version: version,
-				Command: command,
-				Reservation: &MatchCommandDataReservation{
-					MatchType:        byte(matchType),
-					HasPublicIP:      true,
-					PublicIP:         binary.BigEndian.Uint32(buffer[0x04:0x08]),
-					PublicPort:       uint16(publicPort),
-					IsFriend:         isFriend,
-					LocalPlayerCount: binary.LittleEndian.Uint32(buffer[0x10:0x14]),
-					UserData:         buffer[0x14:],
-				},
-			}, true
+	return reservation, nil
+}
 
-		case 90:
-			localPort := binary.LittleEndian.Uint32(buffer[0x10:0x14])
-			if localPort > 0xffff {
-				break
-			}
+func DecodeMatchCommand(command byte, buffer []byte, version int) (MatchCommandData, bool) {
+	if version != 3 && version != 11 && version != 90 {
+		return MatchCommandData{}, false
+	}
 
-			isFriendValue := binary.LittleEndian.Uint32(buffer[0x18:0x1C])
-			if isFriendValue > 1 {
-				break
-			}
-			isFriend := isFriendValue != 0
+	// Match commands must be 4 byte aligned
+	if (len(buffer) & 3) != 0 {
+		return MatchCommandData{}, false
+	}
 
-			return MatchCommandData{
-				Version: version,
-				Command: command,
-				Reservation: &MatchCommandDataReservation{
-					MatchType:        byte(matchType),
-					HasPublicIP:      true,
-					PublicIP:         binary.BigEndian.Uint32(buffer[0x04:0x08]),
-					PublicPort:       uint16(publicPort),
-					LocalIP:          binary.BigEndian.Uint32(buffer[0x0C:0x10]),
-					LocalPort:        uint16(localPort),
-					Unknown:          binary.LittleEndian.Uint32(buffer[0x14:0x18]),
-					IsFriend:         isFriend,
-					LocalPlayerCount: binary.LittleEndian.Uint32(buffer[0x1C:0x20]),
-					ResvCheckValue:   binary.LittleEndian.Uint32(buffer[0x20:0x24]),
-					UserData:         buffer[0x24:],
-				}}, true
+	switch command {
+	case MatchReservation:
+		reservation, err := DecodeMatchReservation(buffer, version)
+		if err != nil {
+			break
 		}
 
+		return MatchCommandData{
+			Version:     version,
+			Command:     command,
+			Reservation: reservation,
+		}, true
+
 	case MatchResvOK:
 		if version == 3 || version == 11 {
 			if len(buffer) < 0xC {
@@ -0,0 +1,59 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func makeReservation90(matchType uint32, publicPort uint32, localPort uint32, isFriend uint32) []byte {
+	buffer := binary.LittleEndian.AppendUint32(nil, matchType)
+	buffer = binary.BigEndian.AppendUint32(buffer, 0x01020304)
+	buffer = binary.LittleEndian.AppendUint32(buffer, publicPort)
+	buffer = binary.BigEndian.AppendUint32(buffer, 0xc0a80102)
+	buffer = binary.LittleEndian.AppendUint32(buffer, localPort)
+	buffer = binary.LittleEndian.AppendUint32(buffer, 0)
+	buffer = binary.LittleEndian.AppendUint32(buffer, isFriend)
+	buffer = binary.LittleEndian.AppendUint32(buffer, 2)
+	buffer = binary.LittleEndian.AppendUint32(buffer, 0xdeadbeef)
+	return append(buffer, 0xaa, 0xbb, 0xcc, 0xdd)
+}
+
+func TestDecodeMatchReservation(t *testing.T) {
+	reservation, err := DecodeMatchReservation(makeReservation90(1, 1234, 5678, 1), 90)
+	if err != nil {
+		t.Fatalf("valid reservation rejected: %v", err)
+	}
+
+	if reservation.MatchType != 1 || !reservation.HasPublicIP || reservation.PublicIP != 0x01020304 || reservation.PublicPort != 1234 ||
+		reservation.LocalIP != 0xc0a80102 || reservation.LocalPort != 5678 || !reservation.IsFriend ||
+		reservation.LocalPlayerCount != 2 || reservation.ResvCheckValue != 0xdeadbeef || len(reservation.UserData) != 4 {
+		t.Fatalf("reservation fields not populated correctly: %+v", reservation)
+	}
+
+	tests := []struct {
+		name   string
+		buffer []byte
+		field  string
+	}{
+		{"truncated", makeReservation90(1, 1234, 5678, 1)[:0x20], "data"},
+		{"unaligned", makeReservation90(1, 1234, 5678, 1)[:0x25], "data"},
+		{"match type", makeReservation90(9, 1234, 5678, 1), "match type"},
+		{"public port", makeReservation90(1, 0x10000, 5678, 1), "public port"},
+		{"local port", makeReservation90(1, 1234, 0x10000, 1), "local port"},
+		{"is friend", makeReservation90(1, 1234, 5678, 2), "is friend"},
+	}
+
+	for _, test := range tests {
+		_, err := DecodeMatchReservation(test.buffer, 90)
+
+		var reservationErr ReservationError
+		if !errors.As(err, &reservationErr) || reservationErr.Field != test.field {
+			t.Errorf("%s: expected an error for %q, got %v", test.name, test.field, err)
+		}
+
+		if _, ok := DecodeMatchCommand(MatchReservation, test.buffer, 90); ok {
+			t.Errorf("%s: malformed reservation decoded as a match command", test.name)
+		}
+	}
+}
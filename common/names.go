@@ -0,0 +1,61 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+// Decode a name field sent by the console into UTF-8. Names are normally
+// UTF-16, but some Japanese games send Shift-JIS. If the bytes are not valid
+// in the expected encoding they are kept as an escaped string instead.
+func DecodeInGameName(raw []byte, byteOrder binary.ByteOrder, shiftJIS bool) string {
+	if shiftJIS {
+		if nullTerminator := bytes.IndexByte(raw, 0); nullTerminator != -1 {
+			raw = raw[:nullTerminator]
+		}
+
+		decoded, err := japanese.ShiftJIS.NewDecoder().Bytes(raw)
+		if err != nil || bytes.ContainsRune(decoded, utf8.RuneError) {
+			return EscapeRawName(raw)
+		}
+		return string(decoded)
+	}
+
+	if len(raw)%2 != 0 {
+		return EscapeRawName(raw)
+	}
+
+	var utf16String []uint16
+	for i := 0; i < len(raw)/2; i++ {
+		utf16String = append(utf16String, byteOrder.Uint16(raw[i*2:i*2+2]))
+	}
+
+	decoded := utf16.Decode(utf16String)
+	for _, r := range decoded {
+		// Unpaired surrogates decode to the replacement character
+		if r == utf8.RuneError {
+			return EscapeRawName(raw)
+		}
+	}
+	return string(decoded)
+}
+
+// Keep printable ASCII as is and write every other byte as %XX, so a name in
+// an unknown encoding can still be stored and logged without corrupting it
+func EscapeRawName(raw []byte) string {
+	var builder strings.Builder
+	for _, b := range raw {
+		if b < 0x20 || b > 0x7e || b == '%' || b == '\\' {
+			builder.WriteString(fmt.Sprintf("%%%02X", b))
+		} else {
+			builder.WriteByte(b)
+		}
+	}
+	return builder.String()
+}
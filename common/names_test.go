@@ -0,0 +1,28 @@
+package common
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestDecodeInGameName(t *testing.T) {
+	// "まりお" in Shift-JIS, null padded
+	shiftJIS := []byte{0x82, 0xdc, 0x82, 0xe8, 0x82, 0xa8, 0x00, 0x00}
+	if name := DecodeInGameName(shiftJIS, binary.LittleEndian, true); name != "まりお" {
+		t.Errorf("Shift-JIS name decoded as %q", name)
+	}
+
+	// "Mario" in UTF-16BE
+	utf16Name := []byte{0x00, 'M', 0x00, 'a', 0x00, 'r', 0x00, 'i', 0x00, 'o'}
+	if name := DecodeInGameName(utf16Name, binary.BigEndian, false); name != "Mario" {
+		t.Errorf("UTF-16 name decoded as %q", name)
+	}
+
+	// Invalid data in either encoding is escaped rather than mangled
+	if name := DecodeInGameName([]byte{'A', 0x82}, binary.LittleEndian, true); name != "A%82" {
+		t.Errorf("invalid Shift-JIS name decoded as %q", name)
+	}
+	if name := DecodeInGameName([]byte{0xd8, 0x00, 0x00, 'A'}, binary.BigEndian, false); name != "%D8%00%00A" {
+		t.Errorf("UTF-16 name with an unpaired surrogate decoded as %q", name)
+	}
+}
@@ -2,8 +2,10 @@ package common
 
 import (
 	"bytes"
+	cryptorand "crypto/rand"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"math/rand"
 	"unicode/utf16"
 )
@@ -28,6 +30,19 @@ func RandomHexString(n int) string {
 	return string(b)
 }
 
+// RandomUUID generates a random (version 4) UUID string
+func RandomUUID() string {
+	b := make([]byte, 16)
+	if _, err := cryptorand.Read(b); err != nil {
+		rand.Read(b)
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
 func UTF16ToByteArray(wideString []uint16) []byte {
 	byteArray := make([]byte, len(wideString)*2)
 	for i, b := range wideString {
@@ -0,0 +1,5 @@
+package common
+
+// The server version reported to clients. Set it at build time with
+// -ldflags "-X wwfc/common.ServerVersion=...".
+var ServerVersion = "dev"
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"wwfc/common"
+	"wwfc/logging"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/logrusorgru/aurora/v3"
+)
+
+var ErrUserExists = errors.New("user already exists")
+
+// An account record as read by ImportAccounts, one JSON object per line
+type ImportedAccount struct {
+	ProfileId  uint32 `json:"profileId"`
+	UserId     uint64 `json:"userId"`
+	GsbrCode   string `json:"gsbrcd"`
+	NgDeviceId uint32 `json:"ngDeviceId"`
+	Email      string `json:"email"`
+	UniqueNick string `json:"uniqueNick"`
+	FirstName  string `json:"firstName"`
+	LastName   string `json:"lastName"`
+}
+
+// Returned by ImportAccounts when accounts were skipped because the user or
+// profile ID already exists
+type DuplicateAccountsError struct {
+	Lines []int
+}
+
+func (e *DuplicateAccountsError) Error() string {
+	lines := make([]string, 0, len(e.Lines))
+	for _, line := range e.Lines {
+		lines = append(lines, strconv.Itoa(line))
+	}
+	return "skipped " + strconv.Itoa(len(e.Lines)) + " duplicate accounts on lines " + strings.Join(lines, ", ")
+}
+
+// ImportAccounts reads JSONL account records and inserts them into the store,
+// returning how many were imported. Accounts that already exist are skipped
+// and reported in a DuplicateAccountsError once the rest are imported; any
+// other error stops the import.
+func ImportAccounts(store Store, r io.Reader) (int, error) {
+	imported := 0
+	var duplicates []int
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var account ImportedAccount
+		if err := json.Unmarshal([]byte(text), &account); err != nil {
+			return imported, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if account.UserId == 0 || account.GsbrCode == "" {
+			return imported, fmt.Errorf("line %d: missing user ID or gsbrcd", line)
+		}
+
+		user := User{
+			ProfileId:  account.ProfileId,
+			UserId:     account.UserId,
+			GsbrCode:   account.GsbrCode,
+			NgDeviceId: account.NgDeviceId,
+			Email:      account.Email,
+			UniqueNick: account.UniqueNick,
+			FirstName:  account.FirstName,
+			LastName:   account.LastName,
+		}
+
+		if user.UniqueNick == "" {
+			user.UniqueNick = common.Base32Encode(user.UserId) + user.GsbrCode
+		}
+		if user.Email == "" {
+			user.Email = user.UniqueNick + "@nds"
+		}
+
+		err := store.ImportUser(user)
+		if errors.Is(err, ErrUserExists) || errors.Is(err, ErrProfileIDInUse) {
+			logging.Warn("DATABASE", "Skipping duplicate account on line", aurora.Cyan(line), "-", err.Error())
+			duplicates = append(duplicates, line)
+			continue
+		}
+		if err != nil {
+			return imported, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		imported++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return imported, err
+	}
+
+	logging.Notice("DATABASE", "Imported", aurora.Cyan(imported), "accounts")
+	if len(duplicates) != 0 {
+		return imported, &DuplicateAccountsError{Lines: duplicates}
+	}
+	return imported, nil
+}
+
+// Insert an account from another server, keeping its profile ID
+func ImportUser(pool *pgxpool.Pool, ctx context.Context, user User) error {
+	var exists bool
+	err := queryRow(pool, ctx, "DoesUserExist", DoesUserExist, user.UserId, user.GsbrCode).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return ErrUserExists
+	}
+
+	if err := user.CreateUser(pool, ctx); err != nil {
+		return err
+	}
+
+	if user.FirstName != "" || user.LastName != "" {
+		user.UpdateProfile(pool, ctx, map[string]string{
+			"firstname": user.FirstName,
+			"lastname":  user.LastName,
+		})
+	}
+	return nil
+}
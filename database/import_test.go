@@ -0,0 +1,38 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestImportAccounts(t *testing.T) {
+	store := NewMemoryStore()
+	store.InsertUser(User{ProfileId: 300, UserId: 3, GsbrCode: "RMCJ"})
+
+	input := strings.Join([]string{
+		`{"profileId": 100, "userId": 1, "gsbrcd": "RMCJ", "ngDeviceId": 4660, "lastName": "000000000RMCJ"}`,
+		`{"profileId": 200, "userId": 2, "gsbrcd": "RMCE", "email": "player@nds"}`,
+		``,
+		`{"profileId": 301, "userId": 3, "gsbrcd": "RMCJ"}`,
+		`{"profileId": 100, "userId": 4, "gsbrcd": "RMCJ"}`,
+	}, "\n")
+
+	imported, err := ImportAccounts(store, strings.NewReader(input))
+	if imported != 2 {
+		t.Fatalf("expected 2 imported accounts, got %d", imported)
+	}
+
+	var duplicates *DuplicateAccountsError
+	if !errors.As(err, &duplicates) || len(duplicates.Lines) != 2 || duplicates.Lines[0] != 4 || duplicates.Lines[1] != 5 {
+		t.Fatalf("duplicates were not reported: %v", err)
+	}
+
+	user, exists := store.GetProfile(100)
+	if !exists || user.UserId != 1 || user.NgDeviceId != 4660 || user.LastName != "000000000RMCJ" || user.Email == "" {
+		t.Fatalf("imported account is wrong: %+v", user)
+	}
+	if user, exists := store.GetProfile(200); !exists || user.Email != "player@nds" {
+		t.Fatalf("imported account is wrong: %+v", user)
+	}
+}
@@ -19,8 +19,23 @@ var (
 )
 
 func LoginUserToGPCM(pool *pgxpool.Pool, ctx context.Context, userId uint64, gsbrcd string, profileId uint32, ngDeviceId uint32, ipAddress string, ingamesn string) (User, error) {
+	return loginUserToGPCM(pool, ctx, userId, gsbrcd, profileId, ngDeviceId, ipAddress, ingamesn, false)
+}
+
+// Log in from a device other than the one on record without failing with
+// ErrDeviceIDMismatch. The recorded device is kept, and bans are looked up
+// against the connecting device.
+func LoginUserToGPCMFromOtherDevice(pool *pgxpool.Pool, ctx context.Context, userId uint64, gsbrcd string, profileId uint32, ngDeviceId uint32, ipAddress string, ingamesn string) (User, error) {
+	return loginUserToGPCM(pool, ctx, userId, gsbrcd, profileId, ngDeviceId, ipAddress, ingamesn, true)
+}
+
+func loginUserToGPCM(pool *pgxpool.Pool, ctx context.Context, userId uint64, gsbrcd string, profileId uint32, ngDeviceId uint32, ipAddress string, ingamesn string, allowDeviceMismatch bool) (User, error) {
+	// The device bans are looked up against, the recorded one unless a
+	// mismatch is allowed
+	banDeviceId := uint32(0)
+
 	var exists bool
-	err := pool.QueryRow(ctx, DoesUserExist, userId, gsbrcd).Scan(&exists)
+	err := queryRow(pool, ctx, "DoesUserExist", DoesUserExist, userId, gsbrcd).Scan(&exists)
 	if err != nil {
 		return User{}, err
 	}
@@ -48,7 +63,7 @@ func LoginUserToGPCM(pool *pgxpool.Pool, ctx context.Context, userId uint64, gsb
 		var expectedNgId *uint32
 		var firstName *string
 		var lastName *string
-		err := pool.QueryRow(ctx, GetUserProfileID, userId, gsbrcd).Scan(&user.ProfileId, &expectedNgId, &user.Email, &user.UniqueNick, &firstName, &lastName)
+		err := queryRow(pool, ctx, "GetUserProfileID", GetUserProfileID, userId, gsbrcd).Scan(&user.ProfileId, &expectedNgId, &user.Email, &user.UniqueNick, &firstName, &lastName)
 		if err != nil {
 			return User{}, err
 		}
@@ -65,11 +80,14 @@ func LoginUserToGPCM(pool *pgxpool.Pool, ctx context.Context, userId uint64, gsb
 			user.NgDeviceId = *expectedNgId
 			if ngDeviceId != 0 && user.NgDeviceId != ngDeviceId {
 				logging.Error("DATABASE", "NG device ID mismatch for profile", aurora.Cyan(user.ProfileId), "- expected", aurora.Cyan(fmt.Sprintf("%08x", user.NgDeviceId)), "but got", aurora.Cyan(fmt.Sprintf("%08x", ngDeviceId)))
-				return User{}, ErrDeviceIDMismatch
+				if !allowDeviceMismatch {
+					return User{}, ErrDeviceIDMismatch
+				}
+				banDeviceId = ngDeviceId
 			}
 		} else if ngDeviceId != 0 {
 			user.NgDeviceId = ngDeviceId
-			_, err := pool.Exec(ctx, UpdateUserNGDeviceID, user.ProfileId, ngDeviceId)
+			err := exec(pool, ctx, "UpdateUserNGDeviceID", UpdateUserNGDeviceID, user.ProfileId, ngDeviceId)
 			if err != nil {
 				return User{}, err
 			}
@@ -95,7 +113,7 @@ func LoginUserToGPCM(pool *pgxpool.Pool, ctx context.Context, userId uint64, gsb
 	}
 
 	// Update the user's last IP address and ingamesn
-	_, err = pool.Exec(ctx, UpdateUserLastIPAddress, user.ProfileId, ipAddress, ingamesn)
+	err = exec(pool, ctx, "UpdateUserLastIPAddress", UpdateUserLastIPAddress, user.ProfileId, ipAddress, ingamesn)
 	if err != nil {
 		return User{}, err
 	}
@@ -105,7 +123,10 @@ func LoginUserToGPCM(pool *pgxpool.Pool, ctx context.Context, userId uint64, gsb
 	var banTOS bool
 	var bannedDeviceId uint32
 	timeNow := time.Now()
-	err = pool.QueryRow(ctx, SearchUserBan, user.ProfileId, user.NgDeviceId, ipAddress, timeNow).Scan(&banExists, &banTOS, &bannedDeviceId)
+	if banDeviceId == 0 {
+		banDeviceId = user.NgDeviceId
+	}
+	err = queryRow(pool, ctx, "SearchUserBan", SearchUserBan, user.ProfileId, banDeviceId, ipAddress, timeNow).Scan(&banExists, &banTOS, &bannedDeviceId)
 	if err != nil {
 		if err != pgx.ErrNoRows {
 			return User{}, err
@@ -137,7 +158,7 @@ func LoginUserToGameStats(pool *pgxpool.Pool, ctx context.Context, userId uint64
 	var expectedNgId *uint32
 	var firstName *string
 	var lastName *string
-	err := pool.QueryRow(ctx, GetUserProfileID, userId, gsbrcd).Scan(&user.ProfileId, &expectedNgId, &user.Email, &user.UniqueNick, &firstName, &lastName)
+	err := queryRow(pool, ctx, "GetUserProfileID", GetUserProfileID, userId, gsbrcd).Scan(&user.ProfileId, &expectedNgId, &user.Email, &user.UniqueNick, &firstName, &lastName)
 	if err != nil {
 		return User{}, err
 	}
@@ -0,0 +1,405 @@
+package database
+
+import (
+	"errors"
+	"sync"
+	"time"
+	"wwfc/common"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+type memoryUser struct {
+	User
+	LastIPAddress  string
+	LastInGameName string
+	MKWFriendInfo  string
+	NamecardType   string
+	Namecard       []byte
+	BlockedIds     []uint32
+	Settings       map[string]string
+
+	HasBan     bool
+	BanTOS     bool
+	BanExpires time.Time
+}
+
+// MemoryStore is an in-memory Store for tests. It follows the same rules as
+// the PostgreSQL queries but nothing is persisted.
+type MemoryStore struct {
+	mutex         sync.Mutex
+	users         map[uint32]*memoryUser
+	nextProfileId uint32
+	natnegStats   map[string]NATNEGStat
+	natnegEvents  []NATNEGSessionEvent
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:         map[uint32]*memoryUser{},
+		nextProfileId: 1,
+		natnegStats:   map[string]NATNEGStat{},
+	}
+}
+
+// Insert a user directly, replacing any user with the same profile ID
+func (s *MemoryStore) InsertUser(user User) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.users[user.ProfileId] = &memoryUser{User: user}
+	if user.ProfileId >= s.nextProfileId {
+		s.nextProfileId = user.ProfileId + 1
+	}
+}
+
+func (s *MemoryStore) findUser(userId uint64, gsbrcd string) *memoryUser {
+	for _, user := range s.users {
+		if user.UserId == userId && user.GsbrCode == gsbrcd {
+			return user
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) createUser(user *User) error {
+	if user.ProfileId == 0 {
+		for s.users[s.nextProfileId] != nil {
+			s.nextProfileId++
+		}
+		user.ProfileId = s.nextProfileId
+	} else if user.ProfileId >= 1000000000 {
+		return ErrReservedProfileIDRange
+	} else if s.users[user.ProfileId] != nil {
+		return ErrProfileIDInUse
+	}
+
+	s.users[user.ProfileId] = &memoryUser{User: *user}
+	return nil
+}
+
+func (s *MemoryStore) LoginUserToGPCM(userId uint64, gsbrcd string, profileId uint32, ngDeviceId uint32, ipAddress string, ingamesn string) (User, error) {
+	return s.loginUserToGPCM(userId, gsbrcd, profileId, ngDeviceId, ipAddress, ingamesn, false)
+}
+
+func (s *MemoryStore) LoginUserToGPCMFromOtherDevice(userId uint64, gsbrcd string, profileId uint32, ngDeviceId uint32, ipAddress string, ingamesn string) (User, error) {
+	return s.loginUserToGPCM(userId, gsbrcd, profileId, ngDeviceId, ipAddress, ingamesn, true)
+}
+
+func (s *MemoryStore) loginUserToGPCM(userId uint64, gsbrcd string, profileId uint32, ngDeviceId uint32, ipAddress string, ingamesn string, allowDeviceMismatch bool) (User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	banDeviceId := uint32(0)
+
+	stored := s.findUser(userId, gsbrcd)
+	if stored == nil {
+		user := User{
+			UserId:     userId,
+			GsbrCode:   gsbrcd,
+			ProfileId:  profileId,
+			NgDeviceId: ngDeviceId,
+			UniqueNick: common.Base32Encode(userId) + gsbrcd,
+		}
+		user.Email = user.UniqueNick + "@nds"
+
+		if err := s.createUser(&user); err != nil {
+			return User{}, err
+		}
+
+		stored = s.users[user.ProfileId]
+	} else {
+		if stored.NgDeviceId != 0 {
+			if ngDeviceId != 0 && stored.NgDeviceId != ngDeviceId {
+				if !allowDeviceMismatch {
+					return User{}, ErrDeviceIDMismatch
+				}
+				banDeviceId = ngDeviceId
+			}
+		} else if ngDeviceId != 0 {
+			stored.NgDeviceId = ngDeviceId
+		}
+
+		if profileId != 0 && stored.ProfileId != profileId && profileId < 1000000000 && s.users[profileId] == nil {
+			delete(s.users, stored.ProfileId)
+			stored.ProfileId = profileId
+			s.users[profileId] = stored
+		}
+	}
+
+	// This should be set if the user already knows its own profile ID
+	if profileId != 0 && stored.LastName == "" {
+		stored.LastName = "000000000" + gsbrcd
+	}
+
+	stored.LastIPAddress = ipAddress
+	stored.LastInGameName = ingamesn
+
+	user := stored.User
+	user.Restricted = false
+	user.RestrictedDeviceId = 0
+
+	// Find ban from device ID or IP address, TOS bans first
+	if banDeviceId == 0 {
+		banDeviceId = user.NgDeviceId
+	}
+	var ban *memoryUser
+	for _, other := range s.users {
+		if !other.HasBan || (!other.BanExpires.IsZero() && !other.BanExpires.After(time.Now())) {
+			continue
+		}
+
+		if other.ProfileId != user.ProfileId && (banDeviceId == 0 || other.NgDeviceId != banDeviceId) && other.LastIPAddress != ipAddress {
+			continue
+		}
+
+		if ban == nil || (other.BanTOS && !ban.BanTOS) {
+			ban = other
+		}
+	}
+
+	if ban != nil {
+		if ban.BanTOS {
+			return User{RestrictedDeviceId: ban.NgDeviceId}, ErrProfileBannedTOS
+		}
+
+		user.Restricted = true
+		user.RestrictedDeviceId = ban.NgDeviceId
+	}
+
+	return user, nil
+}
+
+func (s *MemoryStore) LoginUserToGameStats(userId uint64, gsbrcd string) (User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if stored := s.findUser(userId, gsbrcd); stored != nil {
+		return stored.User, nil
+	}
+
+	return User{}, ErrUserNotFound
+}
+
+func (s *MemoryStore) GetProfile(profileId uint32) (User, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if stored, exists := s.users[profileId]; exists {
+		return stored.User, true
+	}
+
+	return User{}, false
+}
+
+func (s *MemoryStore) UpdateProfile(user *User, data map[string]string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored := s.users[user.ProfileId]
+
+	if firstName, exists := data["firstname"]; exists {
+		user.FirstName = firstName
+		if stored != nil {
+			stored.FirstName = firstName
+		}
+	}
+
+	if lastName, exists := data["lastname"]; exists {
+		user.LastName = lastName
+		if stored != nil {
+			stored.LastName = lastName
+		}
+	}
+}
+
+func (s *MemoryStore) BanUser(profileId uint32, tos bool, length time.Duration, reason string, reasonHidden string, moderator string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, exists := s.users[profileId]
+	if !exists {
+		return false
+	}
+
+	stored.HasBan = true
+	stored.BanTOS = tos
+	stored.BanExpires = time.Now().Add(length)
+	return true
+}
+
+func (s *MemoryStore) UnbanUser(profileId uint32) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, exists := s.users[profileId]
+	if !exists {
+		return false
+	}
+
+	stored.HasBan = false
+	return true
+}
+
+func (s *MemoryStore) GetMKWFriendInfo(profileId uint32) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if stored, exists := s.users[profileId]; exists {
+		return stored.MKWFriendInfo
+	}
+
+	return ""
+}
+
+func (s *MemoryStore) UpdateMKWFriendInfo(profileId uint32, info string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if stored, exists := s.users[profileId]; exists {
+		stored.MKWFriendInfo = info
+	}
+}
+
+func (s *MemoryStore) GetNamecard(profileId uint32) (string, []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if stored, exists := s.users[profileId]; exists {
+		return stored.NamecardType, stored.Namecard
+	}
+
+	return "", nil
+}
+
+func (s *MemoryStore) UpdateNamecard(profileId uint32, contentType string, data []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if stored, exists := s.users[profileId]; exists {
+		stored.NamecardType = contentType
+		stored.Namecard = append([]byte(nil), data...)
+	}
+}
+
+func (s *MemoryStore) GetBlockedProfiles(profileId uint32) []uint32 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if stored, exists := s.users[profileId]; exists {
+		return append([]uint32{}, stored.BlockedIds...)
+	}
+
+	return []uint32{}
+}
+
+func (s *MemoryStore) BlockProfile(profileId uint32, blockedId uint32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if stored, exists := s.users[profileId]; exists {
+		stored.BlockedIds = append(removeProfileId(stored.BlockedIds, blockedId), blockedId)
+	}
+}
+
+func (s *MemoryStore) UnblockProfile(profileId uint32, blockedId uint32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if stored, exists := s.users[profileId]; exists {
+		stored.BlockedIds = removeProfileId(stored.BlockedIds, blockedId)
+	}
+}
+
+func removeProfileId(profileIds []uint32, profileId uint32) []uint32 {
+	kept := []uint32{}
+	for _, id := range profileIds {
+		if id != profileId {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+func (s *MemoryStore) GetNATNEGStats() map[string]NATNEGStat {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stats := map[string]NATNEGStat{}
+	for stat, value := range s.natnegStats {
+		stats[stat] = value
+	}
+	return stats
+}
+
+func (s *MemoryStore) SaveNATNEGStats(stats map[string]NATNEGStat) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for stat, value := range stats {
+		s.natnegStats[stat] = value
+	}
+}
+
+func (s *MemoryStore) GetNATNEGSessionEvents(limit int) []NATNEGSessionEvent {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	events := []NATNEGSessionEvent{}
+	for i := len(s.natnegEvents) - 1; i >= 0 && len(events) < limit; i-- {
+		events = append(events, s.natnegEvents[i])
+	}
+	return events
+}
+
+func (s *MemoryStore) SaveNATNEGSessionEvents(events []NATNEGSessionEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.natnegEvents = append(s.natnegEvents, events...)
+}
+
+func (s *MemoryStore) ImportUser(user User) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.findUser(user.UserId, user.GsbrCode) != nil {
+		return ErrUserExists
+	}
+
+	return s.createUser(&user)
+}
+
+func (s *MemoryStore) GetProfileSettings(profileId uint32) map[string]string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	settings := map[string]string{}
+	if stored, exists := s.users[profileId]; exists {
+		for key, value := range stored.Settings {
+			settings[key] = value
+		}
+	}
+	return settings
+}
+
+func (s *MemoryStore) SetProfileSetting(profileId uint32, key string, value string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, exists := s.users[profileId]
+	if !exists {
+		return
+	}
+
+	if value == "" {
+		delete(stored.Settings, key)
+		return
+	}
+
+	if stored.Settings == nil {
+		stored.Settings = map[string]string{}
+	}
+	stored.Settings[key] = value
+}
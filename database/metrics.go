@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+	"wwfc/logging"
+	"wwfc/metrics"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/logrusorgru/aurora/v3"
+)
+
+type QueryStats struct {
+	Count     uint64
+	TotalTime time.Duration
+	MaxTime   time.Duration
+}
+
+var (
+	queryStats      = map[string]*QueryStats{}
+	queryStatsMutex = sync.Mutex{}
+
+	// Queries taking longer than this are logged, 0 to disable
+	slowQueryThreshold time.Duration
+)
+
+func SetSlowQueryThreshold(threshold time.Duration) {
+	slowQueryThreshold = threshold
+}
+
+// Get a copy of the statistics for each query label
+func GetQueryStats() map[string]QueryStats {
+	queryStatsMutex.Lock()
+	defer queryStatsMutex.Unlock()
+
+	stats := map[string]QueryStats{}
+	for label, stat := range queryStats {
+		stats[label] = *stat
+	}
+
+	return stats
+}
+
+func recordQuery(label string, duration time.Duration) {
+	queryStatsMutex.Lock()
+	stat, exists := queryStats[label]
+	if !exists {
+		stat = &QueryStats{}
+		queryStats[label] = stat
+	}
+
+	stat.Count++
+	stat.TotalTime += duration
+	if duration > stat.MaxTime {
+		stat.MaxTime = duration
+	}
+	queryStatsMutex.Unlock()
+
+	metrics.Counter("database_queries_total", 1, "query", label)
+	metrics.Histogram("database_query_seconds", duration.Seconds(), "query", label)
+
+	if slowQueryThreshold > 0 && duration >= slowQueryThreshold {
+		logging.Warn("DATABASE", "Slow query", aurora.Cyan(label), "took", aurora.BrightRed(duration.Round(time.Millisecond)))
+	}
+}
+
+// Run a query and record how long it took under the label
+func trackQuery(label string, query func() error) error {
+	start := time.Now()
+	err := query()
+	recordQuery(label, time.Since(start))
+	return err
+}
+
+type trackedRow struct {
+	row   pgx.Row
+	label string
+	start time.Time
+}
+
+// The query result is only read on Scan, so the time is recorded there
+func (r trackedRow) Scan(dest ...any) error {
+	err := r.row.Scan(dest...)
+	recordQuery(r.label, time.Since(r.start))
+	return err
+}
+
+func queryRow(pool *pgxpool.Pool, ctx context.Context, label string, sql string, args ...any) pgx.Row {
+	return trackedRow{row: pool.QueryRow(ctx, sql, args...), label: label, start: time.Now()}
+}
+
+func exec(pool *pgxpool.Pool, ctx context.Context, label string, sql string, args ...any) error {
+	return trackQuery(label, func() error {
+		_, err := pool.Exec(ctx, sql, args...)
+		return err
+	})
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"wwfc/logging"
+	"wwfc/metrics"
+)
+
+func TestSlowQueryLogged(t *testing.T) {
+	queryStatsMutex.Lock()
+	queryStats = map[string]*QueryStats{}
+	queryStatsMutex.Unlock()
+
+	sink := metrics.NewMemorySink()
+	previousSink := metrics.GetSink()
+	metrics.SetSink(sink)
+	defer metrics.SetSink(previousSink)
+
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+	logging.SetLevel(4)
+
+	SetSlowQueryThreshold(10 * time.Millisecond)
+	defer SetSlowQueryThreshold(0)
+
+	trackQuery("FastQuery", func() error { return nil })
+	if strings.Contains(output.String(), "Slow query") {
+		t.Fatalf("fast query was logged as slow: %s", output.String())
+	}
+
+	trackQuery("SlowQuery", func() error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	if !strings.Contains(output.String(), "Slow query") || !strings.Contains(output.String(), "SlowQuery") {
+		t.Fatalf("slow query was not logged: %s", output.String())
+	}
+
+	stats := GetQueryStats()
+	if stats["SlowQuery"].Count != 1 || stats["SlowQuery"].MaxTime < 20*time.Millisecond {
+		t.Fatalf("slow query not recorded: %+v", stats["SlowQuery"])
+	}
+
+	if count := sink.GetCounter("database_queries_total", "query", "SlowQuery"); count != 1 {
+		t.Fatalf("expected the slow query to be counted once, got %v", count)
+	}
+	if histogram := sink.GetHistogram("database_query_seconds", "query", "SlowQuery"); histogram.Count != 1 || histogram.Sum < 0.02 {
+		t.Fatalf("slow query latency not observed: %+v", histogram)
+	}
+}
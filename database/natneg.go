@@ -0,0 +1,124 @@
+package database
+
+import (
+	"context"
+	"time"
+	"wwfc/logging"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Aggregate NATNEG outcome counts for one statistic, kept across restarts
+type NATNEGStat struct {
+	Successes uint64
+	Failures  uint64
+}
+
+// One NATNEG session from creation to deletion. The cookie is hashed, as it
+// is only needed to tell sessions apart.
+type NATNEGSessionEvent struct {
+	CookieHash string
+	GameName   string
+	Clients    int
+	Outcome    string
+	Created    time.Time
+	Deleted    time.Time
+}
+
+const (
+	GetNATNEGStatsQuery  = `SELECT stat, successes, failures FROM natneg_stats`
+	SaveNATNEGStatsQuery = `INSERT INTO natneg_stats (stat, successes, failures) VALUES ($1, $2, $3) ON CONFLICT (stat) DO UPDATE SET successes = $2, failures = $3`
+
+	GetNATNEGSessionEventsQuery  = `SELECT cookie_hash, game, clients, outcome, created, deleted FROM natneg_session_events ORDER BY deleted DESC LIMIT $1`
+	SaveNATNEGSessionEventsQuery = `INSERT INTO natneg_session_events (cookie_hash, game, clients, outcome, created, deleted) SELECT * FROM unnest($1::varchar[], $2::varchar[], $3::integer[], $4::varchar[], $5::timestamp[], $6::timestamp[])`
+)
+
+func GetNATNEGStats(pool *pgxpool.Pool, ctx context.Context) map[string]NATNEGStat {
+	stats := map[string]NATNEGStat{}
+
+	err := trackQuery("GetNATNEGStatsQuery", func() error {
+		rows, err := pool.Query(ctx, GetNATNEGStatsQuery)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var stat string
+			var successes, failures int64
+			if err := rows.Scan(&stat, &successes, &failures); err != nil {
+				return err
+			}
+
+			stats[stat] = NATNEGStat{Successes: uint64(successes), Failures: uint64(failures)}
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		logging.Error("DATABASE", "Failed to get NATNEG stats:", err.Error())
+	}
+
+	return stats
+}
+
+func SaveNATNEGStats(pool *pgxpool.Pool, ctx context.Context, stats map[string]NATNEGStat) {
+	for stat, value := range stats {
+		err := exec(pool, ctx, "SaveNATNEGStatsQuery", SaveNATNEGStatsQuery, stat, int64(value.Successes), int64(value.Failures))
+		if err != nil {
+			logging.Error("DATABASE", "Failed to save NATNEG stat", stat+":", err.Error())
+		}
+	}
+}
+
+// Get the most recently deleted sessions, newest first
+func GetNATNEGSessionEvents(pool *pgxpool.Pool, ctx context.Context, limit int) []NATNEGSessionEvent {
+	events := []NATNEGSessionEvent{}
+
+	err := trackQuery("GetNATNEGSessionEventsQuery", func() error {
+		rows, err := pool.Query(ctx, GetNATNEGSessionEventsQuery, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var event NATNEGSessionEvent
+			var clients int32
+			if err := rows.Scan(&event.CookieHash, &event.GameName, &clients, &event.Outcome, &event.Created, &event.Deleted); err != nil {
+				return err
+			}
+
+			event.Clients = int(clients)
+			events = append(events, event)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		logging.Error("DATABASE", "Failed to get NATNEG session events:", err.Error())
+	}
+
+	return events
+}
+
+// Insert a batch of session events in one query
+func SaveNATNEGSessionEvents(pool *pgxpool.Pool, ctx context.Context, events []NATNEGSessionEvent) {
+	cookieHashes := make([]string, len(events))
+	games := make([]string, len(events))
+	clients := make([]int32, len(events))
+	outcomes := make([]string, len(events))
+	created := make([]time.Time, len(events))
+	deleted := make([]time.Time, len(events))
+	for i, event := range events {
+		cookieHashes[i] = event.CookieHash
+		games[i] = event.GameName
+		clients[i] = int32(event.Clients)
+		outcomes[i] = event.Outcome
+		created[i] = event.Created
+		deleted[i] = event.Deleted
+	}
+
+	err := exec(pool, ctx, "SaveNATNEGSessionEventsQuery", SaveNATNEGSessionEventsQuery, cookieHashes, games, clients, outcomes, created, deleted)
+	if err != nil {
+		logging.Error("DATABASE", "Failed to save", len(events), "NATNEG session events:", err.Error())
+	}
+}
@@ -17,6 +17,37 @@ ALTER TABLE ONLY public.users
 	ADD IF NOT EXISTS ban_reason character varying,
 	ADD IF NOT EXISTS ban_reason_hidden character varying,
 	ADD IF NOT EXISTS ban_moderator character varying,
-	ADD IF NOT EXISTS ban_tos boolean
+	ADD IF NOT EXISTS ban_tos boolean,
+	ADD IF NOT EXISTS namecard bytea,
+	ADD IF NOT EXISTS namecard_type character varying DEFAULT ''::character varying,
+	ADD IF NOT EXISTS blocked_profiles bigint[] DEFAULT '{}'::bigint[]
+`)
+
+	pool.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS public.natneg_stats (
+	stat character varying NOT NULL PRIMARY KEY,
+	successes bigint DEFAULT 0 NOT NULL,
+	failures bigint DEFAULT 0 NOT NULL
+)
+`)
+
+	pool.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS public.natneg_session_events (
+	cookie_hash character varying NOT NULL,
+	game character varying NOT NULL,
+	clients integer NOT NULL,
+	outcome character varying NOT NULL,
+	created timestamp without time zone NOT NULL,
+	deleted timestamp without time zone NOT NULL
+)
+`)
+
+	pool.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS public.profile_settings (
+	profile_id bigint NOT NULL,
+	key character varying NOT NULL,
+	value character varying NOT NULL,
+	PRIMARY KEY (profile_id, key)
+)
 `)
 }
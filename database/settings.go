@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"wwfc/logging"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	GetProfileSettingsQuery   = `SELECT key, value FROM profile_settings WHERE profile_id = $1`
+	SetProfileSettingQuery    = `INSERT INTO profile_settings (profile_id, key, value) VALUES ($1, $2, $3) ON CONFLICT (profile_id, key) DO UPDATE SET value = $3`
+	DeleteProfileSettingQuery = `DELETE FROM profile_settings WHERE profile_id = $1 AND key = $2`
+)
+
+func GetProfileSettings(pool *pgxpool.Pool, ctx context.Context, profileId uint32) map[string]string {
+	settings := map[string]string{}
+
+	err := trackQuery("GetProfileSettingsQuery", func() error {
+		rows, err := pool.Query(ctx, GetProfileSettingsQuery, profileId)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var key, value string
+			if err := rows.Scan(&key, &value); err != nil {
+				return err
+			}
+
+			settings[key] = value
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		logging.Error("DATABASE", "Failed to get profile settings:", err.Error())
+	}
+
+	return settings
+}
+
+// Set a profile setting, or delete it if the value is empty
+func SetProfileSetting(pool *pgxpool.Pool, ctx context.Context, profileId uint32, key string, value string) {
+	var err error
+	if value == "" {
+		err = exec(pool, ctx, "DeleteProfileSettingQuery", DeleteProfileSettingQuery, profileId, key)
+	} else {
+		err = exec(pool, ctx, "SetProfileSettingQuery", SetProfileSettingQuery, profileId, key, value)
+	}
+
+	if err != nil {
+		logging.Error("DATABASE", "Failed to set profile setting:", err.Error())
+	}
+}
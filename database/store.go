@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Store is the user storage used by the servers. PostgresStore is used in
+// production and MemoryStore can be used in tests.
+type Store interface {
+	LoginUserToGPCM(userId uint64, gsbrcd string, profileId uint32, ngDeviceId uint32, ipAddress string, ingamesn string) (User, error)
+	LoginUserToGPCMFromOtherDevice(userId uint64, gsbrcd string, profileId uint32, ngDeviceId uint32, ipAddress string, ingamesn string) (User, error)
+	LoginUserToGameStats(userId uint64, gsbrcd string) (User, error)
+	GetProfile(profileId uint32) (User, bool)
+	UpdateProfile(user *User, data map[string]string)
+	BanUser(profileId uint32, tos bool, length time.Duration, reason string, reasonHidden string, moderator string) bool
+	UnbanUser(profileId uint32) bool
+	GetMKWFriendInfo(profileId uint32) string
+	UpdateMKWFriendInfo(profileId uint32, info string)
+	GetNamecard(profileId uint32) (string, []byte)
+	UpdateNamecard(profileId uint32, contentType string, data []byte)
+	GetBlockedProfiles(profileId uint32) []uint32
+	BlockProfile(profileId uint32, blockedId uint32)
+	UnblockProfile(profileId uint32, blockedId uint32)
+	GetNATNEGStats() map[string]NATNEGStat
+	SaveNATNEGStats(stats map[string]NATNEGStat)
+	GetNATNEGSessionEvents(limit int) []NATNEGSessionEvent
+	SaveNATNEGSessionEvents(events []NATNEGSessionEvent)
+	ImportUser(user User) error
+	GetProfileSettings(profileId uint32) map[string]string
+	SetProfileSetting(profileId uint32, key string, value string)
+}
+
+type PostgresStore struct {
+	Pool *pgxpool.Pool
+	Ctx  context.Context
+}
+
+func NewPostgresStore(pool *pgxpool.Pool, ctx context.Context) *PostgresStore {
+	return &PostgresStore{Pool: pool, Ctx: ctx}
+}
+
+func (s *PostgresStore) LoginUserToGPCM(userId uint64, gsbrcd string, profileId uint32, ngDeviceId uint32, ipAddress string, ingamesn string) (User, error) {
+	return LoginUserToGPCM(s.Pool, s.Ctx, userId, gsbrcd, profileId, ngDeviceId, ipAddress, ingamesn)
+}
+
+func (s *PostgresStore) LoginUserToGPCMFromOtherDevice(userId uint64, gsbrcd string, profileId uint32, ngDeviceId uint32, ipAddress string, ingamesn string) (User, error) {
+	return LoginUserToGPCMFromOtherDevice(s.Pool, s.Ctx, userId, gsbrcd, profileId, ngDeviceId, ipAddress, ingamesn)
+}
+
+func (s *PostgresStore) LoginUserToGameStats(userId uint64, gsbrcd string) (User, error) {
+	return LoginUserToGameStats(s.Pool, s.Ctx, userId, gsbrcd)
+}
+
+func (s *PostgresStore) GetProfile(profileId uint32) (User, bool) {
+	return GetProfile(s.Pool, s.Ctx, profileId)
+}
+
+func (s *PostgresStore) UpdateProfile(user *User, data map[string]string) {
+	user.UpdateProfile(s.Pool, s.Ctx, data)
+}
+
+func (s *PostgresStore) BanUser(profileId uint32, tos bool, length time.Duration, reason string, reasonHidden string, moderator string) bool {
+	return BanUser(s.Pool, s.Ctx, profileId, tos, length, reason, reasonHidden, moderator)
+}
+
+func (s *PostgresStore) UnbanUser(profileId uint32) bool {
+	return UnbanUser(s.Pool, s.Ctx, profileId)
+}
+
+func (s *PostgresStore) GetMKWFriendInfo(profileId uint32) string {
+	return GetMKWFriendInfo(s.Pool, s.Ctx, profileId)
+}
+
+func (s *PostgresStore) UpdateMKWFriendInfo(profileId uint32, info string) {
+	UpdateMKWFriendInfo(s.Pool, s.Ctx, profileId, info)
+}
+
+func (s *PostgresStore) GetNamecard(profileId uint32) (string, []byte) {
+	return GetNamecard(s.Pool, s.Ctx, profileId)
+}
+
+func (s *PostgresStore) UpdateNamecard(profileId uint32, contentType string, data []byte) {
+	UpdateNamecard(s.Pool, s.Ctx, profileId, contentType, data)
+}
+
+func (s *PostgresStore) GetBlockedProfiles(profileId uint32) []uint32 {
+	return GetBlockedProfiles(s.Pool, s.Ctx, profileId)
+}
+
+func (s *PostgresStore) BlockProfile(profileId uint32, blockedId uint32) {
+	BlockProfile(s.Pool, s.Ctx, profileId, blockedId)
+}
+
+func (s *PostgresStore) UnblockProfile(profileId uint32, blockedId uint32) {
+	UnblockProfile(s.Pool, s.Ctx, profileId, blockedId)
+}
+
+func (s *PostgresStore) GetNATNEGStats() map[string]NATNEGStat {
+	return GetNATNEGStats(s.Pool, s.Ctx)
+}
+
+func (s *PostgresStore) SaveNATNEGStats(stats map[string]NATNEGStat) {
+	SaveNATNEGStats(s.Pool, s.Ctx, stats)
+}
+
+func (s *PostgresStore) GetNATNEGSessionEvents(limit int) []NATNEGSessionEvent {
+	return GetNATNEGSessionEvents(s.Pool, s.Ctx, limit)
+}
+
+func (s *PostgresStore) SaveNATNEGSessionEvents(events []NATNEGSessionEvent) {
+	SaveNATNEGSessionEvents(s.Pool, s.Ctx, events)
+}
+
+func (s *PostgresStore) ImportUser(user User) error {
+	return ImportUser(s.Pool, s.Ctx, user)
+}
+
+func (s *PostgresStore) GetProfileSettings(profileId uint32) map[string]string {
+	return GetProfileSettings(s.Pool, s.Ctx, profileId)
+}
+
+func (s *PostgresStore) SetProfileSetting(profileId uint32, key string, value string) {
+	SetProfileSetting(s.Pool, s.Ctx, profileId, key, value)
+}
@@ -27,6 +27,13 @@ const (
 
 	GetMKWFriendInfoQuery    = `SELECT mariokartwii_friend_info FROM users WHERE profile_id = $1`
 	UpdateMKWFriendInfoQuery = `UPDATE users SET mariokartwii_friend_info = $2 WHERE profile_id = $1`
+
+	GetNamecardQuery    = `SELECT namecard_type, namecard FROM users WHERE profile_id = $1`
+	UpdateNamecardQuery = `UPDATE users SET namecard_type = $2, namecard = $3 WHERE profile_id = $1`
+
+	GetBlockedProfilesQuery = `SELECT blocked_profiles FROM users WHERE profile_id = $1`
+	BlockProfileQuery       = `UPDATE users SET blocked_profiles = array_append(array_remove(coalesce(blocked_profiles, '{}'), $2), $2) WHERE profile_id = $1`
+	UnblockProfileQuery     = `UPDATE users SET blocked_profiles = array_remove(blocked_profiles, $2) WHERE profile_id = $1`
 )
 
 type User struct {
@@ -49,7 +56,7 @@ var (
 
 func (user *User) CreateUser(pool *pgxpool.Pool, ctx context.Context) error {
 	if user.ProfileId == 0 {
-		return pool.QueryRow(ctx, InsertUser, user.UserId, user.GsbrCode, "", user.NgDeviceId, user.Email, user.UniqueNick).Scan(&user.ProfileId)
+		return queryRow(pool, ctx, "InsertUser", InsertUser, user.UserId, user.GsbrCode, "", user.NgDeviceId, user.Email, user.UniqueNick).Scan(&user.ProfileId)
 	}
 
 	if user.ProfileId >= 1000000000 {
@@ -57,7 +64,7 @@ func (user *User) CreateUser(pool *pgxpool.Pool, ctx context.Context) error {
 	}
 
 	var exists bool
-	err := pool.QueryRow(ctx, IsProfileIDInUse, user.ProfileId).Scan(&exists)
+	err := queryRow(pool, ctx, "IsProfileIDInUse", IsProfileIDInUse, user.ProfileId).Scan(&exists)
 	if err != nil {
 		return err
 	}
@@ -66,7 +73,7 @@ func (user *User) CreateUser(pool *pgxpool.Pool, ctx context.Context) error {
 		return ErrProfileIDInUse
 	}
 
-	_, err = pool.Exec(ctx, InsertUserWithProfileID, user.ProfileId, user.UserId, user.GsbrCode, "", user.NgDeviceId, user.Email, user.UniqueNick)
+	err = exec(pool, ctx, "InsertUserWithProfileID", InsertUserWithProfileID, user.ProfileId, user.UserId, user.GsbrCode, "", user.NgDeviceId, user.Email, user.UniqueNick)
 	return err
 }
 
@@ -76,7 +83,7 @@ func (user *User) UpdateProfileID(pool *pgxpool.Pool, ctx context.Context, newPr
 	}
 
 	var exists bool
-	err := pool.QueryRow(ctx, IsProfileIDInUse, newProfileId).Scan(&exists)
+	err := queryRow(pool, ctx, "IsProfileIDInUse", IsProfileIDInUse, newProfileId).Scan(&exists)
 	if err != nil {
 		return err
 	}
@@ -85,7 +92,7 @@ func (user *User) UpdateProfileID(pool *pgxpool.Pool, ctx context.Context, newPr
 		return ErrProfileIDInUse
 	}
 
-	_, err = pool.Exec(ctx, UpdateUserProfileID, user.UserId, user.GsbrCode, newProfileId)
+	err = exec(pool, ctx, "UpdateUserProfileID", UpdateUserProfileID, user.UserId, user.GsbrCode, newProfileId)
 	if err == nil {
 		user.ProfileId = newProfileId
 	}
@@ -94,7 +101,7 @@ func (user *User) UpdateProfileID(pool *pgxpool.Pool, ctx context.Context, newPr
 }
 
 func (user *User) UpdateDeviceID(pool *pgxpool.Pool, ctx context.Context, newDeviceId uint32) error {
-	_, err := pool.Exec(ctx, UpdateUserNGDeviceID, user.ProfileId, newDeviceId)
+	err := exec(pool, ctx, "UpdateUserNGDeviceID", UpdateUserNGDeviceID, user.ProfileId, newDeviceId)
 	if err == nil {
 		user.NgDeviceId = newDeviceId
 	}
@@ -111,7 +118,7 @@ func (user *User) UpdateProfile(pool *pgxpool.Pool, ctx context.Context, data ma
 	firstName, firstNameExists := data["firstname"]
 	lastName, lastNameExists := data["lastname"]
 
-	_, err := pool.Exec(ctx, UpdateUserTable, user.ProfileId, firstName, firstNameExists, lastName, lastNameExists)
+	err := exec(pool, ctx, "UpdateUserTable", UpdateUserTable, user.ProfileId, firstName, firstNameExists, lastName, lastNameExists)
 	if err != nil {
 		panic(err)
 	}
@@ -127,7 +134,7 @@ func (user *User) UpdateProfile(pool *pgxpool.Pool, ctx context.Context, data ma
 
 func GetProfile(pool *pgxpool.Pool, ctx context.Context, profileId uint32) (User, bool) {
 	user := User{}
-	row := pool.QueryRow(ctx, GetUser, profileId)
+	row := queryRow(pool, ctx, "GetUser", GetUser, profileId)
 	err := row.Scan(&user.UserId, &user.GsbrCode, &user.Email, &user.UniqueNick, &user.FirstName, &user.LastName)
 	if err != nil {
 		return User{}, false
@@ -138,7 +145,7 @@ func GetProfile(pool *pgxpool.Pool, ctx context.Context, profileId uint32) (User
 }
 
 func BanUser(pool *pgxpool.Pool, ctx context.Context, profileId uint32, tos bool, length time.Duration, reason string, reasonHidden string, moderator string) bool {
-	_, err := pool.Exec(ctx, UpdateUserBan, profileId, time.Now(), time.Now().Add(length), reason, reasonHidden, moderator, tos)
+	err := exec(pool, ctx, "UpdateUserBan", UpdateUserBan, profileId, time.Now(), time.Now().Add(length), reason, reasonHidden, moderator, tos)
 	if err != nil {
 		return false
 	}
@@ -147,7 +154,7 @@ func BanUser(pool *pgxpool.Pool, ctx context.Context, profileId uint32, tos bool
 }
 
 func UnbanUser(pool *pgxpool.Pool, ctx context.Context, profileId uint32) bool {
-	_, err := pool.Exec(ctx, DisableUserBan, profileId)
+	err := exec(pool, ctx, "DisableUserBan", DisableUserBan, profileId)
 	if err != nil {
 		return false
 	}
@@ -157,7 +164,7 @@ func UnbanUser(pool *pgxpool.Pool, ctx context.Context, profileId uint32) bool {
 
 func GetMKWFriendInfo(pool *pgxpool.Pool, ctx context.Context, profileId uint32) string {
 	var info string
-	err := pool.QueryRow(ctx, GetMKWFriendInfoQuery, profileId).Scan(&info)
+	err := queryRow(pool, ctx, "GetMKWFriendInfoQuery", GetMKWFriendInfoQuery, profileId).Scan(&info)
 	if err != nil {
 		return ""
 	}
@@ -166,7 +173,53 @@ func GetMKWFriendInfo(pool *pgxpool.Pool, ctx context.Context, profileId uint32)
 }
 
 func UpdateMKWFriendInfo(pool *pgxpool.Pool, ctx context.Context, profileId uint32, info string) {
-	_, err := pool.Exec(ctx, UpdateMKWFriendInfoQuery, profileId, info)
+	err := exec(pool, ctx, "UpdateMKWFriendInfoQuery", UpdateMKWFriendInfoQuery, profileId, info)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func GetNamecard(pool *pgxpool.Pool, ctx context.Context, profileId uint32) (string, []byte) {
+	var contentType *string
+	var data []byte
+	err := queryRow(pool, ctx, "GetNamecardQuery", GetNamecardQuery, profileId).Scan(&contentType, &data)
+	if err != nil || contentType == nil {
+		return "", nil
+	}
+
+	return *contentType, data
+}
+
+func UpdateNamecard(pool *pgxpool.Pool, ctx context.Context, profileId uint32, contentType string, data []byte) {
+	err := exec(pool, ctx, "UpdateNamecardQuery", UpdateNamecardQuery, profileId, contentType, data)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func GetBlockedProfiles(pool *pgxpool.Pool, ctx context.Context, profileId uint32) []uint32 {
+	var blocked []int64
+	err := queryRow(pool, ctx, "GetBlockedProfilesQuery", GetBlockedProfilesQuery, profileId).Scan(&blocked)
+	if err != nil {
+		return []uint32{}
+	}
+
+	profileIds := make([]uint32, 0, len(blocked))
+	for _, blockedId := range blocked {
+		profileIds = append(profileIds, uint32(blockedId))
+	}
+	return profileIds
+}
+
+func BlockProfile(pool *pgxpool.Pool, ctx context.Context, profileId uint32, blockedId uint32) {
+	err := exec(pool, ctx, "BlockProfileQuery", BlockProfileQuery, profileId, int64(blockedId))
+	if err != nil {
+		panic(err)
+	}
+}
+
+func UnblockProfile(pool *pgxpool.Pool, ctx context.Context, profileId uint32, blockedId uint32) {
+	err := exec(pool, ctx, "UnblockProfileQuery", UnblockProfileQuery, profileId, int64(blockedId))
 	if err != nil {
 		panic(err)
 	}
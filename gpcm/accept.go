@@ -0,0 +1,92 @@
+package gpcm
+
+import (
+	"net"
+	"time"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// How long an accepted connection may wait for a free slot before it is rejected
+const connectionQueueTimeout = 2 * time.Second
+
+// How long a login may wait for a free database slot before it fails
+const loginQueueTimeout = 5 * time.Second
+
+var (
+	// Slots for concurrently handled connections, nil if unbounded
+	connectionSlots chan struct{}
+
+	// Slots for concurrent login database operations, nil if unbounded
+	loginSlots chan struct{}
+)
+
+func setMaxConnections(maxConnections int) {
+	if maxConnections <= 0 {
+		connectionSlots = nil
+		return
+	}
+
+	connectionSlots = make(chan struct{}, maxConnections)
+}
+
+func setMaxConcurrentLogins(maxLogins int) {
+	if maxLogins <= 0 {
+		loginSlots = nil
+		return
+	}
+
+	loginSlots = make(chan struct{}, maxLogins)
+}
+
+// Wait for a free login slot. Returns a function to release the slot, or nil if
+// no slot became free in time.
+func acquireLoginSlot() func() {
+	slots := loginSlots
+	if slots == nil {
+		return func() {}
+	}
+
+	timer := time.NewTimer(loginQueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Handle the connection in a new goroutine once a slot is free. Returns false
+// and closes the connection if no slot became free in time.
+func acceptConnection(conn net.Conn, handler func(net.Conn)) bool {
+	slots := connectionSlots
+	if slots == nil {
+		go handler(conn)
+		return true
+	}
+
+	select {
+	case slots <- struct{}{}:
+	default:
+		timer := time.NewTimer(connectionQueueTimeout)
+		defer timer.Stop()
+
+		select {
+		case slots <- struct{}{}:
+		case <-timer.C:
+			logging.Warn("GPCM", "Rejecting connection from", aurora.BrightCyan(conn.RemoteAddr()), "- too many connections")
+			conn.Close()
+			return false
+		}
+	}
+
+	go func() {
+		defer func() { <-slots }()
+		handler(conn)
+	}()
+
+	return true
+}
@@ -0,0 +1,94 @@
+package gpcm
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"wwfc/database"
+)
+
+func TestAcceptConnectionBound(t *testing.T) {
+	setMaxConnections(2)
+	defer setMaxConnections(0)
+
+	var active, peak atomic.Int32
+	var wg sync.WaitGroup
+
+	handler := func(conn net.Conn) {
+		defer wg.Done()
+
+		current := active.Add(1)
+		for {
+			previous := peak.Load()
+			if current <= previous || peak.CompareAndSwap(previous, current) {
+				break
+			}
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		active.Add(-1)
+	}
+
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		if !acceptConnection(&mockConn{remote: "1.2.3.4:5000"}, handler) {
+			t.Fatal("queued connection was rejected")
+		}
+	}
+
+	wg.Wait()
+	if peak.Load() > 2 {
+		t.Fatalf("handled %d connections at once, expected at most 2", peak.Load())
+	}
+}
+
+type slowLoginStore struct {
+	*database.MemoryStore
+	active atomic.Int32
+	peak   atomic.Int32
+}
+
+func (s *slowLoginStore) LoginUserToGPCM(userId uint64, gsbrcd string, profileId uint32, ngDeviceId uint32, ipAddress string, ingamesn string) (database.User, error) {
+	current := s.active.Add(1)
+	defer s.active.Add(-1)
+
+	for {
+		previous := s.peak.Load()
+		if current <= previous || s.peak.CompareAndSwap(previous, current) {
+			break
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	return s.MemoryStore.LoginUserToGPCM(userId, gsbrcd, profileId, ngDeviceId, ipAddress, ingamesn)
+}
+
+func TestConcurrentLoginLimit(t *testing.T) {
+	previousDb := db
+	store := &slowLoginStore{MemoryStore: database.NewMemoryStore()}
+	db = store
+	defer func() { db = previousDb }()
+
+	setMaxConcurrentLogins(3)
+	defer setMaxConcurrentLogins(0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(userId uint64) {
+			defer wg.Done()
+
+			session := &GameSpySession{Conn: &mockConn{remote: "1.2.3.4:5000"}}
+			if !session.performLoginWithDatabase(userId, "RMCJ", 0, 0) {
+				t.Errorf("login %d failed", userId)
+			}
+		}(uint64(i + 1))
+	}
+	wg.Wait()
+
+	if store.peak.Load() > 3 {
+		t.Fatalf("%d logins queried the database at once, expected at most 3", store.peak.Load())
+	}
+}
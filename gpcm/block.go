@@ -0,0 +1,148 @@
+package gpcm
+
+import (
+	"strconv"
+	"strings"
+	"wwfc/common"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+const maxBlockListSize = 256
+
+// isBlocking reports whether the session owner has blocked profileId.
+// Expects the global mutex to be held by the caller.
+func (g *GameSpySession) isBlocking(profileId uint32) bool {
+	for _, blockedId := range g.BlockList {
+		if blockedId == profileId {
+			return true
+		}
+	}
+	return false
+}
+
+// isBlockedBy reports whether profileId has blocked the session owner,
+// falling back to the database when that profile is not online
+func (g *GameSpySession) isBlockedBy(profileId uint32) bool {
+	mutex.Lock()
+	session, ok := sessions[profileId]
+	if ok && session.LoggedIn {
+		blocked := session.isBlocking(g.User.ProfileId)
+		mutex.Unlock()
+		return blocked
+	}
+	mutex.Unlock()
+
+	for _, blockedId := range storeForProfile(profileId).GetBlockedProfiles(profileId) {
+		if blockedId == g.User.ProfileId {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *GameSpySession) parseBlockProfileId(command common.GameSpyCommand) (uint32, bool) {
+	strProfileId := command.OtherValues["profileid"]
+	profileId, err := strconv.ParseUint(strProfileId, 10, 32)
+	if err != nil || profileId == 0 {
+		logging.Error(g.ModuleName, "Invalid block profile ID string:", aurora.Cyan(strProfileId))
+		return 0, false
+	}
+
+	return uint32(profileId), true
+}
+
+func (g *GameSpySession) blockProfile(command common.GameSpyCommand) {
+	profileId, ok := g.parseBlockProfileId(command)
+	if !ok || profileId == g.User.ProfileId {
+		g.replyError(ErrAddBlock)
+		return
+	}
+
+	mutex.Lock()
+	if g.isBlocking(profileId) {
+		mutex.Unlock()
+		g.replyError(ErrAddBlockAlreadyBlocked)
+		return
+	}
+
+	if len(g.BlockList) >= maxBlockListSize {
+		mutex.Unlock()
+		logging.Error(g.ModuleName, "Block list is full")
+		g.replyError(ErrAddBlock)
+		return
+	}
+
+	g.BlockList = append(g.BlockList, profileId)
+	mutex.Unlock()
+
+	g.store().BlockProfile(g.User.ProfileId, profileId)
+	logging.Info(g.ModuleName, "Blocked profile", aurora.Cyan(profileId))
+
+	g.WriteBuffer += common.CreateGameSpyMessage(common.GameSpyCommand{
+		Command:      "wwfc_block",
+		CommandValue: "",
+		OtherValues: map[string]string{
+			"profileid": strconv.FormatUint(uint64(profileId), 10),
+			"id":        command.OtherValues["id"],
+		},
+	})
+}
+
+func (g *GameSpySession) unblockProfile(command common.GameSpyCommand) {
+	profileId, ok := g.parseBlockProfileId(command)
+	if !ok {
+		g.replyError(ErrRemoveBlock)
+		return
+	}
+
+	mutex.Lock()
+	index := -1
+	for i, blockedId := range g.BlockList {
+		if blockedId == profileId {
+			index = i
+			break
+		}
+	}
+
+	if index == -1 {
+		mutex.Unlock()
+		g.replyError(ErrRemoveBlockNotBlocked)
+		return
+	}
+
+	g.BlockList = append(g.BlockList[:index], g.BlockList[index+1:]...)
+	mutex.Unlock()
+
+	g.store().UnblockProfile(g.User.ProfileId, profileId)
+	logging.Info(g.ModuleName, "Unblocked profile", aurora.Cyan(profileId))
+
+	g.WriteBuffer += common.CreateGameSpyMessage(common.GameSpyCommand{
+		Command:      "wwfc_unblock",
+		CommandValue: "",
+		OtherValues: map[string]string{
+			"profileid": strconv.FormatUint(uint64(profileId), 10),
+			"id":        command.OtherValues["id"],
+		},
+	})
+}
+
+func (g *GameSpySession) getBlocks(command common.GameSpyCommand) {
+	mutex.Lock()
+	profileIds := make([]string, 0, len(g.BlockList))
+	for _, blockedId := range g.BlockList {
+		profileIds = append(profileIds, strconv.FormatUint(uint64(blockedId), 10))
+	}
+	mutex.Unlock()
+
+	g.WriteBuffer += common.CreateGameSpyMessage(common.GameSpyCommand{
+		Command:      "getblocks",
+		CommandValue: "",
+		OtherValues: map[string]string{
+			"count":      strconv.Itoa(len(profileIds)),
+			"profileids": strings.Join(profileIds, ","),
+			"id":         command.OtherValues["id"],
+		},
+	})
+}
@@ -0,0 +1,55 @@
+package gpcm
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"wwfc/common"
+	"wwfc/database"
+)
+
+func TestBlockedMessageDropped(t *testing.T) {
+	previousDb := db
+	db = database.NewMemoryStore()
+	defer func() { db = previousDb }()
+
+	blocker, blockerConn := newTestSession(3601, "mariokartwii", "1.2.3.4:5000")
+	sender, senderConn := newTestSession(3602, "mariokartwii", "5.6.7.8:5000")
+	defer removeTestSessions(3601, 3602)
+
+	blocker.FriendList = []uint32{3602}
+	sender.FriendList = []uint32{3601}
+	sender.User.LastName = "sender"
+
+	blocker.blockProfile(common.GameSpyCommand{Command: "wwfc_block", OtherValues: map[string]string{"profileid": "3602", "id": "1"}})
+	if !strings.HasPrefix(blocker.WriteBuffer, `\wwfc_block\`) || !strings.Contains(blocker.WriteBuffer, `\profileid\3602\`) {
+		t.Fatalf("block was not acknowledged: %s", blocker.WriteBuffer)
+	}
+
+	blocker.WriteBuffer = ""
+	blocker.getBlocks(common.GameSpyCommand{Command: "getblocks", OtherValues: map[string]string{"id": "2"}})
+	if !strings.Contains(blocker.WriteBuffer, `\profileids\3602`) {
+		t.Fatalf("blocked profile missing from list: %s", blocker.WriteBuffer)
+	}
+
+	sender.bestieMessage(common.GameSpyCommand{
+		Command:      "bm",
+		CommandValue: "1",
+		OtherValues: map[string]string{
+			"t":   "3601",
+			"msg": "GPCM90vMAT" + string(rune(common.MatchResvWait)),
+		},
+	})
+
+	if written := blockerConn.written(); written != "" {
+		t.Fatalf("blocked message was delivered: %s", written)
+	}
+	if written := senderConn.written(); written != "" {
+		t.Fatalf("sender was told about the block: %s", written)
+	}
+
+	sender.addFriend(common.GameSpyCommand{Command: "addbuddy", OtherValues: map[string]string{"newprofileid": "3601"}})
+	if !strings.Contains(senderConn.written(), `\err\`+strconv.Itoa(ErrAddFriendBlocked.ErrorCode)) {
+		t.Fatalf("friend request to blocker was not refused: %s", senderConn.written())
+	}
+}
@@ -0,0 +1,41 @@
+package gpcm
+
+import (
+	"unicode/utf16"
+	"wwfc/common"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// Send a message to every logged in session, or only to those playing the
+// game if one is given. The message is encoded the same way as the message of
+// the day. Returns the number of sessions it was sent to.
+func BroadcastMessage(message string, gameName string) int {
+	payload := []byte(common.CreateGameSpyMessage(common.GameSpyCommand{
+		Command:      "wwfc_broadcast",
+		CommandValue: "",
+		OtherValues: map[string]string{
+			"msg": common.Base64DwcEncoding.EncodeToString(common.UTF16ToByteArray(utf16.Encode([]rune(message)))),
+		},
+	}))
+
+	var recipients []*GameSpySession
+	mutex.Lock()
+	for _, session := range sessions {
+		if session.LoggedIn && (gameName == "" || session.GameName == gameName) {
+			recipients = append(recipients, session)
+		}
+	}
+	mutex.Unlock()
+
+	sent := 0
+	for _, session := range recipients {
+		if session.write(payload) {
+			sent++
+		}
+	}
+
+	logging.Notice("GPCM", "Broadcast message to", aurora.Cyan(sent), "sessions")
+	return sent
+}
@@ -0,0 +1,46 @@
+package gpcm
+
+import (
+	"testing"
+	"unicode/utf16"
+	"wwfc/common"
+)
+
+func TestBroadcastAndListSessions(t *testing.T) {
+	first, firstConn := newTestSession(9921, "mariokartwii", "1.2.3.4:5000")
+	_, secondConn := newTestSession(9922, "tetrisds", "5.6.7.8:5000")
+	defer removeTestSessions(9921, 9922)
+	first.InGameName = "Player"
+
+	listed := map[uint32]SessionInfo{}
+	for _, info := range ListSessions() {
+		listed[info.ProfileID] = info
+	}
+	if listed[9921].GameName != "mariokartwii" || listed[9921].InGameName != "Player" || listed[9922].RemoteAddr != "5.6.7.8:5000" {
+		t.Fatalf("sessions were not listed: %+v", listed)
+	}
+
+	if sent := BroadcastMessage("Maintenance soon", "mariokartwii"); sent != 1 {
+		t.Fatalf("expected the broadcast to reach 1 session, got %d", sent)
+	}
+	if secondConn.written() != "" {
+		t.Fatalf("broadcast reached another game: %s", secondConn.written())
+	}
+
+	commands, err := common.ParseGameSpyMessage(firstConn.written())
+	if err != nil || len(commands) != 1 || commands[0].Command != "wwfc_broadcast" {
+		t.Fatalf("expected a broadcast, got %s", firstConn.written())
+	}
+
+	encoded, err := common.Base64DwcEncoding.DecodeString(commands[0].OtherValues["msg"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	units := make([]uint16, len(encoded)/2)
+	for i := range units {
+		units[i] = uint16(encoded[i*2])<<8 | uint16(encoded[i*2+1])
+	}
+	if message := string(utf16.Decode(units)); message != "Maintenance soon" {
+		t.Fatalf("unexpected broadcast message %q", message)
+	}
+}
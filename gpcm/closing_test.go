@@ -0,0 +1,35 @@
+package gpcm
+
+import (
+	"testing"
+	"wwfc/common"
+)
+
+func TestWriteToClosedConnection(t *testing.T) {
+	session, conn := newTestSession(3801, "mariokartwii", "1.2.3.4:5000")
+	defer removeTestSessions(3801)
+
+	conn.Close()
+
+	commands := []common.GameSpyCommand{
+		{Command: "ka", OtherValues: map[string]string{}},
+		{Command: "ka", OtherValues: map[string]string{}},
+		{Command: "getblocks", OtherValues: map[string]string{"id": "1"}},
+	}
+
+	handled := 0
+	commands = session.handleCommand("ka", commands, func(command common.GameSpyCommand) {
+		handled++
+		session.keepAlive(command)
+	})
+
+	if !session.closing.Load() {
+		t.Fatal("session was not marked as closing after a failed write")
+	}
+	if handled != 1 {
+		t.Fatalf("expected the loop to stop after the failed write, handled %d commands", handled)
+	}
+	if len(commands) != 0 {
+		t.Fatalf("remaining commands were not skipped: %v", commands)
+	}
+}
@@ -0,0 +1,78 @@
+package gpcm
+
+import (
+	"fmt"
+	"wwfc/common"
+	"wwfc/database"
+	"wwfc/logging"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// Databases for sessions from specific console regions, used instead of the
+// default for the session's profile. Lookups of other profiles go to the
+// database holding that profile.
+var regionDbs = map[byte]database.Store{}
+
+// Get the database for the session's region, or the default one
+func (g *GameSpySession) store() database.Store {
+	if g.TestLogin {
+		return testLoginDb
+	}
+
+	if g.LoginInfoSet {
+		if store, exists := regionDbs[g.Region]; exists {
+			return store
+		}
+	}
+
+	return db
+}
+
+// Get the database holding a profile: the one for its session's region while
+// it's online, otherwise whichever regional database has it on record, or the
+// default. Expects the session mutex to not be locked.
+func storeForProfile(profileId uint32) database.Store {
+	mutex.Lock()
+	session, ok := sessions[profileId]
+	mutex.Unlock()
+
+	if ok && session.LoggedIn {
+		return session.store()
+	}
+
+	for _, store := range regionDbs {
+		if _, exists := store.GetProfile(profileId); exists {
+			return store
+		}
+	}
+
+	return db
+}
+
+func connectRegionDatabases(config common.Config) {
+	regionDbs = map[byte]database.Store{}
+
+	for _, region := range config.DatabaseRegions {
+		name := region.Name
+		if name == "" {
+			name = config.DatabaseName
+		}
+
+		dbString := fmt.Sprintf("postgres://%s:%s@%s/%s", config.Username, config.Password, region.Address, name)
+		dbConf, err := pgxpool.ParseConfig(dbString)
+		if err != nil {
+			panic(err)
+		}
+
+		regionPool, err := pgxpool.ConnectConfig(ctx, dbConf)
+		if err != nil {
+			panic(err)
+		}
+
+		database.UpdateTables(regionPool, ctx)
+		regionDbs[region.Region] = database.NewPostgresStore(regionPool, ctx)
+		logging.Notice("GPCM", "Using the database at", aurora.BrightCyan(region.Address), "for region", aurora.Cyan(region.Region))
+	}
+}
@@ -0,0 +1,96 @@
+package gpcm
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"wwfc/common"
+	"wwfc/database"
+)
+
+func TestRegionDatabaseRouting(t *testing.T) {
+	previousDb := db
+	fallback := database.NewMemoryStore()
+	europe := database.NewMemoryStore()
+	america := database.NewMemoryStore()
+	db = fallback
+	regionDbs = map[byte]database.Store{RegionEurope: europe, RegionAmerica: america}
+	defer func() {
+		db = previousDb
+		regionDbs = map[byte]database.Store{}
+	}()
+
+	// The test login is from a European console
+	session, _ := loginTestSession(t, 9701, 9702, "1.2.3.4:5000")
+	defer removeTestSessions(9702)
+
+	session.setPref(common.GameSpyCommand{Command: "wwfc_setpref", OtherValues: map[string]string{"key": "controls", "value": "classic", "id": "1"}})
+
+	if _, exists := europe.GetProfile(9702); !exists {
+		t.Fatal("the profile was not created in the session's regional database")
+	}
+	if europe.GetProfileSettings(9702)["controls"] != "classic" {
+		t.Fatal("the preference was not stored in the session's regional database")
+	}
+
+	for name, store := range map[string]database.Store{"default": fallback, "other region": america} {
+		if _, exists := store.GetProfile(9702); exists {
+			t.Errorf("the profile was created in the %s database", name)
+		}
+		if len(store.GetProfileSettings(9702)) != 0 {
+			t.Errorf("the preference was stored in the %s database", name)
+		}
+	}
+
+	// Regions without their own database use the default
+	session.Region = RegionJapan
+	if session.store() != fallback {
+		t.Fatal("expected a region without a database to use the default")
+	}
+}
+
+func TestRegionDatabaseBlocksAfterLogout(t *testing.T) {
+	previousDb := db
+	db = database.NewMemoryStore()
+	europe := database.NewMemoryStore()
+	regionDbs = map[byte]database.Store{RegionEurope: europe}
+	defer func() {
+		db = previousDb
+		regionDbs = map[byte]database.Store{}
+	}()
+
+	// The test login is from a European console, so the block is stored in
+	// the regional database
+	blocker, _ := loginTestSession(t, 9711, 9712, "1.2.3.4:5000")
+	blocker.blockProfile(common.GameSpyCommand{Command: "wwfc_block", OtherValues: map[string]string{"profileid": "9713", "id": "1"}})
+	removeTestSessions(9712)
+
+	sender, senderConn := newTestSession(9713, "tetrisds", "5.6.7.8:5000")
+	defer removeTestSessions(9713)
+	sender.FriendList = []uint32{9712}
+	sender.User.LastName = "sender"
+
+	sender.addFriend(common.GameSpyCommand{Command: "addbuddy", OtherValues: map[string]string{"newprofileid": "9712"}})
+	if !strings.Contains(senderConn.written(), `\err\`+strconv.Itoa(ErrAddFriendBlocked.ErrorCode)) {
+		t.Fatalf("friend request to the offline blocker was not refused: %s", senderConn.written())
+	}
+
+	// Back online, the block still drops messages
+	blocker, blockerConn := loginTestSession(t, 9711, 9712, "1.2.3.4:5000")
+	defer removeTestSessions(9712)
+	blocker.FriendList = []uint32{9713}
+	written := blockerConn.written()
+
+	sender.bestieMessage(common.GameSpyCommand{
+		Command:      "bm",
+		CommandValue: "1",
+		OtherValues: map[string]string{
+			"t":   "9712",
+			"msg": "GPCM90vMAT" + string(rune(common.MatchResvWait)),
+		},
+	})
+
+	if blockerConn.written() != written {
+		t.Fatalf("blocked message was delivered: %s", blockerConn.written()[len(written):])
+	}
+}
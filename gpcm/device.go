@@ -0,0 +1,45 @@
+package gpcm
+
+import (
+	"fmt"
+	"sync/atomic"
+	"wwfc/database"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+var (
+	// Allow logins from a device other than the one on record instead of
+	// rejecting them, restricting the session
+	flagDeviceMismatch = false
+
+	deviceMismatchLogins atomic.Uint64
+)
+
+// GetDeviceMismatchCount returns the number of logins flagged for coming from
+// a device other than the one on record for the profile
+func GetDeviceMismatchCount() uint64 {
+	return deviceMismatchLogins.Load()
+}
+
+// Log and count a login from a device other than the one on record, whether
+// or not it's then allowed
+func (g *GameSpySession) recordDeviceMismatch(userId uint64, gsbrCode string, deviceId uint32) {
+	logging.Warn(g.ModuleName, "Login for user", aurora.Cyan(userId), aurora.Cyan(gsbrCode), "from device", aurora.Cyan(fmt.Sprintf("%08x", deviceId)), "which is not the one on record")
+	deviceMismatchLogins.Add(1)
+}
+
+// Log in from the mismatched device, still checking it for bans. The session
+// is restricted, keeping it out of public rooms, since the device couldn't be
+// verified as belonging to the profile.
+func (g *GameSpySession) loginWithMismatchedDevice(userId uint64, gsbrCode string, profileId uint32, deviceId uint32, ipAddress string) (database.User, error) {
+	user, err := g.store().LoginUserToGPCMFromOtherDevice(userId, gsbrCode, profileId, deviceId, ipAddress, g.InGameName)
+	if err != nil {
+		return user, err
+	}
+
+	g.DeviceMismatch = true
+	user.Restricted = true
+	return user, nil
+}
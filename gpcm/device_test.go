@@ -0,0 +1,70 @@
+package gpcm
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"wwfc/database"
+)
+
+func TestDeviceMismatchLogin(t *testing.T) {
+	previousDb := db
+	store := database.NewMemoryStore()
+	db = store
+	defer func() { db = previousDb }()
+
+	first := &GameSpySession{Conn: &mockConn{remote: "1.2.3.4:5000"}}
+	if !first.performLoginWithDatabase(7001, "RMCJ", 0, 0x11111111) {
+		t.Fatal("first login failed")
+	}
+
+	// Rejected by default, but still counted
+	before := GetDeviceMismatchCount()
+	conn := &mockConn{remote: "1.2.3.4:5000"}
+	rejected := &GameSpySession{Conn: conn}
+	if rejected.performLoginWithDatabase(7001, "RMCJ", 0, 0x22222222) {
+		t.Fatal("login from another device was accepted")
+	}
+	if !strings.Contains(conn.written(), `\err\`+strconv.Itoa(ErrLogin.ErrorCode)) {
+		t.Fatalf("rejected login was not sent an error: %s", conn.written())
+	}
+	if GetDeviceMismatchCount() != before+1 {
+		t.Fatalf("rejected mismatch was not counted: %d", GetDeviceMismatchCount()-before)
+	}
+
+	flagDeviceMismatch = true
+	defer func() { flagDeviceMismatch = false }()
+
+	before = GetDeviceMismatchCount()
+	flagged := &GameSpySession{Conn: &mockConn{remote: "1.2.3.4:5000"}}
+	if !flagged.performLoginWithDatabase(7001, "RMCJ", 0, 0x22222222) {
+		t.Fatal("flagged login was rejected")
+	}
+
+	if !flagged.DeviceMismatch || GetDeviceMismatchCount() != before+1 {
+		t.Fatalf("login was not flagged: %v %d", flagged.DeviceMismatch, GetDeviceMismatchCount()-before)
+	}
+	if !flagged.User.Restricted {
+		t.Fatal("flagged login was not restricted")
+	}
+	if flagged.User.ProfileId != first.User.ProfileId || flagged.User.NgDeviceId != 0x11111111 {
+		t.Fatalf("flagged login changed the profile: %d %08x", flagged.User.ProfileId, flagged.User.NgDeviceId)
+	}
+
+	// A banned device is refused even when it's a mismatch that would be let in
+	banned := &GameSpySession{Conn: &mockConn{remote: "5.6.7.8:5000"}}
+	if !banned.performLoginWithDatabase(7002, "RMCJ", 0, 0x33333333) {
+		t.Fatal("login for the banned device's profile failed")
+	}
+	store.BanUser(banned.User.ProfileId, true, time.Hour, "test", "test", "test")
+
+	conn = &mockConn{remote: "9.9.9.9:5000"}
+	evading := &GameSpySession{Conn: conn}
+	if evading.performLoginWithDatabase(7001, "RMCJ", 0, 0x33333333) {
+		t.Fatal("login from a banned device was accepted")
+	}
+	if !strings.Contains(conn.written(), "banned") {
+		t.Fatalf("expected a ban error, got %s", conn.written())
+	}
+}
@@ -351,6 +351,23 @@ var (
 				"Error Code: %[1]d",
 		},
 	}
+
+	WWFCMsgUpdateRequired = WWFCErrorMessage{
+		ErrorCode: 22010,
+		MessageRMC: map[byte]string{
+			LangEnglish: "" +
+				"Your game is using an outdated\n" +
+				"version of the network library.\n" +
+				"Please update to continue.\n" +
+				"\n" +
+				"Error Code: %[1]d",
+		},
+	}
+
+	// The message itself comes from the block configuration
+	WWFCMsgGameBlocked = WWFCErrorMessage{
+		ErrorCode: 22011,
+	}
 )
 
 func (err GPError) GetMessage() string {
@@ -409,7 +426,7 @@ func (g *GameSpySession) replyError(err GPError) {
 	if !g.LoginInfoSet {
 		msg := err.GetMessage()
 		// logging.Info(g.ModuleName, "Sending error message:", msg)
-		g.Conn.Write([]byte(msg))
+		g.write([]byte(msg))
 		if err.Fatal {
 			g.Conn.Close()
 		}
@@ -423,7 +440,7 @@ func (g *GameSpySession) replyError(err GPError) {
 
 	msg := err.GetMessageTranslate(g.GameName, g.Region, g.Language, g.ConsoleFriendCode, deviceId)
 	// logging.Info(g.ModuleName, "Sending error message:", msg)
-	g.Conn.Write([]byte(msg))
+	g.write([]byte(msg))
 	if err.Fatal {
 		g.Conn.Close()
 	}
@@ -0,0 +1,69 @@
+package gpcm
+
+import "encoding/json"
+
+type ProfileExport struct {
+	ProfileID     uint32   `json:"profileId"`
+	UserID        uint64   `json:"userId"`
+	GsbrCode      string   `json:"gsbrcd"`
+	NgDeviceID    uint32   `json:"ngDeviceId"`
+	Email         string   `json:"email"`
+	UniqueNick    string   `json:"uniqueNick"`
+	FirstName     string   `json:"firstName"`
+	LastName      string   `json:"lastName"`
+	MKWFriendInfo string   `json:"mkwFriendInfo,omitempty"`
+	Online        bool     `json:"online"`
+	GameName      string   `json:"gameName,omitempty"`
+	Status        string   `json:"status,omitempty"`
+	LocString     string   `json:"locString,omitempty"`
+	Friends       []uint32 `json:"friends"`
+	FriendAdds    []uint32 `json:"friendAdds"`
+}
+
+// Collect all stored data for a profile into a JSON document, for data
+// portability requests. Friend lists are only kept in memory, so they are
+// only included while the profile is online.
+func ExportProfile(profileId uint32) ([]byte, bool) {
+	store := storeForProfile(profileId)
+	user, exists := store.GetProfile(profileId)
+	if !exists {
+		return nil, false
+	}
+
+	export := ProfileExport{
+		ProfileID:     user.ProfileId,
+		UserID:        user.UserId,
+		GsbrCode:      user.GsbrCode,
+		NgDeviceID:    user.NgDeviceId,
+		Email:         user.Email,
+		UniqueNick:    user.UniqueNick,
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		MKWFriendInfo: store.GetMKWFriendInfo(profileId),
+		Friends:       []uint32{},
+		FriendAdds:    []uint32{},
+	}
+
+	mutex.Lock()
+	if session, ok := sessions[profileId]; ok && session.LoggedIn {
+		export.Online = true
+		export.GameName = session.GameName
+		export.Status = session.Status
+		export.LocString = session.LocString
+		export.Friends = append(export.Friends, session.AuthFriendList...)
+
+		for _, friend := range session.FriendList {
+			if !session.isFriendAuthorized(friend) {
+				export.FriendAdds = append(export.FriendAdds, friend)
+			}
+		}
+	}
+	mutex.Unlock()
+
+	data, err := json.Marshal(export)
+	if err != nil {
+		return nil, false
+	}
+
+	return data, true
+}
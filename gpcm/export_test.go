@@ -0,0 +1,44 @@
+package gpcm
+
+import (
+	"encoding/json"
+	"testing"
+	"wwfc/database"
+)
+
+func TestExportProfile(t *testing.T) {
+	previousDb := db
+	store := database.NewMemoryStore()
+	db = store
+	defer func() { db = previousDb }()
+
+	store.InsertUser(database.User{ProfileId: 6001, UserId: 300, GsbrCode: "RMCJ", Email: "test@nds", UniqueNick: "test"})
+	store.UpdateMKWFriendInfo(6001, "friendinfo")
+
+	session, _ := newTestSession(6001, "mariokartwii", "1.2.3.4:5000")
+	defer removeTestSessions(6001)
+
+	session.FriendList = []uint32{6002, 6003, 6004}
+	session.AuthFriendList = []uint32{6002, 6003}
+	session.Status = "|s|1|ss|Online"
+
+	data, exists := ExportProfile(6001)
+	if !exists {
+		t.Fatal("profile not found")
+	}
+
+	var export ProfileExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		t.Fatal(err)
+	}
+
+	if export.UserID != 300 || export.Email != "test@nds" || export.MKWFriendInfo != "friendinfo" {
+		t.Fatalf("user record missing from export: %s", data)
+	}
+	if !export.Online || export.Status != session.Status {
+		t.Fatalf("session state missing from export: %s", data)
+	}
+	if len(export.Friends) != 2 || len(export.FriendAdds) != 1 || export.FriendAdds[0] != 6004 {
+		t.Fatalf("friends missing from export: %s", data)
+	}
+}
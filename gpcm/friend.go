@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 	"wwfc/common"
 	"wwfc/logging"
 	"wwfc/qr2"
@@ -98,6 +99,17 @@ func (g *GameSpySession) addFriend(command common.GameSpyCommand) {
 	fc := common.CalcFriendCodeString(uint32(newProfileId), "RMCJ")
 	logging.Info(g.ModuleName, "Add friend:", aurora.Cyan(strNewProfileId), aurora.Cyan(fc))
 
+	if g.isBlockedBy(uint32(newProfileId)) {
+		logging.Error(g.ModuleName, "Destination", aurora.Cyan(strNewProfileId), "has blocked the sender")
+		g.replyError(ErrAddFriendBlocked)
+		return
+	}
+
+	// Hold the lock for the whole update so a concurrent delbuddy for the same
+	// friend is applied either entirely before or entirely after this
+	mutex.Lock()
+	defer mutex.Unlock()
+
 	if g.isFriendAuthorized(uint32(newProfileId)) {
 		logging.Info(g.ModuleName, "Attempt to add a friend who is already authorized")
 		// This seems to always happen, do we need to return an error?
@@ -106,9 +118,6 @@ func (g *GameSpySession) addFriend(command common.GameSpyCommand) {
 		return
 	}
 
-	mutex.Lock()
-	defer mutex.Unlock()
-
 	// TODO: Add a limit
 	if !g.isFriendAdded(uint32(newProfileId)) {
 		g.FriendList = append(g.FriendList, uint32(newProfileId))
@@ -136,7 +145,9 @@ func (g *GameSpySession) addFriend(command common.GameSpyCommand) {
 	// Friends are now mutual!
 	// TODO: Add a limit
 	g.AuthFriendList = append(g.AuthFriendList, uint32(newProfileId))
-	newSession.AuthFriendList = append(newSession.AuthFriendList, g.User.ProfileId)
+	if !newSession.isFriendAuthorized(g.User.ProfileId) {
+		newSession.AuthFriendList = append(newSession.AuthFriendList, g.User.ProfileId)
+	}
 
 	// Send friend auth message
 	sendMessageToSessionBuffer("4", newSession.User.ProfileId, g, "")
@@ -185,7 +196,7 @@ func (g *GameSpySession) removeFriend(command common.GameSpyCommand) {
 	delProfileIDIndex = g.getAuthorizedFriendIndex(delProfileID32)
 	removeFromUint32Array(&g.AuthFriendList, delProfileIDIndex)
 
-	sendMessageToProfileId("100", g.User.ProfileId, delProfileID32, logOutMessage)
+	queueFriendStatusToProfileId(g.User.ProfileId, delProfileID32, logOutMessage)
 }
 
 func (g *GameSpySession) authAddFriend(command common.GameSpyCommand) {
@@ -209,7 +220,43 @@ func (g *GameSpySession) authAddFriend(command common.GameSpyCommand) {
 	g.exchangeFriendStatus(uint32(fromProfileId))
 }
 
+// GameSpy buddy status codes
+const (
+	StatusOffline  = 0
+	StatusOnline   = 1
+	StatusPlaying  = 2
+	StatusStaging  = 3
+	StatusChatting = 4
+	StatusAway     = 5
+)
+
+// Build the buddy status sent to friends in a bm 100 message, carrying the
+// status code along with the status and location strings
+func formatStatusMessage(statusCode int, statstring string, locstring string) string {
+	return "|s|" + strconv.Itoa(statusCode) + "|ss|" + statstring + "|ls|" + locstring + "|ip|0|p|0|qm|0"
+}
+
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceBusy    = "busy"
+	PresenceInMatch = "inmatch"
+
+	// Online for matchmaking but shown to friends as offline
+	PresenceInvisible = "invisible"
+)
+
+var presenceStates = map[string]bool{
+	PresenceOnline:    true,
+	PresenceAway:      true,
+	PresenceBusy:      true,
+	PresenceInMatch:   true,
+	PresenceInvisible: true,
+}
+
 func (g *GameSpySession) setStatus(command common.GameSpyCommand) {
+	g.touchActivity()
+
 	status := command.CommandValue
 	logging.Notice(g.ModuleName, "New status:", aurora.BrightMagenta(status))
 
@@ -227,24 +274,60 @@ func (g *GameSpySession) setStatus(command common.GameSpyCommand) {
 		locstring = ""
 	}
 
-	statusMsg := "|s|" + status + "|ss|" + statstring + "|ls|" + locstring + "|ip|0|p|0|qm|0"
+	statusCode, err := strconv.Atoi(status)
+	if err != nil || statusCode < StatusOffline || statusCode > StatusAway {
+		logging.Warn(g.ModuleName, "Invalid status code:", aurora.Cyan(status))
+		statusCode = StatusOnline
+	}
+
+	statusMsg := formatStatusMessage(statusCode, statstring, locstring)
+
+	// Presence state sent by modded clients alongside the free-text status
+	presence := ""
+	if value, ok := command.OtherValues["wwfc_presence"]; ok {
+		if presenceStates[value] {
+			presence = value
+			if presence != PresenceInvisible {
+				statusMsg += "|wp|" + presence
+			}
+		} else {
+			logging.Warn(g.ModuleName, "Invalid presence state:", aurora.Cyan(value))
+		}
+	}
 
 	mutex.Lock()
 	defer mutex.Unlock()
 
-	if status == "3" && g.User.Restricted {
+	if statusCode == StatusStaging && g.User.Restricted {
 		logging.Warn(g.ModuleName, "Restricted user searching for public rooms")
 		kickPlayer(g.User.ProfileId, "restricted_join")
 	}
 
+	// Friends only need to be told once that an invisible player went offline
+	wasVisible := g.Status != "" && g.Presence != PresenceInvisible
+
 	g.LocString = locstring
+	g.StatusCode = statusCode
+	g.Presence = presence
 	g.Status = statusMsg
 
+	if presence == PresenceInvisible && !wasVisible {
+		return
+	}
+
 	for _, storedPid := range g.FriendList {
 		g.sendFriendStatus(storedPid)
 	}
 }
 
+// Get the status shown to friends, which is offline for an invisible player
+func (g *GameSpySession) visibleStatus() string {
+	if g.Presence == PresenceInvisible {
+		return logOutMessage
+	}
+	return g.Status
+}
+
 const (
 	resvDenyVer3  = "GPCM3vMAT\x0316"
 	resvDenyVer11 = "GPCM11vMAT\x0300000010"
@@ -386,10 +469,30 @@ func (g *GameSpySession) bestieMessage(command common.GameSpyCommand) {
 		return
 	}
 
-	msgMatchData, ok := common.DecodeMatchCommand(cmd, msgData, version)
+	// Reservations are decoded directly so the reason one is invalid can be logged
+	var msgMatchData common.MatchCommandData
+	var reservationErr error
+	if cmd == common.MatchReservation {
+		var reservation *common.MatchCommandDataReservation
+		reservation, reservationErr = common.DecodeMatchReservation(msgData, version)
+		ok = reservationErr == nil
+		if ok {
+			msgMatchData = common.MatchCommandData{
+				Version:     version,
+				Command:     cmd,
+				Reservation: reservation,
+			}
+		}
+	} else {
+		msgMatchData, ok = common.DecodeMatchCommand(cmd, msgData, version)
+	}
+
 	common.LogMatchCommand(g.ModuleName, strconv.FormatInt(int64(toProfileId), 10), cmd, msgMatchData)
 	if !ok {
 		logging.Error(g.ModuleName, "Invalid match command data; message:", msg)
+		if reservationErr != nil {
+			logging.Error(g.ModuleName, "Reservation error:", reservationErr.Error())
+		}
 		g.replyError(ErrMessage)
 		return
 	}
@@ -419,8 +522,18 @@ func (g *GameSpySession) bestieMessage(command common.GameSpyCommand) {
 	var toSession *GameSpySession
 	if toSession, ok = sessions[uint32(toProfileId)]; !ok || !toSession.LoggedIn {
 		logging.Error(g.ModuleName, "Destination", aurora.Cyan(toProfileId), "is not online")
-		// g.replyError(ErrMessageFriendOffline)
 		sendMessageToSession("1", uint32(toProfileId), g, resvDenyMsg)
+
+		// The deny is enough for a buddy, but for a profile that was never
+		// authorized the client should also be told the message bounced
+		if !g.isFriendAuthorized(uint32(toProfileId)) {
+			g.replyError(ErrMessageFriendOffline)
+		}
+		return
+	}
+
+	if toSession.isBlocking(g.User.ProfileId) {
+		logging.Warn(g.ModuleName, "Destination", aurora.Cyan(toProfileId), "has blocked the sender, dropping message")
 		return
 	}
 
@@ -527,37 +640,13 @@ func (g *GameSpySession) bestieMessage(command common.GameSpyCommand) {
 	if cmd == common.MatchReservation {
 		g.Reservation = msgMatchData
 		g.ReservationPID = uint32(toProfileId)
+	} else if cmd == common.MatchResvCancel {
+		// The forwarded message already tells the peer
+		g.clearReservation(false)
 	}
 
-	var newMsgStr string
-
 	// Re-encode the new message
-	switch version {
-	case 3:
-		newMsgStr = "GPCM3vMAT" + string(cmd)
-
-		for i := 0; i < len(newMsg); i += 4 {
-			if i > 0 {
-				newMsgStr += "/"
-			}
-
-			newMsgStr += strconv.FormatUint(uint64(binary.LittleEndian.Uint32(newMsg[i:])), 10)
-		}
-
-	case 11:
-		newMsgStr = "GPCM11vMAT" + string(cmd)
-
-		for i := 0; i < len(newMsg); i += 4 {
-			if i > 0 {
-				newMsgStr += "/"
-			}
-
-			newMsgStr += hex.EncodeToString(newMsg[i : i+4])
-		}
-
-	case 90:
-		newMsgStr = "GPCM90vMAT" + string(cmd) + common.Base64DwcEncoding.EncodeToString(newMsg)
-	}
+	newMsgStr := encodeMatchMessage(version, cmd, newMsg)
 
 	sendMessageToSession("1", g.User.ProfileId, toSession, newMsgStr)
 }
@@ -571,7 +660,7 @@ func sendMessageToSession(msgType string, from uint32, session *GameSpySession,
 			"msg": msg,
 		},
 	})
-	session.Conn.Write([]byte(message))
+	session.write([]byte(message))
 }
 
 func sendMessageToSessionBuffer(msgType string, from uint32, session *GameSpySession, msg string) {
@@ -585,16 +674,6 @@ func sendMessageToSessionBuffer(msgType string, from uint32, session *GameSpySes
 	})
 }
 
-func sendMessageToProfileId(msgType string, from uint32, to uint32, msg string) bool {
-	if session, ok := sessions[to]; ok && session.LoggedIn {
-		sendMessageToSession(msgType, from, session, msg)
-		return true
-	}
-
-	logging.Info("GPCM", "Destination", aurora.Cyan(to), "from", aurora.Cyan(from), "is not online")
-	return false
-}
-
 func (g *GameSpySession) sendFriendStatus(profileId uint32) {
 	if g.isFriendAdded(profileId) {
 		if session, ok := sessions[profileId]; ok && session.LoggedIn && session.isFriendAdded(g.User.ProfileId) {
@@ -604,7 +683,7 @@ func (g *GameSpySession) sendFriendStatus(profileId uint32) {
 				return
 			}
 
-			sendMessageToSession("100", g.User.ProfileId, session, g.Status)
+			queueFriendStatus(g.User.ProfileId, session, g.visibleStatus())
 		}
 	}
 }
@@ -612,8 +691,8 @@ func (g *GameSpySession) sendFriendStatus(profileId uint32) {
 func (g *GameSpySession) exchangeFriendStatus(profileId uint32) {
 	if g.isFriendAdded(profileId) {
 		if session, ok := sessions[profileId]; ok && session.LoggedIn && session.isFriendAdded(g.User.ProfileId) {
-			sendMessageToSession("100", g.User.ProfileId, session, g.Status)
-			sendMessageToSessionBuffer("100", profileId, g, session.Status)
+			queueFriendStatus(g.User.ProfileId, session, g.visibleStatus())
+			sendMessageToSessionBuffer("100", profileId, g, session.visibleStatus())
 		}
 	}
 }
@@ -621,7 +700,49 @@ func (g *GameSpySession) exchangeFriendStatus(profileId uint32) {
 func (g *GameSpySession) sendLogoutStatus() {
 	mutex.Lock()
 	for _, storedPid := range g.AuthFriendList {
-		sendMessageToProfileId("100", g.User.ProfileId, storedPid, logOutMessage)
+		queueFriendStatusToProfileId(g.User.ProfileId, storedPid, logOutMessage)
 	}
 	mutex.Unlock()
 }
+
+// Send the logout status to friends after the offline grace period, unless the
+// profile logs in again before then
+func (g *GameSpySession) scheduleLogoutStatus() {
+	if offlineGracePeriod <= 0 {
+		g.sendLogoutStatus()
+		return
+	}
+
+	profileId := g.User.ProfileId
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if timer, exists := pendingLogouts[profileId]; exists {
+		timer.Stop()
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(offlineGracePeriod, func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if pendingLogouts[profileId] != timer {
+			return
+		}
+		delete(pendingLogouts, profileId)
+
+		for _, storedPid := range g.AuthFriendList {
+			queueFriendStatusToProfileId(profileId, storedPid, logOutMessage)
+		}
+	})
+	pendingLogouts[profileId] = timer
+}
+
+// Cancel a pending logout status broadcast. Expects the global mutex to already be locked.
+func cancelLogoutStatus(profileId uint32) {
+	if timer, exists := pendingLogouts[profileId]; exists {
+		timer.Stop()
+		delete(pendingLogouts, profileId)
+	}
+}
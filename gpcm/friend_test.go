@@ -0,0 +1,248 @@
+package gpcm
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"wwfc/common"
+)
+
+func TestOfflineGracePeriod(t *testing.T) {
+	offlineGracePeriod = 50 * time.Millisecond
+	defer func() { offlineGracePeriod = 0 }()
+
+	friend, friendConn := newTestSession(3001, "mariokartwii", "1.2.3.4:5000")
+	player, _ := newTestSession(3002, "mariokartwii", "5.6.7.8:5000")
+	defer removeTestSessions(3001, 3002)
+
+	friend.AuthFriendList = []uint32{3002}
+	player.AuthFriendList = []uint32{3001}
+
+	// Drop and quickly reconnect
+	player.closeSession()
+	reconnected, _ := newTestSession(3002, "mariokartwii", "5.6.7.8:5001")
+	reconnected.AuthFriendList = []uint32{3001}
+	mutex.Lock()
+	cancelLogoutStatus(3002)
+	mutex.Unlock()
+
+	time.Sleep(100 * time.Millisecond)
+	if strings.Contains(friendConn.written(), logOutMessage) {
+		t.Fatal("offline status was sent despite reconnecting")
+	}
+
+	// Drop without reconnecting
+	reconnected.closeSession()
+	time.Sleep(100 * time.Millisecond)
+	if !strings.Contains(friendConn.written(), logOutMessage) {
+		t.Fatal("offline status was not sent after the grace period")
+	}
+}
+
+func TestPresenceBroadcast(t *testing.T) {
+	friend, friendConn := newTestSession(3101, "mariokartwii", "1.2.3.4:5000")
+	player, _ := newTestSession(3102, "mariokartwii", "5.6.7.8:5000")
+	defer removeTestSessions(3101, 3102)
+
+	friend.FriendList = []uint32{3102}
+	friend.AuthFriendList = []uint32{3102}
+	player.FriendList = []uint32{3101}
+	player.AuthFriendList = []uint32{3101}
+
+	player.setStatus(common.GameSpyCommand{
+		Command:      "status",
+		CommandValue: "2",
+		OtherValues: map[string]string{
+			"statstring":    "Racing",
+			"locstring":     "",
+			"wwfc_presence": PresenceInMatch,
+		},
+	})
+
+	if player.Presence != PresenceInMatch {
+		t.Fatalf("presence not stored: %q", player.Presence)
+	}
+	if !strings.Contains(friendConn.written(), "|wp|"+PresenceInMatch) {
+		t.Fatalf("friend did not receive the presence state: %s", friendConn.written())
+	}
+}
+
+func TestConcurrentAddAndRemoveFriend(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		player, _ := newTestSession(3201, "mariokartwii", "1.2.3.4:5000")
+		friend, _ := newTestSession(3202, "mariokartwii", "5.6.7.8:5000")
+		player.User.LastName = "000000000RMCJ"
+
+		player.FriendList = []uint32{3202}
+		player.AuthFriendList = []uint32{3202}
+		friend.FriendList = []uint32{3201}
+		friend.AuthFriendList = []uint32{3201}
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			player.removeFriend(common.GameSpyCommand{Command: "delbuddy", OtherValues: map[string]string{"delprofileid": "3202"}})
+		}()
+		go func() {
+			defer wg.Done()
+			player.addFriend(common.GameSpyCommand{Command: "addbuddy", OtherValues: map[string]string{"newprofileid": "3202"}})
+		}()
+		wg.Wait()
+
+		mutex.Lock()
+		added, authorized := player.isFriendAdded(3202), player.isFriendAuthorized(3202)
+		if authorized && !added {
+			t.Fatalf("friend is authorized but not added: %v %v", player.FriendList, player.AuthFriendList)
+		}
+		if len(player.FriendList) > 1 || len(player.AuthFriendList) > 1 || len(friend.AuthFriendList) > 1 {
+			t.Fatalf("duplicate friend entries: %v %v %v", player.FriendList, player.AuthFriendList, friend.AuthFriendList)
+		}
+		mutex.Unlock()
+
+		removeTestSessions(3201, 3202)
+	}
+}
+
+func TestMessageToOfflineNonBuddyBounces(t *testing.T) {
+	player, conn := newTestSession(3301, "mariokartwii", "1.2.3.4:5000")
+	defer removeTestSessions(3301)
+
+	// Added but never authorized, and not online
+	player.FriendList = []uint32{3302}
+
+	player.bestieMessage(common.GameSpyCommand{
+		Command:      "bm",
+		CommandValue: "1",
+		OtherValues: map[string]string{
+			"t":   "3302",
+			"msg": "GPCM90vMAT" + string(rune(common.MatchResvWait)),
+		},
+	})
+
+	if !strings.Contains(conn.written(), `\err\`+strconv.Itoa(ErrMessageFriendOffline.ErrorCode)) {
+		t.Fatalf("sender did not receive a delivery failure: %s", conn.written())
+	}
+}
+
+func TestStatusPushFields(t *testing.T) {
+	friend, friendConn := newTestSession(3501, "mariokartwii", "1.2.3.4:5000")
+	player, _ := newTestSession(3502, "mariokartwii", "5.6.7.8:5000")
+	defer removeTestSessions(3501, 3502)
+
+	friend.FriendList = []uint32{3502}
+	friend.AuthFriendList = []uint32{3502}
+	player.FriendList = []uint32{3501}
+	player.AuthFriendList = []uint32{3501}
+
+	player.setStatus(common.GameSpyCommand{
+		Command:      "status",
+		CommandValue: "2",
+		OtherValues: map[string]string{
+			"statstring": "Racing",
+			"locstring":  "GPCM Test",
+		},
+	})
+
+	commands, err := common.ParseGameSpyMessage(friendConn.written())
+	if err != nil || len(commands) != 1 || commands[0].Command != "bm" || commands[0].CommandValue != "100" {
+		t.Fatalf("expected a status push, got %s", friendConn.written())
+	}
+
+	msg := commands[0].OtherValues["msg"]
+	for _, expected := range []string{"|s|2|", "|ss|Racing|", "|ls|GPCM Test|"} {
+		if !strings.Contains(msg, expected) {
+			t.Errorf("status push is missing %s: %s", expected, msg)
+		}
+	}
+
+	// An invalid status code falls back to online
+	player.setStatus(common.GameSpyCommand{Command: "status", CommandValue: "x", OtherValues: map[string]string{}})
+	if player.StatusCode != StatusOnline || !strings.HasPrefix(player.Status, "|s|1|") {
+		t.Fatalf("invalid status code was not replaced: %s", player.Status)
+	}
+}
+
+func TestStatusPushesBatched(t *testing.T) {
+	statusBatchWindow = 50 * time.Millisecond
+	defer func() { statusBatchWindow = 0 }()
+
+	recipient, recipientConn := newTestSession(3701, "mariokartwii", "1.2.3.4:5000")
+	defer removeTestSessions(3701, 3702, 3703, 3704)
+
+	var friends []*GameSpySession
+	for profileId := uint32(3702); profileId <= 3704; profileId++ {
+		friend, _ := newTestSession(profileId, "mariokartwii", "5.6.7.8:5000")
+		friend.FriendList = []uint32{3701}
+		recipient.FriendList = append(recipient.FriendList, profileId)
+		friends = append(friends, friend)
+	}
+
+	var wg sync.WaitGroup
+	for _, friend := range friends {
+		wg.Add(1)
+		go func(friend *GameSpySession) {
+			defer wg.Done()
+			friend.setStatus(common.GameSpyCommand{Command: "status", CommandValue: "1", OtherValues: map[string]string{"statstring": "Online", "locstring": ""}})
+			friend.setStatus(common.GameSpyCommand{Command: "status", CommandValue: "2", OtherValues: map[string]string{"statstring": "Racing", "locstring": ""}})
+		}(friend)
+	}
+	wg.Wait()
+
+	time.Sleep(150 * time.Millisecond)
+
+	recipientConn.mutex.Lock()
+	writes := recipientConn.writes
+	recipientConn.mutex.Unlock()
+	if writes != 1 {
+		t.Fatalf("expected one batched write, got %d", writes)
+	}
+
+	// Only the latest status of each friend is sent
+	commands, err := common.ParseGameSpyMessage(recipientConn.written())
+	if err != nil || len(commands) != 3 {
+		t.Fatalf("expected 3 status pushes, got %s", recipientConn.written())
+	}
+	for _, command := range commands {
+		if !strings.HasPrefix(command.OtherValues["msg"], "|s|2|") {
+			t.Errorf("stale status pushed: %s", command.OtherValues["msg"])
+		}
+	}
+}
+
+func TestInvisiblePresence(t *testing.T) {
+	friend, friendConn := newTestSession(3801, "mariokartwii", "1.2.3.4:5000")
+	player, _ := newTestSession(3802, "mariokartwii", "5.6.7.8:5000")
+	defer removeTestSessions(3801, 3802)
+
+	friend.FriendList = []uint32{3802}
+	friend.AuthFriendList = []uint32{3802}
+	player.FriendList = []uint32{3801}
+	player.AuthFriendList = []uint32{3801}
+
+	invisible := map[string]string{"statstring": "Online", "locstring": "", "wwfc_presence": PresenceInvisible}
+	player.setStatus(common.GameSpyCommand{Command: "status", CommandValue: "1", OtherValues: invisible})
+	if written := friendConn.written(); written != "" {
+		t.Fatalf("friend was notified of an invisible player: %s", written)
+	}
+
+	// Becoming visible shows the player online, going invisible again shows them offline
+	player.setStatus(common.GameSpyCommand{Command: "status", CommandValue: "1", OtherValues: map[string]string{"statstring": "Online", "locstring": ""}})
+	player.setStatus(common.GameSpyCommand{Command: "status", CommandValue: "1", OtherValues: invisible})
+
+	commands, err := common.ParseGameSpyMessage(friendConn.written())
+	if err != nil || len(commands) != 2 {
+		t.Fatalf("expected two status pushes, got %s", friendConn.written())
+	}
+	if !strings.HasPrefix(commands[0].OtherValues["msg"], "|s|1|") || commands[1].OtherValues["msg"] != logOutMessage {
+		t.Fatalf("unexpected status pushes: %s", friendConn.written())
+	}
+
+	// Logging out doesn't broadcast anything either
+	player.closeSession()
+	if commands, _ := common.ParseGameSpyMessage(friendConn.written()); len(commands) != 2 {
+		t.Fatalf("logout of an invisible player was broadcast: %s", friendConn.written())
+	}
+}
@@ -0,0 +1,21 @@
+package gpcm
+
+import (
+	"strconv"
+	"wwfc/common"
+)
+
+// Echo the console friend code from the login token back to the client, along
+// with the profile ID it is logged in as
+func (g *GameSpySession) getFriendCode(command common.GameSpyCommand) {
+	g.WriteBuffer += common.CreateGameSpyMessage(common.GameSpyCommand{
+		Command:      "wwfc_friendcode",
+		CommandValue: "",
+		OtherValues: map[string]string{
+			"cfc":       strconv.FormatUint(g.ConsoleFriendCode, 10),
+			"fc":        common.GetRawFriendCodeString(g.ConsoleFriendCode),
+			"profileid": strconv.FormatUint(uint64(g.User.ProfileId), 10),
+			"id":        command.OtherValues["id"],
+		},
+	})
+}
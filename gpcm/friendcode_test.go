@@ -0,0 +1,32 @@
+package gpcm
+
+import (
+	"testing"
+	"wwfc/common"
+	"wwfc/database"
+)
+
+func TestGetFriendCode(t *testing.T) {
+	previousDb := db
+	db = database.NewMemoryStore()
+	defer func() { db = previousDb }()
+
+	session, _ := loginTestSession(t, 300, 5301, "1.2.3.4:5000")
+	defer removeTestSessions(5301)
+
+	// The test login token carries no console friend code
+	session.ConsoleFriendCode = 123456789012
+
+	session.WriteBuffer = ""
+	session.getFriendCode(common.GameSpyCommand{Command: "wwfc_friendcode", OtherValues: map[string]string{"id": "2"}})
+
+	commands, err := common.ParseGameSpyMessage(session.WriteBuffer)
+	if err != nil || len(commands) != 1 || commands[0].Command != "wwfc_friendcode" {
+		t.Fatalf("unexpected reply: %s", session.WriteBuffer)
+	}
+
+	values := commands[0].OtherValues
+	if values["cfc"] != "123456789012" || values["fc"] != "1234-5678-9012" || values["profileid"] != "5301" || values["id"] != "2" {
+		t.Fatalf("reply does not match the session: %s", session.WriteBuffer)
+	}
+}
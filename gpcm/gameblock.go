@@ -0,0 +1,84 @@
+package gpcm
+
+import (
+	"strconv"
+	"strings"
+	"wwfc/common"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+type gameBlock struct {
+	gameName string
+	// -1 to match any region
+	region  int
+	message string
+}
+
+// Games and regions refused at login
+var gameBlocks []gameBlock
+
+func setGameBlocks(blocks []common.GPCMBlock) {
+	gameBlocks = nil
+	for _, block := range blocks {
+		region := -1
+		if block.Region != "" {
+			parsed, err := strconv.ParseUint(block.Region, 0, 8)
+			if err != nil {
+				logging.Error("GPCM", "Invalid region in block:", aurora.Cyan(block.Region))
+				continue
+			}
+			region = int(parsed)
+		}
+
+		gameBlocks = append(gameBlocks, gameBlock{
+			gameName: block.GameName,
+			region:   region,
+			message:  strings.TrimSpace(block.Message),
+		})
+	}
+}
+
+// Get the block matching the game and region, if any
+func findGameBlock(gameName string, region byte) (gameBlock, bool) {
+	for _, block := range gameBlocks {
+		if block.gameName != "" && block.gameName != gameName {
+			continue
+		}
+		if block.region >= 0 && block.region != int(region) {
+			continue
+		}
+		return block, true
+	}
+
+	return gameBlock{}, false
+}
+
+// Refuse the login if the game or region is blocked, telling the client why
+func (g *GameSpySession) checkGameBlock() bool {
+	block, blocked := findGameBlock(g.GameName, g.Region)
+	if !blocked {
+		return true
+	}
+
+	logging.Warn(g.ModuleName, "Refusing login for blocked game", aurora.Cyan(g.GameName), "in region", aurora.Cyan(g.Region))
+
+	message := block.message
+	if message == "" {
+		message = "This game is not available."
+	}
+
+	g.replyError(GPError{
+		ErrorCode:   ErrLogin.ErrorCode,
+		ErrorString: message,
+		Fatal:       true,
+		WWFCMessage: WWFCErrorMessage{
+			ErrorCode: WWFCMsgGameBlocked.ErrorCode,
+			MessageRMC: map[byte]string{
+				LangEnglish: strings.ReplaceAll(message, "%", "%%") + "\n\nError Code: %[1]d",
+			},
+		},
+	})
+	return false
+}
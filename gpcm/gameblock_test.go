@@ -0,0 +1,63 @@
+package gpcm
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf16"
+	"wwfc/common"
+)
+
+func TestBlockedGameMessage(t *testing.T) {
+	const message = "This title is temporarily disabled for maintenance"
+	setGameBlocks([]common.GPCMBlock{{GameName: "mariokartwii", Region: "2", Message: message}})
+	defer setGameBlocks(nil)
+
+	login := func(region byte) (*GameSpySession, *mockConn) {
+		conn := &mockConn{remote: "1.2.3.4:5000"}
+		session := &GameSpySession{Conn: conn, Challenge: common.RandomString(10)}
+
+		authToken, nasChallenge := common.MarshalNASAuthToken("RMCP", 9601, "RMCP", 0, region, LangEnglish, "Player", UnitCodeWii, false)
+		clientChallenge := common.RandomString(10)
+		session.login(common.GameSpyCommand{
+			Command: "login",
+			OtherValues: map[string]string{
+				"authtoken": authToken,
+				"challenge": clientChallenge,
+				"response":  generateResponse(session.Challenge, nasChallenge, authToken, clientChallenge),
+				"gamename":  "mariokartwii",
+				"id":        "1",
+			},
+		})
+		return session, conn
+	}
+
+	session, conn := login(RegionEurope)
+	if session.LoggedIn || !conn.closed {
+		t.Fatal("expected the blocked login to be refused")
+	}
+
+	commands, err := common.ParseGameSpyMessage(conn.written())
+	if err != nil || len(commands) != 1 || commands[0].Command != "error" {
+		t.Fatalf("expected an error reply, got %s", conn.written())
+	}
+	if commands[0].OtherValues["errmsg"] != message {
+		t.Fatalf("expected the block message, got %q", commands[0].OtherValues["errmsg"])
+	}
+
+	encoded, err := common.Base64DwcEncoding.DecodeString(commands[0].OtherValues["wwfc_errmsg"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	units := make([]uint16, len(encoded)/2)
+	for i := range units {
+		units[i] = uint16(encoded[i*2])<<8 | uint16(encoded[i*2+1])
+	}
+	if translated := string(utf16.Decode(units)); !strings.HasPrefix(translated, message) || !strings.Contains(translated, "22011") {
+		t.Fatalf("expected the block message for the game, got %q", translated)
+	}
+
+	// Other regions aren't blocked
+	if _, conn := login(RegionAmerica); strings.Contains(conn.written(), message) {
+		t.Fatalf("login from another region was blocked: %s", conn.written())
+	}
+}
@@ -0,0 +1,115 @@
+package gpcm
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"wwfc/common"
+	"wwfc/database"
+	"wwfc/qr2"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden reply files")
+
+// Render replies with their keys sorted, one command per line, since the key
+// order on the wire follows map iteration. Values that change on every run
+// are replaced with a placeholder.
+func canonicalReplies(t *testing.T, replies string, volatile []string) string {
+	commands, err := common.ParseGameSpyMessage(replies)
+	if err != nil {
+		t.Fatalf("invalid reply %q: %v", replies, err)
+	}
+
+	var builder strings.Builder
+	for _, command := range commands {
+		keys := make([]string, 0, len(command.OtherValues))
+		for key := range command.OtherValues {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintf(&builder, `\%s\%s`, command.Command, command.CommandValue)
+		for _, key := range keys {
+			value := command.OtherValues[key]
+			for _, volatileKey := range volatile {
+				if key == volatileKey {
+					value = "*"
+				}
+			}
+			fmt.Fprintf(&builder, `\%s\%s`, key, value)
+		}
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// Replay a recorded client message through a command handler and compare
+// everything the session sent back with the golden file
+func checkGolden(t *testing.T, name string, session *GameSpySession, conn *mockConn, request string, handler commandHandler, volatile []string) {
+	commands, err := common.ParseGameSpyMessage(request)
+	if err != nil {
+		t.Fatalf("%s: invalid request: %v", name, err)
+	}
+
+	session.handleCommand(commands[0].Command, commands, handler)
+	session.flushWriteBuffer()
+
+	actual := canonicalReplies(t, conn.written(), volatile)
+	path := filepath.Join("testdata", "golden", name+".golden")
+
+	if *updateGolden {
+		if err := os.WriteFile(path, []byte(actual), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%s: %v (run with -update to create it)", name, err)
+	}
+	if actual != string(expected) {
+		t.Errorf("%s: reply does not match the golden file\nexpected:\n%s\nactual:\n%s", name, expected, actual)
+	}
+}
+
+func TestGoldenReplies(t *testing.T) {
+	previousDb := db
+	db = database.NewMemoryStore()
+	defer func() { db = previousDb }()
+
+	// Login
+	conn := &mockConn{remote: "1.2.3.4:5000"}
+	session := &GameSpySession{
+		Conn:           conn,
+		ConnID:         common.RandomUUID(),
+		Challenge:      "ABCDEFGHIJ",
+		FriendList:     []uint32{},
+		AuthFriendList: []uint32{},
+	}
+	defer func() {
+		session.LoggedIn = false
+		removeTestSessions(9701)
+		qr2.Logout(9701)
+	}()
+
+	authToken, nasChallenge := common.MarshalNASAuthToken("ATDE", 300, "ATDE", 0, RegionEurope, LangGerman, "Player", UnitCodeDS, false)
+	clientChallenge := "rc5lU5V5skphHnc1eSuXh8j2EzyI2TZP"
+	response := generateResponse(session.Challenge, nasChallenge, authToken, clientChallenge)
+
+	request := `\login\\challenge\` + clientChallenge + `\authtoken\` + authToken + `\partnerid\11\response\` + response + `\firewall\1\port\0\productid\11059\gamename\tetrisds\namespaceid\16\sdkrevision\3\profileid\9701\quiet\0\id\1\final\`
+	checkGolden(t, "login", session, conn, request, session.login, []string{"sesskey", "proof", "lt"})
+
+	// Profile of the logged in session
+	conn.output.Reset()
+	checkGolden(t, "getprofile", session, conn, `\getprofile\\sesskey\1\profileid\9701\id\2\final\`, session.getProfile, []string{"sig"})
+
+	// Error for a login with an invalid auth token
+	errorConn := &mockConn{remote: "5.6.7.8:5000"}
+	errorSession := &GameSpySession{Conn: errorConn, ConnID: common.RandomUUID(), Challenge: "ABCDEFGHIJ"}
+	checkGolden(t, "error", errorSession, errorConn, `\login\\challenge\abc\authtoken\invalid\partnerid\11\response\0\gamename\tetrisds\id\1\final\`, errorSession.login, nil)
+}
@@ -0,0 +1,75 @@
+package gpcm
+
+import (
+	"sort"
+	"sync"
+	"time"
+	"wwfc/common"
+)
+
+// Number of received commands kept for each session
+const commandHistorySize = 32
+
+// A received command, without its values
+type CommandHistoryEntry struct {
+	Command string    `json:"command"`
+	Keys    []string  `json:"keys"`
+	Time    time.Time `json:"time"`
+}
+
+type commandHistory struct {
+	mutex   sync.Mutex
+	entries [commandHistorySize]CommandHistoryEntry
+	next    int
+	count   int
+}
+
+func (h *commandHistory) record(commands []common.GameSpyCommand) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := time.Now()
+	for _, command := range commands {
+		keys := make([]string, 0, len(command.OtherValues))
+		for key := range command.OtherValues {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		h.entries[h.next] = CommandHistoryEntry{
+			Command: command.Command,
+			Keys:    keys,
+			Time:    now,
+		}
+		h.next = (h.next + 1) % commandHistorySize
+		if h.count < commandHistorySize {
+			h.count++
+		}
+	}
+}
+
+// Get the entries from oldest to newest
+func (h *commandHistory) get() []CommandHistoryEntry {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	entries := make([]CommandHistoryEntry, 0, h.count)
+	for i := 0; i < h.count; i++ {
+		entries = append(entries, h.entries[(h.next-h.count+i+commandHistorySize)%commandHistorySize])
+	}
+	return entries
+}
+
+// Get the last commands received from a profile's session, oldest first, so
+// an operator can see what a misbehaving client sent without packet logging
+func GetCommandHistory(profileId uint32) ([]CommandHistoryEntry, bool) {
+	mutex.Lock()
+	session, exists := sessions[profileId]
+	mutex.Unlock()
+
+	if !exists {
+		return nil, false
+	}
+
+	return session.history.get(), true
+}
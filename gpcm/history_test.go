@@ -0,0 +1,45 @@
+package gpcm
+
+import (
+	"strconv"
+	"testing"
+	"wwfc/common"
+)
+
+func TestCommandHistory(t *testing.T) {
+	session, _ := newTestSession(9101, "mariokartwii", "1.2.3.4:5000")
+	defer removeTestSessions(9101)
+
+	session.history.record([]common.GameSpyCommand{
+		{Command: "ka"},
+		{Command: "status", OtherValues: map[string]string{"statstring": "secret", "locstring": "secret"}},
+	})
+	session.history.record([]common.GameSpyCommand{{Command: "getprofile", OtherValues: map[string]string{"profileid": "1"}}})
+
+	history, exists := GetCommandHistory(9101)
+	if !exists {
+		t.Fatal("no history for the session")
+	}
+
+	expected := []string{"ka", "status", "getprofile"}
+	if len(history) != len(expected) {
+		t.Fatalf("expected %d entries, got %d", len(expected), len(history))
+	}
+	for i, entry := range history {
+		if entry.Command != expected[i] {
+			t.Errorf("entry %d: expected %s, got %s", i, expected[i], entry.Command)
+		}
+	}
+	if keys := history[1].Keys; len(keys) != 2 || keys[0] != "locstring" || keys[1] != "statstring" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+
+	// Only the most recent commands are kept
+	for i := 0; i < commandHistorySize; i++ {
+		session.history.record([]common.GameSpyCommand{{Command: "cmd" + strconv.Itoa(i)}})
+	}
+	history, _ = GetCommandHistory(9101)
+	if len(history) != commandHistorySize || history[0].Command != "cmd0" || history[commandHistorySize-1].Command != "cmd"+strconv.Itoa(commandHistorySize-1) {
+		t.Fatalf("history did not wrap around correctly: %v", history)
+	}
+}
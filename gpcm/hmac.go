@@ -0,0 +1,78 @@
+package gpcm
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"wwfc/common"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// Require a valid wwfc_hmac value on WWFC custom commands
+var requireCommandHMAC bool
+
+// Derive the key used to sign WWFC commands from values both sides know after
+// the login
+func deriveCommandKey(serverChallenge string, clientChallenge string, authToken string) []byte {
+	mac := hmac.New(sha256.New, []byte(authToken))
+	mac.Write([]byte(serverChallenge))
+	mac.Write([]byte(clientChallenge))
+	return mac.Sum(nil)
+}
+
+// Calculate the HMAC of a command: the command name and value followed by each
+// other key and value sorted by key, all separated by backslashes, leaving out
+// the wwfc_hmac value itself
+func calculateCommandHMAC(key []byte, command common.GameSpyCommand) string {
+	keys := make([]string, 0, len(command.OtherValues))
+	for k := range command.OtherValues {
+		if k != "wwfc_hmac" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var builder strings.Builder
+	builder.WriteString(command.Command + `\` + command.CommandValue)
+	for _, k := range keys {
+		builder.WriteString(`\` + k + `\` + command.OtherValues[k])
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(builder.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Drop WWFC commands with a missing or invalid HMAC when it's required
+func (g *GameSpySession) verifyCommandHMACs(commands []common.GameSpyCommand) []common.GameSpyCommand {
+	if !requireCommandHMAC {
+		return commands
+	}
+
+	var verified []common.GameSpyCommand
+	for _, command := range commands {
+		if !strings.HasPrefix(command.Command, "wwfc_") {
+			verified = append(verified, command)
+			continue
+		}
+
+		expected := calculateCommandHMAC(g.CommandKey, command)
+		if hmac.Equal([]byte(strings.ToLower(command.OtherValues["wwfc_hmac"])), []byte(expected)) {
+			verified = append(verified, command)
+			continue
+		}
+
+		logging.Error(g.ModuleName, "Rejecting command with a missing or invalid HMAC:", aurora.Cyan(command.Command))
+		g.replyError(GPError{
+			ErrorCode:   ErrParse.ErrorCode,
+			ErrorString: "The command signature is invalid.",
+			Fatal:       false,
+		})
+	}
+
+	return verified
+}
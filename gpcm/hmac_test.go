@@ -0,0 +1,35 @@
+package gpcm
+
+import (
+	"strings"
+	"testing"
+	"wwfc/common"
+)
+
+func TestCommandHMAC(t *testing.T) {
+	requireCommandHMAC = true
+	defer func() { requireCommandHMAC = false }()
+
+	session, conn := newTestSession(9201, "mariokartwii", "1.2.3.4:5000")
+	defer removeTestSessions(9201)
+	session.CommandKey = deriveCommandKey("SERVERCHAL", "clientchallenge", "authtoken")
+
+	signed := common.GameSpyCommand{Command: "wwfc_population", OtherValues: map[string]string{"id": "1"}}
+	signed.OtherValues["wwfc_hmac"] = calculateCommandHMAC(session.CommandKey, signed)
+
+	tampered := common.GameSpyCommand{Command: "wwfc_population", OtherValues: map[string]string{"id": "2", "wwfc_hmac": signed.OtherValues["wwfc_hmac"]}}
+	unsigned := common.GameSpyCommand{Command: "wwfc_nattype", OtherValues: map[string]string{"id": "3"}}
+	other := common.GameSpyCommand{Command: "getprofile", OtherValues: map[string]string{"id": "4"}}
+
+	verified := session.verifyCommandHMACs([]common.GameSpyCommand{signed, tampered, unsigned, other})
+	if len(verified) != 2 || verified[0].OtherValues["id"] != "1" || verified[1].Command != "getprofile" {
+		t.Fatalf("unexpected commands accepted: %v", verified)
+	}
+
+	if count := strings.Count(conn.written(), `\error\`); count != 2 {
+		t.Fatalf("expected 2 errors, got %d: %s", count, conn.written())
+	}
+	if conn.closed {
+		t.Fatal("an invalid HMAC should not close the connection")
+	}
+}
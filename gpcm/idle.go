@@ -0,0 +1,41 @@
+package gpcm
+
+import (
+	"time"
+)
+
+// Connections without a keep alive, login or status update for this long are
+// closed, 0 to disable
+var idleTimeout time.Duration
+
+// Record activity that keeps the connection from being closed as idle
+func (g *GameSpySession) touchActivity() {
+	g.lastActivity.Store(time.Now().UnixNano())
+}
+
+// Make the next read fail once the connection has been idle for too long, or
+// when an incomplete message should have been finished or the pre-login
+// throughput is due to be checked
+func (g *GameSpySession) setReadDeadline() {
+	var deadline time.Time
+	if idleTimeout > 0 {
+		deadline = time.Unix(0, g.lastActivity.Load()).Add(idleTimeout)
+	}
+
+	if pending := g.readBuffer.deadline(); !pending.IsZero() && (deadline.IsZero() || pending.Before(deadline)) {
+		deadline = pending
+	}
+
+	if !g.LoggedIn {
+		if window := g.throughput.deadline(); !window.IsZero() && (deadline.IsZero() || window.Before(deadline)) {
+			deadline = window
+		}
+	}
+
+	g.Conn.SetReadDeadline(deadline)
+}
+
+// Check if the connection has been idle for too long
+func (g *GameSpySession) isIdle() bool {
+	return idleTimeout > 0 && time.Since(time.Unix(0, g.lastActivity.Load())) >= idleTimeout
+}
@@ -0,0 +1,61 @@
+package gpcm
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeepAliveResetsIdleTimeout(t *testing.T) {
+	idleTimeout = 200 * time.Millisecond
+	defer func() { idleTimeout = 0 }()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	done := make(chan struct{})
+	defer func() { <-done }()
+
+	go func() {
+		defer close(done)
+		conn, err := listener.Accept()
+		if err == nil {
+			handleRequest(conn)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	buffer := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := conn.Read(buffer); err != nil || !strings.HasPrefix(string(buffer[:n]), `\lc\1\`) {
+		t.Fatalf("expected a challenge, got %q (%v)", buffer[:n], err)
+	}
+
+	// Stay idle apart from keep alives for well past the timeout
+	for i := 0; i < 8; i++ {
+		time.Sleep(75 * time.Millisecond)
+
+		if _, err := conn.Write([]byte(`\ka\\final\`)); err != nil {
+			t.Fatalf("connection closed despite keep alives: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if n, err := conn.Read(buffer); err != nil || !strings.HasPrefix(string(buffer[:n]), `\ka\`) {
+			t.Fatalf("connection closed despite keep alives: %q (%v)", buffer[:n], err)
+		}
+	}
+
+	// Without them the connection is closed
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(buffer); err == nil || strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("expected the idle connection to be closed, got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package gpcm
+
+import (
+	"net"
+	"sync"
+)
+
+// IP addresses refused before the challenge is sent, separate from profile and
+// device bans which are only checked at login
+var (
+	bannedIPs      = map[string]bool{}
+	bannedIPsMutex = sync.RWMutex{}
+)
+
+func setBannedIPs(ips []string) {
+	bannedIPsMutex.Lock()
+	defer bannedIPsMutex.Unlock()
+
+	bannedIPs = map[string]bool{}
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			bannedIPs[parsed.String()] = true
+		}
+	}
+}
+
+func BanIP(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	bannedIPsMutex.Lock()
+	defer bannedIPsMutex.Unlock()
+
+	bannedIPs[parsed.String()] = true
+	return true
+}
+
+func UnbanIP(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	bannedIPsMutex.Lock()
+	defer bannedIPsMutex.Unlock()
+
+	if !bannedIPs[parsed.String()] {
+		return false
+	}
+
+	delete(bannedIPs, parsed.String())
+	return true
+}
+
+func isAddressBanned(addr net.Addr) bool {
+	host := addr.String()
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		host = tcpAddr.IP.String()
+	} else if splitHost, _, err := net.SplitHostPort(host); err == nil {
+		host = splitHost
+	}
+
+	bannedIPsMutex.RLock()
+	defer bannedIPsMutex.RUnlock()
+
+	return bannedIPs[host]
+}
@@ -0,0 +1,26 @@
+package gpcm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBannedIPClosedBeforeChallenge(t *testing.T) {
+	if !BanIP("203.0.113.9") {
+		t.Fatal("failed to ban IP")
+	}
+	defer UnbanIP("203.0.113.9")
+
+	conn := &mockConn{remote: "203.0.113.9:5000"}
+	handleRequest(conn)
+
+	if !conn.closed {
+		t.Fatal("connection from a banned IP was left open")
+	}
+	if strings.Contains(conn.written(), `\lc\`) {
+		t.Fatal("challenge was sent to a banned IP")
+	}
+	if !strings.Contains(conn.written(), `\error\`) {
+		t.Fatalf("no notice was sent to the banned IP: %s", conn.written())
+	}
+}
@@ -1,5 +1,10 @@
 package gpcm
 
+import (
+	"wwfc/natneg"
+	"wwfc/qr2"
+)
+
 func kickPlayer(profileID uint32, reason string) {
 	if session, exists := sessions[profileID]; exists {
 		errorMessage := WWFCMsgKickedGeneric
@@ -45,3 +50,21 @@ func KickPlayer(profileID uint32, reason string) {
 
 	kickPlayer(profileID, reason)
 }
+
+// DisconnectProfile kicks the profile from GPCM, removes its QR2 login and
+// server registration, and closes any NATNEG sessions it is negotiating in.
+func DisconnectProfile(profileID uint32, reason string) {
+	qr2Address, hasQR2Session := qr2.GetLoginAddress(profileID)
+
+	mutex.Lock()
+	kickPlayer(profileID, reason)
+	mutex.Unlock()
+
+	// The GPCM session will also log out of QR2 when it closes, but do it now so
+	// the server registration is gone by the time this returns
+	qr2.Logout(profileID)
+
+	if hasQR2Session {
+		natneg.CloseSessionsForAddress(qr2Address)
+	}
+}
@@ -0,0 +1,22 @@
+package gpcm
+
+import (
+	"testing"
+	"wwfc/qr2"
+)
+
+func TestDisconnectProfile(t *testing.T) {
+	_, conn := newTestSession(2001, "mariokartwii", "1.2.3.4:5000")
+	defer removeTestSessions(2001)
+
+	qr2.Login(2001, "RMCJ", "Player", 0, "1.2.3.4:5000", false, true, false, KickPlayer)
+
+	DisconnectProfile(2001, "banned")
+
+	if !conn.closed {
+		t.Error("GPCM connection was not closed")
+	}
+	if qr2.IsLoggedIn(2001) {
+		t.Error("QR2 login was not removed")
+	}
+}
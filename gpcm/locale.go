@@ -0,0 +1,38 @@
+package gpcm
+
+import "strconv"
+
+const (
+	RegionJapan     = 0x00
+	RegionAmerica   = 0x01
+	RegionEurope    = 0x02
+	RegionAustralia = 0x03
+	RegionKorea     = 0x04
+	RegionTaiwan    = 0x05
+	RegionChina     = 0x06
+)
+
+// Country codes for regions that map to a single country
+var regionCountryCodes = map[byte]string{
+	RegionJapan:     "JP",
+	RegionAmerica:   "US",
+	RegionAustralia: "AU",
+	RegionKorea:     "KR",
+	RegionTaiwan:    "TW",
+	RegionChina:     "CN",
+}
+
+// Add the region and language fields to a profile reply. The profile's own
+// region is used if known, otherwise the requesting client's region is used.
+func (g *GameSpySession) addLocaleValues(values map[string]string, region byte, lang byte, known bool) {
+	if !known {
+		region = g.Region
+		lang = g.Language
+	}
+
+	values["region"] = strconv.Itoa(int(region))
+	values["lang"] = strconv.Itoa(int(lang))
+	if countryCode, exists := regionCountryCodes[region]; exists {
+		values["countrycode"] = countryCode
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"wwfc/common"
 	"wwfc/database"
 	"wwfc/logging"
+	"wwfc/metrics"
 	"wwfc/qr2"
 
 	"github.com/logrusorgru/aurora/v3"
@@ -43,6 +44,41 @@ func generateProof(gpcmChallenge, nasChallenge, authToken, clientChallenge strin
 	return generateResponse(clientChallenge, nasChallenge, authToken, gpcmChallenge)
 }
 
+// Find the likely cause of an incorrect login response, for debugging client
+// implementations. Only hash prefixes are included so the secret parts of the
+// response are not logged.
+func diagnoseLoginResponse(gpcmChallenge, nasChallenge, authToken, clientChallenge, received string) string {
+	expected := generateResponse(gpcmChallenge, nasChallenge, authToken, clientChallenge)
+
+	if _, err := hex.DecodeString(received); err != nil || len(received) != len(expected) {
+		return fmt.Sprintf("response is not an MD5 hex digest (length %d)", len(received))
+	}
+
+	if strings.ToLower(received) == expected {
+		return "response hex digest must be lowercase"
+	}
+
+	if received == generateProof(gpcmChallenge, nasChallenge, authToken, clientChallenge) {
+		return "server and client challenges are swapped"
+	}
+
+	if clientChallenge == "" {
+		return "client challenge is missing"
+	}
+
+	if received == generateResponse(gpcmChallenge, "", authToken, clientChallenge) {
+		return "NAS challenge was not included"
+	}
+
+	if received == generateResponse("", nasChallenge, authToken, clientChallenge) {
+		return "server challenge was not included"
+	}
+
+	nasChallengeHash := md5.Sum([]byte(nasChallenge))
+	return fmt.Sprintf("unknown component; server challenge %q, client challenge %q, NAS challenge hash %s..., expected %s..., received %s...",
+		gpcmChallenge, clientChallenge, hex.EncodeToString(nasChallengeHash[:])[:8], expected[:8], received[:8])
+}
+
 var msPublicKey = []byte{
 	0x00, 0xFD, 0x56, 0x04, 0x18, 0x2C, 0xF1, 0x75, 0x09, 0x21, 0x00, 0xC3, 0x08, 0xAE, 0x48, 0x39,
 	0x91, 0x1B, 0x6F, 0x9F, 0xA1, 0xD5, 0x3A, 0x95, 0xAF, 0x08, 0x33, 0x49, 0x47, 0x2B, 0x00, 0x01,
@@ -144,6 +180,8 @@ func verifySignature(moduleName string, authToken string, signature string) uint
 }
 
 func (g *GameSpySession) login(command common.GameSpyCommand) {
+	loginStart := time.Now()
+
 	if g.LoggedIn {
 		logging.Error(g.ModuleName, "Attempt to login twice")
 		g.replyError(ErrLogin)
@@ -162,6 +200,8 @@ func (g *GameSpySession) login(command common.GameSpyCommand) {
 		return
 	}
 
+	g.TestLogin = g.isTestLogin(userId)
+
 	currentTime := time.Now()
 	if issueTime.Before(currentTime.Add(-10*time.Minute)) || issueTime.After(currentTime) {
 		g.replyError(ErrLoginLoginTicketExpired)
@@ -170,6 +210,15 @@ func (g *GameSpySession) login(command common.GameSpyCommand) {
 
 	g.GameName = command.OtherValues["gamename"]
 	logging.Info(g.ModuleName, "Game name:", aurora.Cyan(g.GameName))
+
+	if !g.checkSDKRevision(command) {
+		return
+	}
+
+	if !g.checkGameCode(gamecd) {
+		return
+	}
+
 	g.GameCode = gamecd
 	g.Region = region
 	g.Language = lang
@@ -189,6 +238,10 @@ func (g *GameSpySession) login(command common.GameSpyCommand) {
 
 	g.LoginInfoSet = true
 
+	if !g.checkGameBlock() {
+		return
+	}
+
 	if g.GameName != "mahjongkcds" && common.GetExpectedUnitCode(g.GameName) != unitcd {
 		logging.Error(g.ModuleName, "Incorrect unit code specified:", aurora.Cyan(unitcd))
 		g.replyError(ErrLogin)
@@ -229,6 +282,7 @@ func (g *GameSpySession) login(command common.GameSpyCommand) {
 
 	response := generateResponse(g.Challenge, challenge, authToken, command.OtherValues["challenge"])
 	if response != command.OtherValues["response"] {
+		logging.Info(g.ModuleName, "Login response mismatch:", diagnoseLoginResponse(g.Challenge, challenge, authToken, command.OtherValues["challenge"], command.OtherValues["response"]))
 		g.replyError(ErrLogin)
 		return
 	}
@@ -255,8 +309,9 @@ func (g *GameSpySession) login(command common.GameSpyCommand) {
 		return
 	}
 
-	g.ModuleName = "GPCM:" + strconv.FormatInt(int64(g.User.ProfileId), 10) + "*"
-	g.ModuleName += "/" + common.CalcFriendCodeString(g.User.ProfileId, "RMCJ") + "*"
+	g.BlockList = g.store().GetBlockedProfiles(g.User.ProfileId)
+
+	g.setModuleName("GPCM:" + strconv.FormatInt(int64(g.User.ProfileId), 10) + "*" + "/" + common.CalcFriendCodeString(g.User.ProfileId, "RMCJ") + "*")
 
 	// Check to see if a session is already open with this profile ID
 	mutex.Lock()
@@ -284,16 +339,22 @@ func (g *GameSpySession) login(command common.GameSpyCommand) {
 		}
 	}
 	sessions[g.User.ProfileId] = g
+	cancelLogoutStatus(g.User.ProfileId)
+	metrics.Gauge("gpcm_sessions", float64(len(sessions)))
 	mutex.Unlock()
 
 	g.AuthToken = authToken
+	g.CommandKey = deriveCommandKey(g.Challenge, command.OtherValues["challenge"], authToken)
 	g.LoginTicket = common.MarshalGPCMLoginTicket(g.User.ProfileId)
 	g.SessionKey = rand.Int31n(290000000) + 10000000
 
 	g.DeviceAuthenticated = deviceAuth
 	g.LoggedIn = true
-	g.ModuleName = "GPCM:" + strconv.FormatInt(int64(g.User.ProfileId), 10)
-	g.ModuleName += "/" + common.CalcFriendCodeString(g.User.ProfileId, "RMCJ")
+	g.touchActivity()
+	g.setModuleName("GPCM:" + strconv.FormatInt(int64(g.User.ProfileId), 10) + "/" + common.CalcFriendCodeString(g.User.ProfileId, "RMCJ"))
+
+	metrics.Counter("gpcm_logins_total", 1, "game", g.GameName)
+	metrics.Histogram("gpcm_login_seconds", time.Since(loginStart).Seconds())
 
 	// Notify QR2 of the login
 	qr2.Login(g.User.ProfileId, gamecd, ingamesn, cfc, g.Conn.RemoteAddr().String(), g.NeedsExploit, g.DeviceAuthenticated, g.User.Restricted, KickPlayer)
@@ -322,23 +383,28 @@ func (g *GameSpySession) login(command common.GameSpyCommand) {
 		}
 	}
 
+	// Clients that ask for it get the server version and time for
+	// compatibility checks and clock sync
+	if command.OtherValues["wwfc_serverinfo"] == "1" {
+		otherValues["wwfc_version"] = common.ServerVersion
+		otherValues["wwfc_time"] = strconv.FormatInt(time.Now().UnixMilli(), 10)
+	}
+
 	payload := common.CreateGameSpyMessage(common.GameSpyCommand{
 		Command:      "lc",
 		CommandValue: "2",
 		OtherValues:  otherValues,
 	})
 
-	g.Conn.Write([]byte(payload))
+	g.write([]byte(payload))
 
 	// Now start sending keep alive packets every 5 minutes
 	go func() {
 		for {
 			time.Sleep(5 * time.Minute)
-			if !g.LoggedIn {
+			if !g.LoggedIn || !g.write([]byte(`\ka\\final\`)) {
 				return
 			}
-
-			g.Conn.Write([]byte(`\ka\\final\`))
 		}
 	}()
 }
@@ -436,7 +502,26 @@ func (g *GameSpySession) performLoginWithDatabase(userId uint64, gsbrCode string
 		ipAddress = ipAddress[:strings.Index(ipAddress, ":")]
 	}
 
-	user, err := database.LoginUserToGPCM(pool, ctx, userId, gsbrCode, profileId, deviceId, ipAddress, g.InGameName)
+	release := acquireLoginSlot()
+	if release == nil {
+		logging.Error(g.ModuleName, "Timed out waiting for a login slot")
+		g.replyError(GPError{
+			ErrorCode:   ErrLogin.ErrorCode,
+			ErrorString: "The server is busy, please try again later.",
+			Fatal:       true,
+			WWFCMessage: WWFCMsgUnknownLoginError,
+		})
+		return false
+	}
+
+	user, err := g.store().LoginUserToGPCM(userId, gsbrCode, profileId, deviceId, ipAddress, g.InGameName)
+	if err == database.ErrDeviceIDMismatch {
+		g.recordDeviceMismatch(userId, gsbrCode, deviceId)
+		if flagDeviceMismatch {
+			user, err = g.loginWithMismatchedDevice(userId, gsbrCode, profileId, deviceId, ipAddress)
+		}
+	}
+	release()
 	g.User = user
 
 	if err != nil {
@@ -493,9 +578,6 @@ func (g *GameSpySession) performLoginWithDatabase(userId uint64, gsbrCode string
 }
 
 func IsLoggedIn(profileID uint32) bool {
-	mutex.Lock()
-	defer mutex.Unlock()
-
-	session, exists := sessions[profileID]
-	return exists && session.LoggedIn
+	online, _ := IsOnline(profileID)
+	return online
 }
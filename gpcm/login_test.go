@@ -0,0 +1,135 @@
+package gpcm
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"wwfc/common"
+	"wwfc/database"
+	"wwfc/qr2"
+)
+
+func TestDiagnoseLoginResponse(t *testing.T) {
+	gpcmChallenge := "ABCDEFGHIJ"
+	nasChallenge := "NASCHALL"
+	authToken := "NDSauthtoken"
+	clientChallenge := "clientchallenge"
+
+	tests := []struct {
+		received string
+		contains string
+	}{
+		{"short", "not an MD5 hex digest"},
+		{strings.ToUpper(generateResponse(gpcmChallenge, nasChallenge, authToken, clientChallenge)), "lowercase"},
+		{generateProof(gpcmChallenge, nasChallenge, authToken, clientChallenge), "swapped"},
+		{generateResponse(gpcmChallenge, "", authToken, clientChallenge), "NAS challenge"},
+		{generateResponse("", nasChallenge, authToken, clientChallenge), "server challenge was not included"},
+		{"00000000000000000000000000000000", "unknown component"},
+	}
+
+	for _, test := range tests {
+		diagnosis := diagnoseLoginResponse(gpcmChallenge, nasChallenge, authToken, clientChallenge, test.received)
+		if !strings.Contains(diagnosis, test.contains) {
+			t.Errorf("expected diagnosis containing %q, got %q", test.contains, diagnosis)
+		}
+		if strings.Contains(diagnosis, nasChallenge) {
+			t.Errorf("diagnosis leaks the NAS challenge: %q", diagnosis)
+		}
+	}
+}
+
+// Log in a new session through the login handler
+func loginTestSession(t *testing.T, userId uint64, profileId uint32, remote string) (*GameSpySession, *mockConn) {
+	return loginTestSessionWith(t, userId, profileId, remote, nil)
+}
+
+// Log in a new session with extra values in the login command
+func loginTestSessionWith(t *testing.T, userId uint64, profileId uint32, remote string, extra map[string]string) (*GameSpySession, *mockConn) {
+	conn := &mockConn{remote: remote}
+	session := &GameSpySession{
+		Conn:           conn,
+		ConnID:         common.RandomUUID(),
+		Challenge:      common.RandomString(10),
+		FriendList:     []uint32{},
+		AuthFriendList: []uint32{},
+	}
+
+	authToken, nasChallenge := common.MarshalNASAuthToken("ATDE", userId, "ATDE", 0, RegionEurope, LangGerman, "Player", UnitCodeDS, false)
+	clientChallenge := common.RandomString(10)
+
+	values := map[string]string{
+		"authtoken": authToken,
+		"challenge": clientChallenge,
+		"response":  generateResponse(session.Challenge, nasChallenge, authToken, clientChallenge),
+		"gamename":  "tetrisds",
+		"profileid": strconv.FormatUint(uint64(profileId), 10),
+		"id":        "1",
+	}
+	for key, value := range extra {
+		values[key] = value
+	}
+
+	session.login(common.GameSpyCommand{Command: "login", OtherValues: values})
+
+	if !session.LoggedIn {
+		t.Fatalf("login failed: %s", conn.written())
+	}
+
+	return session, conn
+}
+
+func TestLoginAndAddFriend(t *testing.T) {
+	previousDb := db
+	store := database.NewMemoryStore()
+	db = store
+	defer func() { db = previousDb }()
+
+	first, firstConn := loginTestSession(t, 100, 5001, "1.2.3.4:5000")
+	second, _ := loginTestSession(t, 200, 5002, "5.6.7.8:5000")
+	defer func() {
+		first.LoggedIn, second.LoggedIn = false, false
+		removeTestSessions(5001, 5002)
+		qr2.Logout(5001)
+		qr2.Logout(5002)
+	}()
+
+	if !strings.Contains(firstConn.written(), `\lc\2\`) {
+		t.Fatalf("missing login reply: %s", firstConn.written())
+	}
+
+	stored, exists := store.GetProfile(5001)
+	if !exists || stored.UserId != 100 || stored.LastName != "000000000ATDE" {
+		t.Fatalf("user not stored correctly: %+v", stored)
+	}
+
+	first.addFriend(common.GameSpyCommand{Command: "addbuddy", OtherValues: map[string]string{"newprofileid": "5002"}})
+	second.addFriend(common.GameSpyCommand{Command: "addbuddy", OtherValues: map[string]string{"newprofileid": "5001"}})
+
+	if !first.isFriendAuthorized(5002) || !second.isFriendAuthorized(5001) {
+		t.Fatalf("friendship not authorized: %v %v", first.AuthFriendList, second.AuthFriendList)
+	}
+}
+
+func TestLoginServerInfo(t *testing.T) {
+	previousDb := db
+	db = database.NewMemoryStore()
+	defer func() { db = previousDb }()
+	defer removeTestSessions(5401, 5402)
+
+	_, conn := loginTestSessionWith(t, 401, 5401, "1.2.3.4:5000", map[string]string{"wwfc_serverinfo": "1"})
+	commands, err := common.ParseGameSpyMessage(conn.written())
+	if err != nil || len(commands) == 0 || commands[0].Command != "lc" {
+		t.Fatalf("unexpected login reply: %s", conn.written())
+	}
+
+	serverTime, err := strconv.ParseInt(commands[0].OtherValues["wwfc_time"], 10, 64)
+	if commands[0].OtherValues["wwfc_version"] != common.ServerVersion || err != nil || time.Since(time.UnixMilli(serverTime)).Abs() > time.Minute {
+		t.Fatalf("server info missing from login reply: %s", conn.written())
+	}
+
+	_, conn = loginTestSession(t, 402, 5402, "5.6.7.8:5000")
+	if strings.Contains(conn.written(), `\wwfc_version\`) || strings.Contains(conn.written(), `\wwfc_time\`) {
+		t.Fatalf("server info sent to a client that did not ask for it: %s", conn.written())
+	}
+}
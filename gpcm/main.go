@@ -7,9 +7,15 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
 	"wwfc/common"
 	"wwfc/database"
 	"wwfc/logging"
+	"wwfc/metrics"
+	"wwfc/natneg"
 	"wwfc/qr2"
 
 	"github.com/jackc/pgx/v4/pgxpool"
@@ -19,6 +25,7 @@ import (
 
 type GameSpySession struct {
 	Conn                net.Conn
+	ConnID              string
 	WriteBuffer         string
 	User                database.User
 	ModuleName          string
@@ -26,6 +33,8 @@ type GameSpySession struct {
 	DeviceAuthenticated bool
 	Challenge           string
 	AuthToken           string
+	CommandKey          []byte
+	SDKRevision         int
 	LoginTicket         string
 	SessionKey          int32
 
@@ -37,50 +46,100 @@ type GameSpySession struct {
 	InGameName        string
 	ConsoleFriendCode uint64
 	DeviceId          uint32
+	DeviceMismatch    bool
 	HostPlatform      string
 	UnitCode          byte
 
 	Status         string
+	StatusCode     int
 	LocString      string
+	Presence       string
 	FriendList     []uint32
 	AuthFriendList []uint32
+	BlockList      []uint32
 
-	QR2IP          uint64
-	Reservation    common.MatchCommandData
-	ReservationPID uint32
+	QR2IP            uint64
+	Reservation      common.MatchCommandData
+	ReservationPID   uint32
+	ReservationSkill uint32
+	ReservationSince time.Time
+	// NATNEG cookie prepared for the pairing, 0 if not paired
+	ReservationCookie uint32
 
 	NeedsExploit bool
+	TestLogin    bool
+
+	history       commandHistory
+	profileUpdate profileUpdateState
+	lastActivity  atomic.Int64
+	readBuffer    messageBuffer
+	throughput    throughputMeter
+	pendingStatus statusBatch
+	closing       atomic.Bool
 }
 
 var (
 	ctx  = context.Background()
 	pool *pgxpool.Pool
+	db   database.Store
 	// I would use a sync.Map instead of the map mutex combo, but this performs better.
 	sessions = map[uint32]*GameSpySession{}
 	mutex    = deadlock.Mutex{}
 
 	allowDefaultDolphinKeys bool
+
+	// Delay before friends are told a disconnected profile is offline
+	offlineGracePeriod time.Duration
+	pendingLogouts     = map[uint32]*time.Timer{}
+
+	// Include the server time in keep alive replies so clients can measure latency
+	keepAliveTimestamp bool
 )
 
 func StartServer() {
 	// Get config
 	config := common.GetConfig()
 
-	// Start SQL
-	dbString := fmt.Sprintf("postgres://%s:%s@%s/%s", config.Username, config.Password, config.DatabaseAddress, config.DatabaseName)
-	dbConf, err := pgxpool.ParseConfig(dbString)
-	if err != nil {
-		panic(err)
-	}
+	if setTestLogin(config) {
+		db = testLoginDb
+	} else {
+		// Start SQL
+		dbString := fmt.Sprintf("postgres://%s:%s@%s/%s", config.Username, config.Password, config.DatabaseAddress, config.DatabaseName)
+		dbConf, err := pgxpool.ParseConfig(dbString)
+		if err != nil {
+			panic(err)
+		}
 
-	pool, err = pgxpool.ConnectConfig(ctx, dbConf)
-	if err != nil {
-		panic(err)
-	}
+		pool, err = pgxpool.ConnectConfig(ctx, dbConf)
+		if err != nil {
+			panic(err)
+		}
 
-	database.UpdateTables(pool, ctx)
+		database.UpdateTables(pool, ctx)
+		db = database.NewPostgresStore(pool, ctx)
+		connectRegionDatabases(config)
+	}
 
 	allowDefaultDolphinKeys = config.AllowDefaultDolphinKeys
+	offlineGracePeriod = time.Duration(config.GPCMOfflineGracePeriod) * time.Second
+	setMaxConnections(config.GPCMMaxConnections)
+	setMaxConcurrentLogins(config.GPCMMaxConcurrentLogins)
+	keepAliveTimestamp = config.GPCMKeepAliveTimestamp
+	setMatchRules(config.MatchRules)
+	setBannedIPs(config.GPCMBannedIPs)
+	setGameBlocks(config.GPCMBlocks)
+	natneg.SetOutcomeListener(natnegOutcomeListener{})
+	qr2.SetNATNEGCookieCallback(prepareMatchNegotiation)
+	requireCommandHMAC = config.GPCMRequireCommandHMAC
+	setMaxWriteBufferSize(config.GPCMMaxWriteBufferSize)
+	profileUpdateInterval = time.Duration(config.GPCMProfileUpdateInterval) * time.Millisecond
+	idleTimeout = time.Duration(config.GPCMIdleTimeout) * time.Second
+	minPreLoginThroughput = config.GPCMMinPreLoginThroughput
+	minSDKRevision = config.GPCMMinSDKRevision
+	rejectOutdatedSDK = config.GPCMRejectOutdatedSDK
+	rejectGameCodeMismatch = config.GPCMRejectGameCodeMismatch
+	flagDeviceMismatch = config.GPCMFlagDeviceMismatch
+	statusBatchWindow = time.Duration(config.GPCMStatusBatchWindow) * time.Millisecond
 
 	address := *config.GameSpyAddress + ":29900"
 	l, err := net.Listen("tcp", address)
@@ -100,7 +159,7 @@ func StartServer() {
 		}
 
 		// Handle connections in a new goroutine.
-		go handleRequest(conn)
+		acceptConnection(conn, handleRequest)
 	}
 }
 
@@ -114,9 +173,15 @@ func (g *GameSpySession) closeSession() {
 		if g.QR2IP != 0 {
 			qr2.ProcessGPStatusUpdate(g.User.ProfileId, g.QR2IP, "0")
 		}
-		g.sendLogoutStatus()
+		// Friends already see an invisible player as offline
+		if g.Presence != PresenceInvisible {
+			g.scheduleLogoutStatus()
+		}
 	}
 
+	// Don't lose an update that was waiting for the interval
+	g.flushProfileUpdate()
+
 	mutex.Lock()
 	defer mutex.Unlock()
 
@@ -124,15 +189,33 @@ func (g *GameSpySession) closeSession() {
 	if g.LoggedIn {
 		g.LoggedIn = false
 		delete(sessions, g.User.ProfileId)
+		metrics.Gauge("gpcm_sessions", float64(len(sessions)))
 	}
 }
 
+func (g *GameSpySession) keepAlive(command common.GameSpyCommand) {
+	g.touchActivity()
+
+	if !keepAliveTimestamp && !common.FeatureEnabled("keepAliveTimestamp") {
+		g.write([]byte(`\ka\\final\`))
+		return
+	}
+
+	g.write([]byte(common.CreateGameSpyMessage(common.GameSpyCommand{
+		Command:      "ka",
+		CommandValue: "",
+		OtherValues: map[string]string{
+			"wwfc_time": strconv.FormatInt(time.Now().UnixMilli(), 10),
+		},
+	})))
+}
+
 // Handles incoming requests.
 func handleRequest(conn net.Conn) {
 	session := &GameSpySession{
 		Conn:           conn,
+		ConnID:         common.RandomUUID(),
 		User:           database.User{},
-		ModuleName:     "GPCM:" + conn.RemoteAddr().String(),
 		LoggedIn:       false,
 		Challenge:      "",
 		Status:         "",
@@ -141,8 +224,21 @@ func handleRequest(conn net.Conn) {
 		AuthFriendList: []uint32{},
 	}
 
+	session.setModuleName("GPCM:" + conn.RemoteAddr().String())
+
 	defer session.closeSession()
 
+	// Don't spend a challenge on a banned address
+	if isAddressBanned(conn.RemoteAddr()) {
+		logging.Notice(session.ModuleName, "Rejecting connection from banned IP")
+		session.replyError(GPError{
+			ErrorCode:   ErrLogin.ErrorCode,
+			ErrorString: "This IP address is banned from the service.",
+			Fatal:       true,
+		})
+		return
+	}
+
 	// Set session ID and challenge
 	session.Challenge = common.RandomString(10)
 
@@ -159,13 +255,22 @@ func handleRequest(conn net.Conn) {
 			"id":        "1",
 		},
 	})
-	conn.Write([]byte(payload))
+	if !session.write([]byte(payload)) {
+		return
+	}
 
 	logging.Notice(session.ModuleName, "Connection established from", conn.RemoteAddr())
+	session.touchActivity()
+	session.throughput.start()
 
 	// Here we go into the listening loop
 	for {
-		// TODO: Handle split packets
+		if session.closing.Load() {
+			return
+		}
+
+		session.setReadDeadline()
+
 		buffer := make([]byte, 1024)
 		n, err := bufio.NewReader(conn).Read(buffer)
 		if err != nil {
@@ -175,23 +280,66 @@ func handleRequest(conn net.Conn) {
 				return
 			}
 
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				if session.readBuffer.expired() {
+					logging.Error(session.ModuleName, "Timed out waiting for the end of a message")
+					session.replyError(ErrParse)
+					return
+				}
+
+				if session.isTooSlow() {
+					logging.Error(session.ModuleName, "Closing connection sending too slowly before login")
+					session.replyError(ErrParse)
+					return
+				}
+
+				if !session.isIdle() {
+					continue
+				}
+
+				logging.Notice(session.ModuleName, "Closing idle connection")
+				return
+			}
+
 			logging.Error(session.ModuleName, "Connection lost")
 			return
 		}
 
-		commands, err := common.ParseGameSpyMessage(string(buffer[:n]))
+		session.throughput.add(n)
+		if session.isTooSlow() {
+			logging.Error(session.ModuleName, "Closing connection sending too slowly before login")
+			session.replyError(ErrParse)
+			return
+		}
+
+		message, ok := session.readBuffer.add(string(buffer[:n]))
+		if !ok {
+			logging.Error(session.ModuleName, "Message too long without an end")
+			session.replyError(ErrParse)
+			return
+		}
+		if message == "" {
+			// Wait for the rest of the message
+			continue
+		}
+
+		commands, err := common.ParseGameSpyMessage(message)
 		if err != nil {
 			logging.Error(session.ModuleName, "Error parsing message:", err.Error())
-			logging.Error(session.ModuleName, "Raw data:", string(buffer[:n]))
+			logging.Error(session.ModuleName, "Raw data:", message)
 			session.replyError(ErrParse)
 			return
 		}
 
+		session.history.record(commands)
+
+		if !session.LoggedIn && !session.checkCommandsBeforeLogin(commands) {
+			return
+		}
+
 		// Commands must be handled in a certain order, not in the order supplied by the client
 
-		commands = session.handleCommand("ka", commands, func(command common.GameSpyCommand) {
-			session.Conn.Write([]byte(`\ka\\final\`))
-		})
+		commands = session.handleCommand("ka", commands, session.keepAlive)
 		commands = session.handleCommand("login", commands, session.login)
 		commands = session.handleCommand("wwfc_exlogin", commands, session.exLogin)
 		commands = session.ignoreCommand("logout", commands)
@@ -202,7 +350,19 @@ func handleRequest(conn net.Conn) {
 			return
 		}
 
+		commands = session.verifyCommandHMACs(commands)
 		commands = session.handleCommand("wwfc_report", commands, session.handleWWFCReport)
+		commands = session.handleCommand("wwfc_namecard", commands, session.setNamecard)
+		commands = session.handleCommand("wwfc_population", commands, session.getPopulation)
+		commands = session.handleCommand("wwfc_nattype", commands, session.getNATType)
+		commands = session.handleCommand("wwfc_waittime", commands, session.getWaitTime)
+		commands = session.handleCommand("wwfc_friendcode", commands, session.getFriendCode)
+		commands = session.handleCommand("wwfc_cancelresv", commands, session.cancelReservation)
+		commands = session.handleCommand("wwfc_block", commands, session.blockProfile)
+		commands = session.handleCommand("wwfc_unblock", commands, session.unblockProfile)
+		commands = session.handleCommand("getblocks", commands, session.getBlocks)
+		commands = session.handleCommand("wwfc_setpref", commands, session.setPref)
+		commands = session.handleCommand("wwfc_getpref", commands, session.getPref)
 		commands = session.handleCommand("updatepro", commands, session.updateProfile)
 		commands = session.handleCommand("status", commands, session.setStatus)
 		commands = session.handleCommand("addbuddy", commands, session.addFriend)
@@ -210,22 +370,60 @@ func handleRequest(conn net.Conn) {
 		commands = session.handleCommand("authadd", commands, session.authAddFriend)
 		commands = session.handleCommand("bm", commands, session.bestieMessage)
 		commands = session.handleCommand("getprofile", commands, session.getProfile)
+		commands = session.handlePrefixCommands(commands)
 
 		for _, command := range commands {
 			logging.Error(session.ModuleName, "Unknown command:", aurora.Cyan(command))
 		}
 
-		if session.WriteBuffer != "" {
-			conn.Write([]byte(session.WriteBuffer))
-			session.WriteBuffer = ""
+		if session.closing.Load() {
+			return
 		}
+
+		session.flushWriteBuffer()
 	}
 }
 
-func (g *GameSpySession) handleCommand(name string, commands []common.GameSpyCommand, handler func(command common.GameSpyCommand)) []common.GameSpyCommand {
+// Set the module name used for logging. The connection ID is kept in the name
+// so a connection can be followed in the logs across login.
+func (g *GameSpySession) setModuleName(name string) {
+	g.ModuleName = name + "#" + g.ConnID
+}
+
+// Only a keep alive or the login itself may come before the login. Anything
+// else is rejected, even when the login follows in the same message, instead
+// of being reordered to run after it.
+func (g *GameSpySession) checkCommandsBeforeLogin(commands []common.GameSpyCommand) bool {
+	for _, command := range commands {
+		switch command.Command {
+		case "ka", "logout":
+			continue
+
+		case "login":
+			return true
+		}
+
+		logging.Error(g.ModuleName, "Attempt to run command before login:", aurora.Cyan(command.Command))
+		g.replyError(ErrNotLoggedIn)
+		return false
+	}
+
+	return true
+}
+
+// A handler for one GameSpy command. Replies are written to the connection or
+// queued in the write buffer.
+type commandHandler func(command common.GameSpyCommand)
+
+func (g *GameSpySession) handleCommand(name string, commands []common.GameSpyCommand, handler commandHandler) []common.GameSpyCommand {
 	var unhandled []common.GameSpyCommand
 
 	for _, command := range commands {
+		// The connection is gone, don't bother with the rest of the message
+		if g.closing.Load() {
+			return nil
+		}
+
 		if command.Command != name {
 			unhandled = append(unhandled, command)
 			continue
@@ -233,6 +431,7 @@ func (g *GameSpySession) handleCommand(name string, commands []common.GameSpyCom
 
 		logging.Info(g.ModuleName, "Command:", aurora.Yellow(command.Command))
 		handler(command)
+		g.checkWriteBufferSize()
 	}
 
 	return unhandled
@@ -0,0 +1,217 @@
+package gpcm
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"wwfc/common"
+	"wwfc/database"
+	"wwfc/logging"
+)
+
+type mockConn struct {
+	mutex  sync.Mutex
+	remote string
+	output strings.Builder
+	writes int
+	closed bool
+}
+
+func (c *mockConn) Read(b []byte) (int, error) { return 0, net.ErrClosed }
+
+func (c *mockConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+
+	c.output.Write(b)
+	c.writes++
+	return len(b), nil
+}
+
+func (c *mockConn) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closed = true
+	return nil
+}
+
+func (c *mockConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *mockConn) RemoteAddr() net.Addr               { addr, _ := net.ResolveTCPAddr("tcp", c.remote); return addr }
+func (c *mockConn) SetDeadline(t time.Time) error      { return nil }
+func (c *mockConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *mockConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func (c *mockConn) written() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.output.String()
+}
+
+// Create a logged in session and register it in the session map
+func newTestSession(profileId uint32, gameName string, remote string) (*GameSpySession, *mockConn) {
+	conn := &mockConn{remote: remote}
+	session := &GameSpySession{
+		Conn:                conn,
+		User:                database.User{ProfileId: profileId, GsbrCode: "RMCJ"},
+		ModuleName:          "GPCM:test",
+		LoggedIn:            true,
+		DeviceAuthenticated: true,
+		GameName:            gameName,
+		FriendList:          []uint32{},
+		AuthFriendList:      []uint32{},
+	}
+
+	mutex.Lock()
+	sessions[profileId] = session
+	mutex.Unlock()
+
+	return session, conn
+}
+
+func removeTestSessions(profileIds ...uint32) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, profileId := range profileIds {
+		delete(sessions, profileId)
+	}
+}
+
+func testReservation(matchType byte) common.MatchCommandData {
+	return common.MatchCommandData{
+		Version: 90,
+		Command: common.MatchReservation,
+		Reservation: &common.MatchCommandDataReservation{
+			MatchType:   matchType,
+			HasPublicIP: true,
+			PublicIP:    0x01020304,
+			PublicPort:  1234,
+		},
+	}
+}
+
+func TestConnectionIDKeptAcrossLogin(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+	logging.SetLevel(4)
+
+	session := &GameSpySession{ConnID: common.RandomUUID()}
+
+	session.setModuleName("GPCM:1.2.3.4:5000")
+	logging.Info(session.ModuleName, "Before login")
+
+	session.setModuleName("GPCM:1000/0000-0000-0000")
+	logging.Info(session.ModuleName, "After login")
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %d", len(lines))
+	}
+
+	for _, line := range lines {
+		if !strings.Contains(line, session.ConnID) {
+			t.Errorf("log line is missing the connection ID: %s", line)
+		}
+	}
+}
+
+func TestKeepAliveTimestamp(t *testing.T) {
+	keepAliveTimestamp = true
+	defer func() { keepAliveTimestamp = false }()
+
+	session, conn := newTestSession(7001, "mariokartwii", "1.2.3.4:5000")
+	defer removeTestSessions(7001)
+
+	before := time.Now().UnixMilli()
+	session.keepAlive(common.GameSpyCommand{Command: "ka"})
+	after := time.Now().UnixMilli()
+
+	commands, err := common.ParseGameSpyMessage(conn.written())
+	if err != nil || len(commands) != 1 || commands[0].Command != "ka" {
+		t.Fatalf("invalid keep alive reply: %s", conn.written())
+	}
+
+	timestamp, err := strconv.ParseInt(commands[0].OtherValues["wwfc_time"], 10, 64)
+	if err != nil || timestamp < before || timestamp > after {
+		t.Fatalf("implausible timestamp in keep alive reply: %s", conn.written())
+	}
+}
+
+func TestKeepAliveTimestampFeature(t *testing.T) {
+	defer common.SetFeature("keepAliveTimestamp", false)
+
+	session, conn := newTestSession(7002, "mariokartwii", "1.2.3.4:5000")
+	defer removeTestSessions(7002)
+
+	session.keepAlive(common.GameSpyCommand{Command: "ka"})
+	if strings.Contains(conn.written(), "wwfc_time") {
+		t.Fatalf("unexpected timestamp with the feature disabled: %s", conn.written())
+	}
+
+	common.SetFeature("keepAliveTimestamp", true)
+	session.keepAlive(common.GameSpyCommand{Command: "ka"})
+	if !strings.Contains(conn.written(), `\wwfc_time\`) {
+		t.Fatalf("missing timestamp with the feature enabled: %s", conn.written())
+	}
+}
+
+func TestCommandBeforeLoginRejected(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			handleRequest(conn)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	buffer := make([]byte, 1024)
+	n, err := conn.Read(buffer)
+	if err != nil || !strings.HasPrefix(string(buffer[:n]), `\lc\1\`) {
+		t.Fatalf("expected a challenge, got %q (%v)", buffer[:n], err)
+	}
+
+	// The profile update comes first, so it must not be run after the login
+	conn.Write([]byte(`\updatepro\\sesskey\1\firstname\test\final\\login\\authtoken\invalid\final\`))
+
+	var reply []byte
+	for {
+		n, err := conn.Read(buffer)
+		reply = append(reply, buffer[:n]...)
+		if err != nil {
+			break
+		}
+	}
+
+	commands, err := common.ParseGameSpyMessage(string(reply))
+	if err != nil || len(commands) != 1 || commands[0].Command != "error" || commands[0].OtherValues["err"] != strconv.Itoa(ErrNotLoggedIn.ErrorCode) {
+		t.Fatalf("expected a not logged in error, got %q", reply)
+	}
+	if _, fatal := commands[0].OtherValues["fatal"]; !fatal {
+		t.Fatal("the error should close the connection")
+	}
+}
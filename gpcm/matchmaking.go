@@ -0,0 +1,222 @@
+package gpcm
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+	"time"
+	"wwfc/common"
+	"wwfc/logging"
+	"wwfc/natneg"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+var (
+	// Reservations pair only with a waiting session whose skill is within the
+	// tolerance, which widens by one step each interval the session has waited
+	skillTolerance         uint32 = 500
+	skillToleranceStep     uint32 = 250
+	skillToleranceInterval        = 10 * time.Second
+)
+
+// Encode a match command into the bm 1 message format for the specified version
+func encodeMatchMessage(version int, cmd byte, data []byte) string {
+	var msg string
+
+	switch version {
+	case 3:
+		msg = "GPCM3vMAT" + string(cmd)
+
+		for i := 0; i < len(data); i += 4 {
+			if i > 0 {
+				msg += "/"
+			}
+
+			msg += strconv.FormatUint(uint64(binary.LittleEndian.Uint32(data[i:])), 10)
+		}
+
+	case 11:
+		msg = "GPCM11vMAT" + string(cmd)
+
+		for i := 0; i < len(data); i += 4 {
+			if i > 0 {
+				msg += "/"
+			}
+
+			msg += hex.EncodeToString(data[i : i+4])
+		}
+
+	case 90:
+		msg = "GPCM90vMAT" + string(cmd) + common.Base64DwcEncoding.EncodeToString(data)
+	}
+
+	return msg
+}
+
+func (g *GameSpySession) isReservationCompatible(other *GameSpySession, reservation common.MatchCommandData) bool {
+	if other == g || !other.LoggedIn || !other.DeviceAuthenticated {
+		return false
+	}
+
+	// Only sessions waiting without a target are considered
+	if other.ReservationPID != 0 || other.Reservation.Reservation == nil {
+		return false
+	}
+
+	return other.GameName == g.GameName &&
+		other.Region == g.Region &&
+		other.Reservation.Version == reservation.Version &&
+		other.Reservation.Reservation.MatchType == reservation.Reservation.MatchType &&
+		matchRulesAllow(g.GameName, other.Reservation.Reservation, reservation.Reservation)
+}
+
+// Allowed skill difference for a reservation that has been waiting for the
+// specified duration
+func skillToleranceAfter(waited time.Duration) uint32 {
+	if waited < 0 {
+		waited = 0
+	}
+
+	return skillTolerance + uint32(waited/skillToleranceInterval)*skillToleranceStep
+}
+
+func skillDifference(a uint32, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// PairReservation registers a reservation for the profile and pairs it with a
+// waiting session playing the same game in the same region, with the same
+// match command version and match type, that passes the game's match rules.
+// Among those, the waiting session closest in skill is preferred, as long as
+// the difference is within its tolerance; ties go to the longest waiting. If
+// a match is found, the waiting session is sent the reservation and the
+// requester is told to wait for the reply. Otherwise the requester is left
+// waiting for a later reservation.
+func PairReservation(profileId uint32, reservation common.MatchCommandData, skill uint32) (uint32, bool) {
+	if reservation.Reservation == nil {
+		return 0, false
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	session, exists := sessions[profileId]
+	if !exists || !session.LoggedIn {
+		return 0, false
+	}
+
+	now := time.Now()
+	session.Reservation = reservation
+	session.ReservationPID = 0
+	session.ReservationCookie = 0
+	session.ReservationSkill = skill
+	session.ReservationSince = now
+
+	var match *GameSpySession
+	var matchDifference uint32
+	for _, other := range sessions {
+		if !session.isReservationCompatible(other, reservation) {
+			continue
+		}
+
+		difference := skillDifference(skill, other.ReservationSkill)
+		if difference > skillToleranceAfter(now.Sub(other.ReservationSince)) {
+			continue
+		}
+
+		if match == nil || difference < matchDifference ||
+			(difference == matchDifference && other.ReservationSince.Before(match.ReservationSince)) {
+			match = other
+			matchDifference = difference
+		}
+	}
+
+	if match == nil {
+		logging.Info(session.ModuleName, "Waiting for a compatible reservation")
+		return 0, false
+	}
+
+	resvMsg, ok := common.EncodeMatchCommand(common.MatchReservation, reservation)
+	if !ok {
+		logging.Error(session.ModuleName, "Failed to encode reservation")
+		return 0, false
+	}
+
+	session.ReservationPID = match.User.ProfileId
+	match.ReservationPID = session.User.ProfileId
+	recordPairing(session.GameName, session.Region, now)
+
+	logging.Notice(session.ModuleName, "Paired reservation with", aurora.BrightCyan(match.User.ProfileId))
+
+	sendMessageToSession("1", session.User.ProfileId, match, encodeMatchMessage(reservation.Version, common.MatchReservation, resvMsg))
+	sendMessageToSession("1", match.User.ProfileId, session, encodeMatchMessage(reservation.Version, common.MatchResvWait, []byte{}))
+
+	return match.User.ProfileId, true
+}
+
+// Clear the session's pending reservation. A peer it was paired with is sent
+// a reservation cancel if notify is set, and can then be paired again.
+// Expects the global mutex to already be locked.
+func (g *GameSpySession) clearReservation(notify bool) bool {
+	if g.Reservation.Reservation == nil && g.ReservationPID == 0 {
+		return false
+	}
+
+	if peer, exists := sessions[g.ReservationPID]; exists && peer.LoggedIn && peer.ReservationPID == g.User.ProfileId {
+		if notify {
+			sendMessageToSession("1", g.User.ProfileId, peer, encodeMatchMessage(g.Reservation.Version, common.MatchResvCancel, []byte{}))
+		}
+		peer.ReservationPID = 0
+		peer.ReservationCookie = 0
+	}
+
+	g.Reservation = common.MatchCommandData{}
+	g.ReservationPID = 0
+	g.ReservationCookie = 0
+	return true
+}
+
+// CancelReservation clears the profile's pending reservation before a match
+// forms, telling any peer it was paired with
+func CancelReservation(profileId uint32) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	session, exists := sessions[profileId]
+	if !exists || !session.LoggedIn {
+		return false
+	}
+
+	cookie := session.ReservationCookie
+	if !session.clearReservation(true) {
+		return false
+	}
+
+	// The pairing is gone, so the outcome of its negotiation is ignored
+	if cookie != 0 {
+		natneg.AbortSession(cookie)
+	}
+
+	logging.Info(session.ModuleName, "Cancelled reservation")
+	return true
+}
+
+func (g *GameSpySession) cancelReservation(command common.GameSpyCommand) {
+	cancelled := "0"
+	if CancelReservation(g.User.ProfileId) {
+		cancelled = "1"
+	}
+
+	g.WriteBuffer += common.CreateGameSpyMessage(common.GameSpyCommand{
+		Command:      "wwfc_cancelresv",
+		CommandValue: "",
+		OtherValues: map[string]string{
+			"cancelled": cancelled,
+			"id":        command.OtherValues["id"],
+		},
+	})
+}
@@ -0,0 +1,141 @@
+package gpcm
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"wwfc/common"
+)
+
+func TestPairReservation(t *testing.T) {
+	host, hostConn := newTestSession(1001, "mariokartwii", "1.2.3.4:5000")
+	client, clientConn := newTestSession(1002, "mariokartwii", "5.6.7.8:5000")
+	other, _ := newTestSession(1003, "mariokartwii", "9.9.9.9:5000")
+	defer removeTestSessions(1001, 1002, 1003)
+
+	other.Region = 2
+
+	if _, paired := PairReservation(host.User.ProfileId, testReservation(1), 0); paired {
+		t.Fatal("first reservation should be left waiting")
+	}
+
+	profileId, paired := PairReservation(client.User.ProfileId, testReservation(1), 0)
+	if !paired || profileId != host.User.ProfileId {
+		t.Fatalf("expected pairing with %d, got %d (%v)", host.User.ProfileId, profileId, paired)
+	}
+
+	if host.ReservationPID != client.User.ProfileId || client.ReservationPID != host.User.ProfileId {
+		t.Fatalf("reservations not linked: %d %d", host.ReservationPID, client.ReservationPID)
+	}
+
+	if !strings.Contains(hostConn.written(), `\f\1002\`) || !strings.Contains(clientConn.written(), `\f\1001\`) {
+		t.Fatal("both clients should have been notified")
+	}
+}
+
+func TestPairReservationMatchRules(t *testing.T) {
+	setMatchRules([]common.MatchRules{{
+		GameName: "mariokartwii",
+		Fields:   []common.MatchFieldRule{{Name: "mode", Offset: 0, Length: 4}},
+	}})
+	defer setMatchRules(nil)
+
+	waiting, _ := newTestSession(1101, "mariokartwii", "1.2.3.4:5000")
+	other, _ := newTestSession(1102, "mariokartwii", "5.6.7.8:5000")
+	compatible, _ := newTestSession(1103, "mariokartwii", "9.9.9.9:5000")
+	defer removeTestSessions(1101, 1102, 1103)
+
+	reservationWithMode := func(mode byte) common.MatchCommandData {
+		reservation := testReservation(1)
+		reservation.Reservation.UserData = []byte{0, 0, 0, mode, 0, 0, 0, 0xff}
+		return reservation
+	}
+
+	if _, paired := PairReservation(waiting.User.ProfileId, reservationWithMode(1), 0); paired {
+		t.Fatal("first reservation should be left waiting")
+	}
+
+	if _, paired := PairReservation(other.User.ProfileId, reservationWithMode(2), 0); paired {
+		t.Fatal("reservation with a different mode should not pair")
+	}
+
+	profileId, paired := PairReservation(compatible.User.ProfileId, reservationWithMode(1), 0)
+	if !paired || profileId != waiting.User.ProfileId {
+		t.Fatalf("expected pairing with %d, got %d (%v)", waiting.User.ProfileId, profileId, paired)
+	}
+}
+
+func TestCancelReservation(t *testing.T) {
+	host, hostConn := newTestSession(1201, "mariokartwii", "1.2.3.4:5000")
+	client, _ := newTestSession(1202, "mariokartwii", "5.6.7.8:5000")
+	other, _ := newTestSession(1203, "mariokartwii", "9.9.9.9:5000")
+	defer removeTestSessions(1201, 1202, 1203)
+
+	PairReservation(host.User.ProfileId, testReservation(1), 0)
+	if _, paired := PairReservation(client.User.ProfileId, testReservation(1), 0); !paired {
+		t.Fatal("reservations should have been paired")
+	}
+
+	client.cancelReservation(common.GameSpyCommand{Command: "wwfc_cancelresv", OtherValues: map[string]string{"id": "1"}})
+
+	if !strings.Contains(client.WriteBuffer, `\cancelled\1\`) {
+		t.Fatalf("missing cancel reply: %s", client.WriteBuffer)
+	}
+	if client.Reservation.Reservation != nil || client.ReservationPID != 0 {
+		t.Fatal("reservation was not cleared")
+	}
+
+	notified := false
+	messages, _ := common.ParseGameSpyMessage(hostConn.written())
+	for _, message := range messages {
+		if message.Command == "bm" && message.OtherValues["f"] == "1202" && message.OtherValues["msg"] == encodeMatchMessage(90, common.MatchResvCancel, []byte{}) {
+			notified = true
+		}
+	}
+	if !notified {
+		t.Fatalf("peer was not told about the cancel: %s", hostConn.written())
+	}
+	if host.ReservationPID != 0 {
+		t.Fatal("peer is still paired")
+	}
+
+	// The peer can be paired again
+	if profileId, paired := PairReservation(other.User.ProfileId, testReservation(1), 0); !paired || profileId != host.User.ProfileId {
+		t.Fatalf("expected the freed peer to be paired again, got %d (%v)", profileId, paired)
+	}
+
+	if CancelReservation(client.User.ProfileId) {
+		t.Fatal("cancelling without a reservation should do nothing")
+	}
+}
+
+func TestPairReservationPrefersCloseSkill(t *testing.T) {
+	low, _ := newTestSession(1101, "mariokartwii", "1.2.3.4:5000")
+	high, _ := newTestSession(1102, "mariokartwii", "1.2.3.5:5000")
+	nearHigh, _ := newTestSession(1103, "mariokartwii", "1.2.3.6:5000")
+	nearLow, _ := newTestSession(1104, "mariokartwii", "1.2.3.7:5000")
+	distant, _ := newTestSession(1105, "mariokartwii", "1.2.3.8:5000")
+	defer removeTestSessions(1101, 1102, 1103, 1104, 1105)
+
+	PairReservation(low.User.ProfileId, testReservation(1), 1000)
+	PairReservation(high.User.ProfileId, testReservation(1), 5000)
+
+	if profileId, paired := PairReservation(nearHigh.User.ProfileId, testReservation(1), 5200); !paired || profileId != high.User.ProfileId {
+		t.Fatalf("expected pairing with %d, got %d (%v)", high.User.ProfileId, profileId, paired)
+	}
+
+	// Nobody waiting is close enough yet
+	if _, paired := PairReservation(distant.User.ProfileId, testReservation(1), 9000); paired {
+		t.Fatal("distant skill paired before its tolerance widened")
+	}
+
+	if profileId, paired := PairReservation(nearLow.User.ProfileId, testReservation(1), 1100); !paired || profileId != low.User.ProfileId {
+		t.Fatalf("expected pairing with %d, got %d (%v)", low.User.ProfileId, profileId, paired)
+	}
+
+	// Once the distant reservation has waited long enough, it is accepted
+	distant.ReservationSince = time.Now().Add(-time.Minute * 5)
+	if profileId, paired := PairReservation(low.User.ProfileId, testReservation(1), 1000); !paired || profileId != distant.User.ProfileId {
+		t.Fatalf("expected pairing with %d after waiting, got %d (%v)", distant.User.ProfileId, profileId, paired)
+	}
+}
@@ -0,0 +1,37 @@
+package gpcm
+
+import (
+	"bytes"
+	"wwfc/common"
+)
+
+// Per game rules for reservation pairing, keyed by game name
+var matchRules = map[string][]common.MatchFieldRule{}
+
+func setMatchRules(rules []common.MatchRules) {
+	matchRules = map[string][]common.MatchFieldRule{}
+	for _, game := range rules {
+		matchRules[game.GameName] = append(matchRules[game.GameName], game.Fields...)
+	}
+}
+
+// Check the game's match rules against two reservations. A reservation with
+// user data too short to hold a field never matches on it.
+func matchRulesAllow(gameName string, a *common.MatchCommandDataReservation, b *common.MatchCommandDataReservation) bool {
+	for _, rule := range matchRules[gameName] {
+		if rule.Offset < 0 || rule.Length <= 0 {
+			continue
+		}
+
+		end := rule.Offset + rule.Length
+		if len(a.UserData) < end || len(b.UserData) < end {
+			return false
+		}
+
+		if !bytes.Equal(a.UserData[rule.Offset:end], b.UserData[rule.Offset:end]) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,31 @@
+package gpcm
+
+import (
+	"testing"
+	"wwfc/database"
+	"wwfc/metrics"
+)
+
+func TestLoginMetrics(t *testing.T) {
+	previousDb := db
+	db = database.NewMemoryStore()
+	defer func() { db = previousDb }()
+
+	sink := metrics.NewMemorySink()
+	previousSink := metrics.GetSink()
+	metrics.SetSink(sink)
+	defer metrics.SetSink(previousSink)
+
+	loginTestSession(t, 501, 5501, "1.2.3.4:5000")
+	defer removeTestSessions(5501)
+
+	if sink.GetCounter("gpcm_logins_total", "game", "tetrisds") != 1 {
+		t.Error("login was not counted")
+	}
+	if sink.GetHistogram("gpcm_login_seconds").Count == 0 {
+		t.Error("login duration was not observed")
+	}
+	if sink.GetGauge("gpcm_sessions") < 1 {
+		t.Error("session gauge was not set")
+	}
+}
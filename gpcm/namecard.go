@@ -0,0 +1,94 @@
+package gpcm
+
+import (
+	"bytes"
+	"wwfc/common"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// The whole command has to fit in a single 1024 byte read, so keep the
+// decoded blob well below that once it has been base64 encoded
+const maxNamecardSize = 512
+
+// Accepted content types and the magic bytes the data must start with
+var namecardTypes = map[string][]byte{
+	"image/png":  {0x89, 'P', 'N', 'G', 0x0D, 0x0A, 0x1A, 0x0A},
+	"image/jpeg": {0xFF, 0xD8, 0xFF},
+}
+
+func (g *GameSpySession) setNamecard(command common.GameSpyCommand) {
+	contentType := command.OtherValues["type"]
+	encoded, ok := command.OtherValues["data"]
+	if !ok {
+		logging.Error(g.ModuleName, "Missing namecard data")
+		g.replyError(ErrUpdateProfile)
+		return
+	}
+
+	// Empty data clears the namecard
+	if encoded == "" {
+		g.store().UpdateNamecard(g.User.ProfileId, "", nil)
+		g.replyNamecard(command)
+		return
+	}
+
+	// Reject oversized data before decoding it
+	if len(encoded) > common.Base64DwcEncoding.EncodedLen(maxNamecardSize) {
+		logging.Error(g.ModuleName, "Namecard is too large:", aurora.Cyan(len(encoded)), "encoded bytes")
+		g.replyError(ErrUpdateProfile)
+		return
+	}
+
+	data, err := common.Base64DwcEncoding.DecodeString(encoded)
+	if err != nil {
+		logging.Error(g.ModuleName, "Error decoding namecard:", err.Error())
+		g.replyError(ErrUpdateProfile)
+		return
+	}
+
+	if len(data) > maxNamecardSize {
+		logging.Error(g.ModuleName, "Namecard is too large:", aurora.Cyan(len(data)), "bytes")
+		g.replyError(ErrUpdateProfile)
+		return
+	}
+
+	magic, ok := namecardTypes[contentType]
+	if !ok {
+		logging.Error(g.ModuleName, "Invalid namecard content type:", aurora.Cyan(contentType))
+		g.replyError(ErrUpdateProfile)
+		return
+	}
+
+	if !bytes.HasPrefix(data, magic) {
+		logging.Error(g.ModuleName, "Namecard data does not match content type", aurora.Cyan(contentType))
+		g.replyError(ErrUpdateProfile)
+		return
+	}
+
+	logging.Info(g.ModuleName, "Updated namecard:", aurora.Cyan(contentType), aurora.Cyan(len(data)), "bytes")
+	g.store().UpdateNamecard(g.User.ProfileId, contentType, data)
+	g.replyNamecard(command)
+}
+
+func (g *GameSpySession) replyNamecard(command common.GameSpyCommand) {
+	g.WriteBuffer += common.CreateGameSpyMessage(common.GameSpyCommand{
+		Command:      "wwfc_namecard",
+		CommandValue: "",
+		OtherValues: map[string]string{
+			"id": command.OtherValues["id"],
+		},
+	})
+}
+
+// Add the stored namecard, if any, to a profile info reply
+func addNamecardValues(values map[string]string, profileId uint32) {
+	contentType, data := storeForProfile(profileId).GetNamecard(profileId)
+	if contentType == "" || len(data) == 0 {
+		return
+	}
+
+	values["wwfc_namecard_type"] = contentType
+	values["wwfc_namecard"] = common.Base64DwcEncoding.EncodeToString(data)
+}
@@ -0,0 +1,118 @@
+package gpcm
+
+import (
+	"fmt"
+	"sync"
+	"wwfc/common"
+	"wwfc/logging"
+	"wwfc/metrics"
+	"wwfc/natneg"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// The pair of profiles a NATNEG session was prepared for
+type matchNegotiation struct {
+	// The profile whose reservation formed the match
+	RequesterPID uint32
+	// The waiting profile it was paired with
+	WaitingPID uint32
+}
+
+var (
+	matchNegotiations      = map[uint32]matchNegotiation{}
+	matchNegotiationsMutex = sync.Mutex{}
+)
+
+// Receives the outcome of NATNEG sessions prepared for matches
+type natnegOutcomeListener struct{}
+
+// Prepare the NATNEG session for a cookie forwarded to a client, if the client
+// is paired by matchmaking and no session was prepared for the pair yet, and
+// remember who it is for so the pair can be re-queued if the negotiation
+// fails. Cookies for clients that aren't paired are left to natneg as usual.
+func prepareMatchNegotiation(profileId uint32, cookie uint32) {
+	if cookie == 0 {
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	session, exists := sessions[profileId]
+	if !exists || !session.LoggedIn || session.ReservationPID == 0 || session.ReservationCookie != 0 {
+		return
+	}
+
+	peer, exists := sessions[session.ReservationPID]
+	if !exists || !peer.LoggedIn || peer.ReservationPID != profileId {
+		return
+	}
+
+	// The requester's reservation is registered when the pair forms, after
+	// the waiting session's
+	requester, waiting := session, peer
+	if session.ReservationSince.Before(peer.ReservationSince) {
+		requester, waiting = peer, session
+	}
+
+	matchNegotiationsMutex.Lock()
+	matchNegotiations[cookie] = matchNegotiation{
+		RequesterPID: requester.User.ProfileId,
+		WaitingPID:   waiting.User.ProfileId,
+	}
+	matchNegotiationsMutex.Unlock()
+
+	session.ReservationCookie = cookie
+	peer.ReservationCookie = cookie
+
+	logging.Info(session.ModuleName, "Preparing NATNEG session", aurora.Cyan(fmt.Sprintf("%08x", cookie)), "for the match with", aurora.BrightCyan(peer.User.ProfileId))
+	natneg.PrepareSession(cookie, session.GameName, 2)
+}
+
+func (natnegOutcomeListener) NATNEGSessionEnded(outcome natneg.SessionOutcome) {
+	moduleName := "GPCM:" + fmt.Sprintf("%08x", outcome.Cookie)
+
+	matchNegotiationsMutex.Lock()
+	negotiation, exists := matchNegotiations[outcome.Cookie]
+	delete(matchNegotiations, outcome.Cookie)
+	matchNegotiationsMutex.Unlock()
+
+	if outcome.Success {
+		logging.Info(moduleName, "NATNEG session for the match succeeded")
+		metrics.Counter("gpcm_match_negotiations_total", 1, "result", "success")
+		return
+	}
+
+	logging.Warn(moduleName, "NATNEG session for the match failed, reason:", aurora.Cyan(outcome.Reason))
+	metrics.Counter("gpcm_match_negotiations_total", 1, "result", "failure")
+
+	if exists {
+		requeueFailedMatch(outcome.Cookie, negotiation, moduleName)
+	}
+}
+
+// Undo a pairing whose negotiation failed. The requester's reservation is
+// cleared and the waiting session is put back in the queue, keeping the time
+// it has waited. Pairings that were already cancelled are left alone.
+func requeueFailedMatch(cookie uint32, negotiation matchNegotiation, moduleName string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	requester, requesterExists := sessions[negotiation.RequesterPID]
+	waiting, waitingExists := sessions[negotiation.WaitingPID]
+
+	if requesterExists && requester.ReservationCookie == cookie {
+		if waitingExists && waiting.ReservationCookie == cookie {
+			sendMessageToSession("1", waiting.User.ProfileId, requester, encodeMatchMessage(requester.Reservation.Version, common.MatchResvCancel, []byte{}))
+		}
+		requester.clearReservation(true)
+	} else if waitingExists && waiting.ReservationCookie == cookie {
+		waiting.ReservationPID = 0
+		waiting.ReservationCookie = 0
+	} else {
+		return
+	}
+
+	logging.Notice(moduleName, "Re-queued", aurora.BrightCyan(negotiation.WaitingPID), "after the match with", aurora.BrightCyan(negotiation.RequesterPID), "failed")
+}
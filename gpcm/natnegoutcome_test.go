@@ -0,0 +1,146 @@
+package gpcm
+
+import (
+	"testing"
+	"time"
+	"wwfc/common"
+	"wwfc/natneg"
+)
+
+func hasMatchCancel(conn *mockConn, from string) bool {
+	messages, _ := common.ParseGameSpyMessage(conn.written())
+	for _, message := range messages {
+		if message.Command == "bm" && message.OtherValues["f"] == from && message.OtherValues["msg"] == encodeMatchMessage(90, common.MatchResvCancel, []byte{}) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFailedMatchNegotiationRequeued(t *testing.T) {
+	natneg.SetOutcomeListener(natnegOutcomeListener{})
+	defer natneg.SetOutcomeListener(nil)
+
+	waiting, waitingConn := newTestSession(1501, "mariokartwii", "1.2.3.4:5000")
+	requester, requesterConn := newTestSession(1502, "mariokartwii", "5.6.7.8:5000")
+	other, _ := newTestSession(1503, "mariokartwii", "9.9.9.9:5000")
+	defer removeTestSessions(1501, 1502, 1503)
+
+	PairReservation(waiting.User.ProfileId, testReservation(1), 0)
+	if _, paired := PairReservation(requester.User.ProfileId, testReservation(1), 0); !paired {
+		t.Fatal("reservations should have been paired")
+	}
+
+	// Pairing alone doesn't prepare a session, since the clients pick the cookie
+	mutex.Lock()
+	prepared := requester.ReservationCookie != 0 || waiting.ReservationCookie != 0
+	mutex.Unlock()
+
+	if prepared {
+		t.Fatal("a NATNEG session was prepared before the clients sent a cookie")
+	}
+
+	// The requester forwards its cookie to the waiting session through QR2
+	const cookie = 0x1e5e0001
+	prepareMatchNegotiation(waiting.User.ProfileId, cookie)
+
+	mutex.Lock()
+	sameCookie := requester.ReservationCookie == cookie && waiting.ReservationCookie == cookie
+	mutex.Unlock()
+
+	if !sameCookie {
+		t.Fatal("the forwarded cookie was not recorded for the pair")
+	}
+
+	// Later cookies for the same pair don't replace it
+	prepareMatchNegotiation(requester.User.ProfileId, cookie+1)
+	if natneg.AbortSession(cookie + 1) {
+		t.Fatal("a second session was prepared for the pair")
+	}
+
+	// The negotiation fails before both clients connect
+	if !natneg.AbortSession(cookie) {
+		t.Fatal("no NATNEG session was prepared for the match")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mutex.Lock()
+		cleared := requester.Reservation.Reservation == nil
+		mutex.Unlock()
+
+		if cleared {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the failed match was not undone")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mutex.Lock()
+	requeued := waiting.Reservation.Reservation != nil && waiting.ReservationPID == 0 && waiting.ReservationCookie == 0
+	mutex.Unlock()
+
+	if !requeued {
+		t.Fatal("the waiting session was not put back in the queue")
+	}
+	if !hasMatchCancel(waitingConn, "1502") || !hasMatchCancel(requesterConn, "1501") {
+		t.Fatal("both clients should have been told the match was cancelled")
+	}
+
+	// The waiting session can be paired again
+	if profileId, paired := PairReservation(other.User.ProfileId, testReservation(1), 0); !paired || profileId != waiting.User.ProfileId {
+		t.Fatalf("expected the re-queued session to be paired again, got %d (%v)", profileId, paired)
+	}
+}
+
+func TestCancelledMatchNegotiationAborted(t *testing.T) {
+	natneg.SetOutcomeListener(natnegOutcomeListener{})
+	defer natneg.SetOutcomeListener(nil)
+
+	waiting, _ := newTestSession(1511, "mariokartwii", "1.2.3.4:5000")
+	requester, _ := newTestSession(1512, "mariokartwii", "5.6.7.8:5000")
+	defer removeTestSessions(1511, 1512)
+
+	PairReservation(waiting.User.ProfileId, testReservation(1), 0)
+	if _, paired := PairReservation(requester.User.ProfileId, testReservation(1), 0); !paired {
+		t.Fatal("reservations should have been paired")
+	}
+
+	const cookie = 0x1e5e0002
+	prepareMatchNegotiation(requester.User.ProfileId, cookie)
+
+	if !CancelReservation(waiting.User.ProfileId) {
+		t.Fatal("reservation was not cancelled")
+	}
+	if natneg.AbortSession(cookie) {
+		t.Fatal("the NATNEG session for a cancelled match is still open")
+	}
+
+	// The late failure doesn't touch the requester, which is waiting again
+	time.Sleep(100 * time.Millisecond)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if requester.Reservation.Reservation == nil || requester.ReservationPID != 0 {
+		t.Fatal("the requester should be left waiting after its peer cancelled")
+	}
+}
+
+func TestUnpairedCookieNotPrepared(t *testing.T) {
+	session, _ := newTestSession(1521, "mariokartwii", "1.2.3.4:5000")
+	defer removeTestSessions(1521)
+
+	// Cookies exchanged outside of matchmaking, such as joining a friend
+	const cookie = 0x1e5e0003
+	prepareMatchNegotiation(session.User.ProfileId, cookie)
+
+	if natneg.AbortSession(cookie) {
+		t.Fatal("a session was prepared for a client that isn't paired")
+	}
+	if session.ReservationCookie != 0 {
+		t.Fatal("a cookie was recorded for a client that isn't paired")
+	}
+}
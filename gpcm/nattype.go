@@ -0,0 +1,28 @@
+package gpcm
+
+import (
+	"strings"
+	"wwfc/common"
+	"wwfc/logging"
+	"wwfc/natneg"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// Report the NAT type seen from the NATNEG probes sent by the client's public
+// IP. The client has to run its NAT check first; until then the type is unknown.
+func (g *GameSpySession) getNATType(command common.GameSpyCommand) {
+	ip := strings.Split(g.Conn.RemoteAddr().String(), ":")[0]
+	natType := natneg.GetNATTypeName(natneg.GetObservedNATType(ip))
+
+	logging.Info(g.ModuleName, "NAT type:", aurora.Cyan(natType))
+
+	g.WriteBuffer += common.CreateGameSpyMessage(common.GameSpyCommand{
+		Command:      "wwfc_nattype",
+		CommandValue: "",
+		OtherValues: map[string]string{
+			"nattype": natType,
+			"id":      command.OtherValues["id"],
+		},
+	})
+}
@@ -0,0 +1,42 @@
+package gpcm
+
+import (
+	"net"
+	"testing"
+	"wwfc/common"
+	"wwfc/natneg"
+)
+
+func TestNATTypeCommand(t *testing.T) {
+	tests := []struct {
+		remote   string
+		ports    []int
+		expected string
+	}{
+		{"198.51.100.1:5000", nil, "unknown"},
+		{"198.51.100.2:5000", []int{40000, 40000}, "port-restricted-cone"},
+		{"198.51.100.3:5000", []int{40000, 40001, 40002}, "symmetric"},
+	}
+
+	for i, test := range tests {
+		profileId := uint32(9001 + i)
+		session, _ := newTestSession(profileId, "mariokartwii", test.remote)
+
+		host, _, _ := net.SplitHostPort(test.remote)
+		ip := net.ParseIP(host)
+		for portType, port := range test.ports {
+			natneg.ObserveProbe(&net.UDPAddr{IP: ip, Port: port}, byte(portType+1), "192.168.1.2:5000")
+		}
+
+		session.getNATType(common.GameSpyCommand{Command: "wwfc_nattype", OtherValues: map[string]string{"id": "1"}})
+		removeTestSessions(profileId)
+
+		commands, err := common.ParseGameSpyMessage(session.WriteBuffer)
+		if err != nil || len(commands) != 1 || commands[0].Command != "wwfc_nattype" {
+			t.Fatalf("invalid NAT type reply: %s", session.WriteBuffer)
+		}
+		if natType := commands[0].OtherValues["nattype"]; natType != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.remote, test.expected, natType)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package gpcm
+
+// Basic information about an online profile's session
+type SessionInfo struct {
+	ProfileID  uint32
+	GameName   string
+	InGameName string
+	Region     byte
+	Status     string
+	StatusCode int
+	LocString  string
+	RemoteAddr string
+}
+
+// Check whether a profile is logged in, and get its session info if it is
+func IsOnline(profileId uint32) (bool, SessionInfo) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	session, exists := sessions[profileId]
+	if !exists || !session.LoggedIn {
+		return false, SessionInfo{}
+	}
+
+	return true, session.info()
+}
+
+// Get the info of every logged in session
+func ListSessions() []SessionInfo {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	infos := []SessionInfo{}
+	for _, session := range sessions {
+		if session.LoggedIn {
+			infos = append(infos, session.info())
+		}
+	}
+	return infos
+}
+
+// Expects the global mutex to already be locked
+func (g *GameSpySession) info() SessionInfo {
+	return SessionInfo{
+		ProfileID:  g.User.ProfileId,
+		GameName:   g.GameName,
+		InGameName: g.InGameName,
+		Region:     g.Region,
+		Status:     g.Status,
+		StatusCode: g.StatusCode,
+		LocString:  g.LocString,
+		RemoteAddr: g.Conn.RemoteAddr().String(),
+	}
+}
@@ -0,0 +1,28 @@
+package gpcm
+
+import "testing"
+
+func TestIsOnline(t *testing.T) {
+	session, _ := newTestSession(9601, "mariokartwii", "1.2.3.4:5000")
+	defer removeTestSessions(9601)
+
+	session.InGameName = "Player"
+	session.Status = "|s|1|ss|Online"
+
+	online, info := IsOnline(9601)
+	if !online {
+		t.Fatal("logged in profile should be online")
+	}
+	if info.ProfileID != 9601 || info.GameName != "mariokartwii" || info.InGameName != "Player" || info.Status != session.Status || info.RemoteAddr != "1.2.3.4:5000" {
+		t.Fatalf("unexpected session info: %+v", info)
+	}
+
+	if online, _ := IsOnline(9602); online {
+		t.Fatal("unknown profile should not be online")
+	}
+
+	session.LoggedIn = false
+	if online, _ := IsOnline(9601); online {
+		t.Fatal("profile that is not logged in should not be online")
+	}
+}
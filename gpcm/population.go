@@ -0,0 +1,53 @@
+package gpcm
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"wwfc/common"
+)
+
+// Count the logged in players, in total and for each game
+func getPopulation() (int, map[string]int) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	total := 0
+	games := map[string]int{}
+	for _, session := range sessions {
+		if !session.LoggedIn {
+			continue
+		}
+
+		total++
+		games[session.GameName]++
+	}
+
+	return total, games
+}
+
+func (g *GameSpySession) getPopulation(command common.GameSpyCommand) {
+	total, games := getPopulation()
+
+	gameNames := make([]string, 0, len(games))
+	for gameName := range games {
+		gameNames = append(gameNames, gameName)
+	}
+	sort.Strings(gameNames)
+
+	// Format: gamename:count,gamename:count
+	counts := make([]string, 0, len(gameNames))
+	for _, gameName := range gameNames {
+		counts = append(counts, gameName+":"+strconv.Itoa(games[gameName]))
+	}
+
+	g.WriteBuffer += common.CreateGameSpyMessage(common.GameSpyCommand{
+		Command:      "wwfc_population",
+		CommandValue: "",
+		OtherValues: map[string]string{
+			"total": strconv.Itoa(total),
+			"games": strings.Join(counts, ","),
+			"id":    command.OtherValues["id"],
+		},
+	})
+}
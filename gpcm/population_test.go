@@ -0,0 +1,27 @@
+package gpcm
+
+import (
+	"testing"
+	"wwfc/common"
+)
+
+func TestPopulation(t *testing.T) {
+	session, _ := newTestSession(8001, "mariokartwii", "1.2.3.4:5000")
+	newTestSession(8002, "mariokartwii", "1.2.3.5:5000")
+	newTestSession(8003, "tetrisds", "1.2.3.6:5000")
+	defer removeTestSessions(8001, 8002, 8003)
+
+	session.getPopulation(common.GameSpyCommand{Command: "wwfc_population", OtherValues: map[string]string{"id": "1"}})
+
+	commands, err := common.ParseGameSpyMessage(session.WriteBuffer)
+	if err != nil || len(commands) != 1 || commands[0].Command != "wwfc_population" {
+		t.Fatalf("invalid population reply: %s", session.WriteBuffer)
+	}
+
+	if total := commands[0].OtherValues["total"]; total != "3" {
+		t.Errorf("unexpected total %s", total)
+	}
+	if games := commands[0].OtherValues["games"]; games != "mariokartwii:2,tetrisds:1" {
+		t.Errorf("unexpected game counts %s", games)
+	}
+}
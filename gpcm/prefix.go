@@ -0,0 +1,81 @@
+package gpcm
+
+import (
+	"strings"
+	"sync"
+	"wwfc/common"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// A handler for commands that no built-in handler took, registered by
+// command name prefix
+type PrefixHandler func(session *GameSpySession, command common.GameSpyCommand)
+
+var (
+	prefixHandlers      = map[string]PrefixHandler{}
+	prefixHandlersMutex = sync.RWMutex{}
+)
+
+// Register a handler for unknown commands starting with the prefix, so
+// experimental commands can be added without touching the dispatch in
+// handleRequest. The longest matching prefix wins. A nil handler removes the
+// registration.
+func RegisterPrefixHandler(prefix string, handler PrefixHandler) {
+	prefixHandlersMutex.Lock()
+	defer prefixHandlersMutex.Unlock()
+
+	if handler == nil {
+		delete(prefixHandlers, prefix)
+		return
+	}
+
+	prefixHandlers[prefix] = handler
+}
+
+// A prefix handler that replies to every command with the error
+func ReplyWithError(err GPError) PrefixHandler {
+	return func(session *GameSpySession, command common.GameSpyCommand) {
+		session.replyError(err)
+	}
+}
+
+func findPrefixHandler(name string) (PrefixHandler, bool) {
+	prefixHandlersMutex.RLock()
+	defer prefixHandlersMutex.RUnlock()
+
+	var handler PrefixHandler
+	longest := -1
+	for prefix, candidate := range prefixHandlers {
+		if len(prefix) > longest && strings.HasPrefix(name, prefix) {
+			handler = candidate
+			longest = len(prefix)
+		}
+	}
+
+	return handler, handler != nil
+}
+
+// Pass commands without a built-in handler to the matching prefix handler
+func (g *GameSpySession) handlePrefixCommands(commands []common.GameSpyCommand) []common.GameSpyCommand {
+	var unhandled []common.GameSpyCommand
+
+	for _, command := range commands {
+		if g.closing.Load() {
+			return nil
+		}
+
+		handler, exists := findPrefixHandler(command.Command)
+		if !exists {
+			unhandled = append(unhandled, command)
+			continue
+		}
+
+		logging.Info(g.ModuleName, "Command:", aurora.Yellow(command.Command), "(by prefix)")
+		handler(g, command)
+		g.checkWriteBufferSize()
+	}
+
+	return unhandled
+}
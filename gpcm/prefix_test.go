@@ -0,0 +1,49 @@
+package gpcm
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"wwfc/common"
+)
+
+func TestPrefixHandler(t *testing.T) {
+	var routed []string
+	RegisterPrefixHandler("wwfc_", ReplyWithError(ErrParse))
+	RegisterPrefixHandler("wwfc_exp_", func(session *GameSpySession, command common.GameSpyCommand) {
+		routed = append(routed, command.Command)
+		session.WriteBuffer += common.CreateGameSpyMessage(common.GameSpyCommand{
+			Command:      command.Command,
+			CommandValue: "",
+			OtherValues:  map[string]string{"id": command.OtherValues["id"]},
+		})
+	})
+	defer func() {
+		RegisterPrefixHandler("wwfc_", nil)
+		RegisterPrefixHandler("wwfc_exp_", nil)
+	}()
+
+	session, conn := newTestSession(9901, "mariokartwii", "1.2.3.4:5000")
+	defer removeTestSessions(9901)
+
+	unhandled := session.handlePrefixCommands([]common.GameSpyCommand{
+		{Command: "wwfc_exp_flag", OtherValues: map[string]string{"id": "1"}},
+		{Command: "other", OtherValues: map[string]string{}},
+	})
+
+	if len(routed) != 1 || routed[0] != "wwfc_exp_flag" {
+		t.Fatalf("expected the command to be routed to the longest prefix, got %v", routed)
+	}
+	if len(unhandled) != 1 || unhandled[0].Command != "other" {
+		t.Fatalf("expected only the unmatched command to be left, got %v", unhandled)
+	}
+	if !strings.HasPrefix(session.WriteBuffer, `\wwfc_exp_flag\`) {
+		t.Fatalf("expected a reply from the prefix handler, got %s", session.WriteBuffer)
+	}
+
+	// The shorter prefix still catches the rest of its commands
+	session.handlePrefixCommands([]common.GameSpyCommand{{Command: "wwfc_unknown", OtherValues: map[string]string{}}})
+	if !strings.Contains(conn.written(), `\err\`+strconv.Itoa(ErrParse.ErrorCode)) {
+		t.Fatalf("expected the default error reply, got %s", conn.written())
+	}
+}
@@ -0,0 +1,91 @@
+package gpcm
+
+import (
+	"wwfc/common"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+const (
+	maxPrefCount       = 32
+	maxPrefKeyLength   = 32
+	maxPrefValueLength = 256
+)
+
+func isValidPrefKey(key string) bool {
+	if key == "" || len(key) > maxPrefKeyLength {
+		return false
+	}
+
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '_' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// Store a small setting for the profile. An empty value deletes it.
+func (g *GameSpySession) setPref(command common.GameSpyCommand) {
+	key := command.OtherValues["key"]
+	if !isValidPrefKey(key) {
+		logging.Error(g.ModuleName, "Invalid preference key:", aurora.Cyan(key))
+		g.replyError(ErrUpdateProfile)
+		return
+	}
+
+	value := command.OtherValues["value"]
+	if len(value) > maxPrefValueLength {
+		logging.Error(g.ModuleName, "Preference value is too large:", aurora.Cyan(len(value)), "bytes")
+		g.replyError(ErrUpdateProfile)
+		return
+	}
+
+	if value != "" {
+		settings := g.store().GetProfileSettings(g.User.ProfileId)
+		if _, exists := settings[key]; !exists && len(settings) >= maxPrefCount {
+			logging.Error(g.ModuleName, "Too many preferences stored")
+			g.replyError(ErrUpdateProfile)
+			return
+		}
+	}
+
+	g.store().SetProfileSetting(g.User.ProfileId, key, value)
+
+	g.WriteBuffer += common.CreateGameSpyMessage(common.GameSpyCommand{
+		Command:      "wwfc_setpref",
+		CommandValue: "",
+		OtherValues: map[string]string{
+			"key": key,
+			"id":  command.OtherValues["id"],
+		},
+	})
+}
+
+func (g *GameSpySession) getPref(command common.GameSpyCommand) {
+	key := command.OtherValues["key"]
+	if !isValidPrefKey(key) {
+		logging.Error(g.ModuleName, "Invalid preference key:", aurora.Cyan(key))
+		g.replyError(ErrUpdateProfile)
+		return
+	}
+
+	value, exists := g.store().GetProfileSettings(g.User.ProfileId)[key]
+
+	found := "0"
+	if exists {
+		found = "1"
+	}
+
+	g.WriteBuffer += common.CreateGameSpyMessage(common.GameSpyCommand{
+		Command:      "wwfc_getpref",
+		CommandValue: "",
+		OtherValues: map[string]string{
+			"key":   key,
+			"value": value,
+			"found": found,
+			"id":    command.OtherValues["id"],
+		},
+	})
+}
@@ -0,0 +1,60 @@
+package gpcm
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"wwfc/common"
+	"wwfc/database"
+)
+
+func TestProfilePreferences(t *testing.T) {
+	previousDb := db
+	store := database.NewMemoryStore()
+	store.InsertUser(database.User{ProfileId: 3901, UserId: 1, GsbrCode: "RMCJ"})
+	db = store
+	defer func() { db = previousDb }()
+
+	session, conn := newTestSession(3901, "mariokartwii", "1.2.3.4:5000")
+	defer removeTestSessions(3901)
+
+	setPref := func(key string, value string) {
+		session.WriteBuffer = ""
+		session.setPref(common.GameSpyCommand{Command: "wwfc_setpref", OtherValues: map[string]string{"key": key, "value": value, "id": "1"}})
+	}
+
+	setPref("controls", "classic")
+	if !strings.HasPrefix(session.WriteBuffer, `\wwfc_setpref\`) {
+		t.Fatalf("preference was not stored: %s", session.WriteBuffer)
+	}
+
+	session.WriteBuffer = ""
+	session.getPref(common.GameSpyCommand{Command: "wwfc_getpref", OtherValues: map[string]string{"key": "controls", "id": "2"}})
+	commands, err := common.ParseGameSpyMessage(session.WriteBuffer)
+	if err != nil || len(commands) != 1 || commands[0].OtherValues["value"] != "classic" || commands[0].OtherValues["found"] != "1" {
+		t.Fatalf("preference was not returned: %s", session.WriteBuffer)
+	}
+
+	setPref("large", strings.Repeat("a", maxPrefValueLength+1))
+	if !strings.Contains(conn.written(), `\err\`+strconv.Itoa(ErrUpdateProfile.ErrorCode)) {
+		t.Fatal("oversized preference was accepted")
+	}
+
+	for i := 1; i < maxPrefCount; i++ {
+		setPref("pref"+strconv.Itoa(i), "1")
+	}
+	if count := len(store.GetProfileSettings(3901)); count != maxPrefCount {
+		t.Fatalf("expected %d preferences, got %d", maxPrefCount, count)
+	}
+
+	setPref("onetoomany", "1")
+	if session.WriteBuffer != "" || len(store.GetProfileSettings(3901)) != maxPrefCount {
+		t.Fatal("preference count cap was not enforced")
+	}
+
+	// Existing keys can still be changed at the cap
+	setPref("controls", "nunchuk")
+	if store.GetProfileSettings(3901)["controls"] != "nunchuk" {
+		t.Fatal("existing preference could not be updated at the cap")
+	}
+}
@@ -22,15 +22,17 @@ func (g *GameSpySession) getProfile(command common.GameSpyCommand) {
 
 	user := database.User{}
 	locstring := ""
+	region, lang, localeKnown := byte(0), byte(0), false
 
 	mutex.Lock()
 	if session, ok := sessions[uint32(profileId)]; ok && session.LoggedIn {
 		locstring = session.LocString
 		user = session.User
+		region, lang, localeKnown = session.Region, session.Language, true
 		mutex.Unlock()
 	} else {
 		mutex.Unlock()
-		user, ok = database.GetProfile(pool, ctx, uint32(profileId))
+		user, ok = storeForProfile(uint32(profileId)).GetProfile(uint32(profileId))
 		if !ok {
 			// The profile info was requested on is invalid.
 			g.replyError(ErrGetProfileBadProfile)
@@ -38,8 +40,9 @@ func (g *GameSpySession) getProfile(command common.GameSpyCommand) {
 		}
 	}
 
+	var reply common.GameSpyCommand
 	if user.ProfileId == g.User.ProfileId {
-		g.WriteBuffer += common.CreateGameSpyMessage(common.GameSpyCommand{
+		reply = common.GameSpyCommand{
 			Command:      "pi",
 			CommandValue: "",
 			OtherValues: map[string]string{
@@ -57,9 +60,9 @@ func (g *GameSpySession) getProfile(command common.GameSpyCommand) {
 				"loc":        locstring,
 				"id":         command.OtherValues["id"],
 			},
-		})
+		}
 	} else {
-		g.WriteBuffer += common.CreateGameSpyMessage(common.GameSpyCommand{
+		reply = common.GameSpyCommand{
 			Command:      "pi",
 			CommandValue: "",
 			OtherValues: map[string]string{
@@ -77,12 +80,12 @@ func (g *GameSpySession) getProfile(command common.GameSpyCommand) {
 				"loc":        locstring,
 				"id":         command.OtherValues["id"],
 			},
-		})
+		}
 	}
-}
 
-func (g *GameSpySession) updateProfile(command common.GameSpyCommand) {
-	g.User.UpdateProfile(pool, ctx, command.OtherValues)
+	g.addLocaleValues(reply.OtherValues, region, lang, localeKnown)
+	addNamecardValues(reply.OtherValues, user.ProfileId)
+	g.WriteBuffer += common.CreateGameSpyMessage(reply)
 }
 
 func VerifyPlayerSearch(profileId uint32, sessionKey int32, gameName string) (string, bool) {
@@ -0,0 +1,87 @@
+package gpcm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"wwfc/common"
+	"wwfc/database"
+)
+
+func TestProfileReplyRegion(t *testing.T) {
+	previousDb := db
+	db = database.NewMemoryStore()
+	defer func() { db = previousDb }()
+
+	session, _ := newTestSession(4001, "mariokartwii", "1.2.3.4:5000")
+	defer removeTestSessions(4001)
+
+	session.Region = RegionAustralia
+	session.Language = LangEnglish
+
+	session.getProfile(common.GameSpyCommand{
+		Command:     "getprofile",
+		OtherValues: map[string]string{"profileid": "4001", "id": "2"},
+	})
+
+	for _, expected := range []string{`\countrycode\AU\`, `\region\3\`, `\lang\1\`} {
+		if !strings.Contains(session.WriteBuffer, expected) {
+			t.Errorf("profile reply is missing %s: %s", expected, session.WriteBuffer)
+		}
+	}
+}
+
+func TestNamecard(t *testing.T) {
+	previousDb := db
+	store := database.NewMemoryStore()
+	db = store
+	defer func() { db = previousDb }()
+
+	store.InsertUser(database.User{ProfileId: 4101, UserId: 400, GsbrCode: "RMCJ"})
+	session, conn := newTestSession(4101, "mariokartwii", "1.2.3.4:5000")
+	defer removeTestSessions(4101)
+
+	png := append([]byte{0x89, 'P', 'N', 'G', 0x0D, 0x0A, 0x1A, 0x0A}, bytes.Repeat([]byte{0x42}, 100)...)
+	session.setNamecard(common.GameSpyCommand{
+		Command: "wwfc_namecard",
+		OtherValues: map[string]string{
+			"type": "image/png",
+			"data": common.Base64DwcEncoding.EncodeToString(png),
+			"id":   "1",
+		},
+	})
+
+	if !strings.Contains(session.WriteBuffer, `\wwfc_namecard\`) || strings.Contains(conn.written(), `\error\`) {
+		t.Fatalf("namecard was not accepted: %s", session.WriteBuffer)
+	}
+
+	session.WriteBuffer = ""
+	session.getProfile(common.GameSpyCommand{
+		Command:     "getprofile",
+		OtherValues: map[string]string{"profileid": "4101", "id": "2"},
+	})
+
+	encoded := `\wwfc_namecard\` + common.Base64DwcEncoding.EncodeToString(png) + `\`
+	if !strings.Contains(session.WriteBuffer, encoded) || !strings.Contains(session.WriteBuffer, `\wwfc_namecard_type\image/png\`) {
+		t.Fatalf("profile reply is missing the namecard: %s", session.WriteBuffer)
+	}
+
+	rejected := []map[string]string{
+		{"type": "image/png", "data": common.Base64DwcEncoding.EncodeToString(append(png, make([]byte, maxNamecardSize)...))},
+		{"type": "image/jpeg", "data": common.Base64DwcEncoding.EncodeToString(png)},
+		{"type": "text/html", "data": common.Base64DwcEncoding.EncodeToString(png)},
+	}
+
+	for _, values := range rejected {
+		errors := strings.Count(conn.written(), `\error\`)
+		session.setNamecard(common.GameSpyCommand{Command: "wwfc_namecard", OtherValues: values})
+
+		if strings.Count(conn.written(), `\error\`) != errors+1 {
+			t.Errorf("namecard with type %s and %d encoded bytes should have been rejected", values["type"], len(values["data"]))
+		}
+	}
+
+	if _, data := store.GetNamecard(4101); !bytes.Equal(data, png) {
+		t.Fatal("rejected namecards should not replace the stored one")
+	}
+}
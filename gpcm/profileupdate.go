@@ -0,0 +1,71 @@
+package gpcm
+
+import (
+	"sync"
+	"time"
+	"wwfc/common"
+)
+
+// Minimum time between profile writes for one session, 0 to write every update
+var profileUpdateInterval time.Duration
+
+// Profile updates waiting for the interval to pass
+type profileUpdateState struct {
+	mutex     sync.Mutex
+	lastWrite time.Time
+	pending   map[string]string
+	timer     *time.Timer
+}
+
+// Write the profile update straight away if the interval has passed since the
+// last write, otherwise merge it with the pending update, which is written
+// once the interval is up
+func (g *GameSpySession) updateProfile(command common.GameSpyCommand) {
+	if profileUpdateInterval <= 0 {
+		g.store().UpdateProfile(&g.User, command.OtherValues)
+		return
+	}
+
+	state := &g.profileUpdate
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	now := time.Now()
+	if state.pending == nil && now.Sub(state.lastWrite) >= profileUpdateInterval {
+		state.lastWrite = now
+		g.store().UpdateProfile(&g.User, command.OtherValues)
+		return
+	}
+
+	if state.pending == nil {
+		state.pending = map[string]string{}
+	}
+	for key, value := range command.OtherValues {
+		state.pending[key] = value
+	}
+
+	if state.timer == nil {
+		state.timer = time.AfterFunc(state.lastWrite.Add(profileUpdateInterval).Sub(now), g.flushProfileUpdate)
+	}
+}
+
+// Write the pending profile update, if there is one
+func (g *GameSpySession) flushProfileUpdate() {
+	state := &g.profileUpdate
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if state.timer != nil {
+		state.timer.Stop()
+		state.timer = nil
+	}
+
+	if state.pending == nil {
+		return
+	}
+
+	data := state.pending
+	state.pending = nil
+	state.lastWrite = time.Now()
+	g.store().UpdateProfile(&g.User, data)
+}
@@ -0,0 +1,54 @@
+package gpcm
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+	"wwfc/common"
+	"wwfc/database"
+)
+
+type countingStore struct {
+	*database.MemoryStore
+	writes atomic.Int32
+}
+
+func (s *countingStore) UpdateProfile(user *database.User, data map[string]string) {
+	s.writes.Add(1)
+	s.MemoryStore.UpdateProfile(user, data)
+}
+
+func TestProfileUpdateRateLimited(t *testing.T) {
+	profileUpdateInterval = 100 * time.Millisecond
+	defer func() { profileUpdateInterval = 0 }()
+
+	store := &countingStore{MemoryStore: database.NewMemoryStore()}
+	previousDb := db
+	db = store
+	defer func() { db = previousDb }()
+
+	session, _ := newTestSession(9501, "mariokartwii", "1.2.3.4:5000")
+	defer removeTestSessions(9501)
+
+	for i := 0; i < 20; i++ {
+		session.updateProfile(common.GameSpyCommand{Command: "updatepro", OtherValues: map[string]string{"firstname": "name" + strconv.Itoa(i)}})
+	}
+
+	if writes := store.writes.Load(); writes != 1 {
+		t.Fatalf("expected only the first update to be written straight away, got %d writes", writes)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	if writes := store.writes.Load(); writes != 2 {
+		t.Fatalf("expected the rest to be coalesced into one write, got %d writes", writes)
+	}
+
+	session.profileUpdate.mutex.Lock()
+	firstName := session.User.FirstName
+	session.profileUpdate.mutex.Unlock()
+	if firstName != "name19" {
+		t.Fatalf("expected the latest update to be applied, got %q", firstName)
+	}
+}
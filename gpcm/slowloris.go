@@ -0,0 +1,57 @@
+package gpcm
+
+import (
+	"time"
+)
+
+// Connections that haven't logged in must send at least this many bytes per
+// second, measured over each throughput window, 0 to disable
+var (
+	minPreLoginThroughput int
+	throughputWindow      = 5 * time.Second
+)
+
+type throughputMeter struct {
+	windowStart time.Time
+	bytes       int
+}
+
+func (m *throughputMeter) start() {
+	m.windowStart = time.Now()
+	m.bytes = 0
+}
+
+func (m *throughputMeter) add(n int) {
+	m.bytes += n
+}
+
+// The time at which the current window ends, or zero if the check is disabled
+func (m *throughputMeter) deadline() time.Time {
+	if minPreLoginThroughput <= 0 {
+		return time.Time{}
+	}
+
+	return m.windowStart.Add(throughputWindow)
+}
+
+// Check the throughput once the current window is over and start the next
+// one. Returns false if the connection sent too little during the window.
+func (m *throughputMeter) check() bool {
+	end := m.deadline()
+	now := time.Now()
+	if end.IsZero() || now.Before(end) {
+		return true
+	}
+
+	required := float64(minPreLoginThroughput) * now.Sub(m.windowStart).Seconds()
+	ok := float64(m.bytes) >= required
+	m.windowStart = now
+	m.bytes = 0
+	return ok
+}
+
+// Check if a connection that hasn't logged in yet is dribbling data too
+// slowly to ever finish logging in
+func (g *GameSpySession) isTooSlow() bool {
+	return !g.LoggedIn && !g.throughput.check()
+}
@@ -0,0 +1,62 @@
+package gpcm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlowPreLoginConnectionClosed(t *testing.T) {
+	minPreLoginThroughput = 20
+	throughputWindow = 200 * time.Millisecond
+	// Registered before dialing so it runs after the connection is closed and
+	// its handler has exited
+	t.Cleanup(func() {
+		minPreLoginThroughput = 0
+		throughputWindow = 5 * time.Second
+	})
+
+	conn, done := dialTestServer(t)
+
+	// Dribble a login one byte at a time, well under the required throughput
+	go func() {
+		for _, b := range []byte(`\login\\challenge\`) {
+			if _, err := conn.Write([]byte{b}); err != nil {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the slow connection to be closed")
+	}
+}
+
+func TestFastPreLoginConnectionKept(t *testing.T) {
+	minPreLoginThroughput = 20
+	throughputWindow = 200 * time.Millisecond
+	// Registered before dialing so it runs after the connection is closed and
+	// its handler has exited
+	t.Cleanup(func() {
+		minPreLoginThroughput = 0
+		throughputWindow = 5 * time.Second
+	})
+
+	conn, done := dialTestServer(t)
+
+	// Keep alives sent often enough keep the throughput up
+	for i := 0; i < 8; i++ {
+		if _, err := conn.Write([]byte(`\ka\\final\`)); err != nil {
+			t.Fatalf("connection closed despite sufficient throughput: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	select {
+	case <-done:
+		t.Fatal("connection closed despite sufficient throughput")
+	default:
+	}
+}
@@ -0,0 +1,52 @@
+package gpcm
+
+import (
+	"strings"
+	"time"
+)
+
+// Bounds on data received without the \final\ that ends a message
+const maxPendingMessageSize = 16 * 1024
+
+var pendingMessageTimeout = 10 * time.Second
+
+// Data received from the client, kept until the message it belongs to is
+// complete, as a message can be split across reads
+type messageBuffer struct {
+	data  string
+	since time.Time
+}
+
+// Add received data and take out all complete messages. Returns false if the
+// incomplete remainder has grown too large.
+func (b *messageBuffer) add(data string) (string, bool) {
+	b.data += data
+
+	complete := ""
+	if end := strings.LastIndex(b.data, `\final\`); end >= 0 {
+		end += len(`\final\`)
+		complete, b.data = b.data[:end], b.data[end:]
+	}
+
+	if b.data == "" {
+		b.since = time.Time{}
+	} else if complete != "" || b.since.IsZero() {
+		b.since = time.Now()
+	}
+
+	return complete, len(b.data) <= maxPendingMessageSize
+}
+
+// Get the time by which the incomplete message must be finished, or zero if
+// there is none
+func (b *messageBuffer) deadline() time.Time {
+	if b.data == "" {
+		return time.Time{}
+	}
+
+	return b.since.Add(pendingMessageTimeout)
+}
+
+func (b *messageBuffer) expired() bool {
+	return b.data != "" && !time.Now().Before(b.deadline())
+}
@@ -0,0 +1,97 @@
+package gpcm
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"wwfc/common"
+)
+
+// Connect to a GPCM connection handler and read the challenge
+func dialTestServer(t *testing.T) (net.Conn, <-chan struct{}) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := listener.Accept()
+		if err == nil {
+			handleRequest(conn)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close(); <-done })
+
+	buffer := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := conn.Read(buffer); err != nil || !strings.HasPrefix(string(buffer[:n]), `\lc\1\`) {
+		t.Fatalf("expected a challenge, got %q (%v)", buffer[:n], err)
+	}
+
+	return conn, done
+}
+
+func TestSplitMessage(t *testing.T) {
+	conn, _ := dialTestServer(t)
+
+	conn.Write([]byte(`\ka\`))
+	time.Sleep(50 * time.Millisecond)
+	conn.Write([]byte(`\final\`))
+
+	buffer := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, err := conn.Read(buffer); err != nil || !strings.HasPrefix(string(buffer[:n]), `\ka\`) {
+		t.Fatalf("expected a keep alive reply to the split message, got %q (%v)", buffer[:n], err)
+	}
+}
+
+func TestMessageWithoutFinalClosed(t *testing.T) {
+	pendingMessageTimeout = 200 * time.Millisecond
+	defer func() { pendingMessageTimeout = 10 * time.Second }()
+
+	conn, done := dialTestServer(t)
+
+	start := time.Now()
+	conn.Write([]byte(`\login\\challenge\abc`))
+
+	var reply []byte
+	buffer := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		n, err := conn.Read(buffer)
+		reply = append(reply, buffer[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	<-done
+
+	if elapsed := time.Since(start); elapsed < pendingMessageTimeout || elapsed > 3*time.Second {
+		t.Fatalf("connection closed after %v", elapsed)
+	}
+
+	commands, err := common.ParseGameSpyMessage(string(reply))
+	if err != nil || len(commands) != 1 || commands[0].Command != "error" {
+		t.Fatalf("expected a parse error, got %q", reply)
+	}
+}
+
+func TestMessageBufferSizeBound(t *testing.T) {
+	var buffer messageBuffer
+
+	if message, ok := buffer.add(`\ka\\final\\lo`); !ok || message != `\ka\\final\` {
+		t.Fatalf("unexpected complete message %q (%v)", message, ok)
+	}
+	if _, ok := buffer.add(strings.Repeat("x", maxPendingMessageSize)); ok {
+		t.Fatal("an unterminated message over the limit should be rejected")
+	}
+}
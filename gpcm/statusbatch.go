@@ -0,0 +1,87 @@
+package gpcm
+
+import (
+	"strconv"
+	"time"
+	"wwfc/common"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// Friend statuses waiting to be sent to a session, only the latest from each
+// friend is kept
+type statusBatch struct {
+	order    []uint32
+	statuses map[uint32]string
+	timer    *time.Timer
+}
+
+// Status pushes to a session within the window are written together, 0 to
+// send each one straight away
+var statusBatchWindow time.Duration
+
+// Queue a friend's status to be sent to the session. Expects the global mutex
+// to already be locked.
+func queueFriendStatus(from uint32, session *GameSpySession, msg string) {
+	if statusBatchWindow <= 0 {
+		sendMessageToSession("100", from, session, msg)
+		return
+	}
+
+	batch := &session.pendingStatus
+	if batch.statuses == nil {
+		batch.statuses = map[uint32]string{}
+	}
+
+	if _, queued := batch.statuses[from]; !queued {
+		batch.order = append(batch.order, from)
+	}
+	batch.statuses[from] = msg
+
+	if batch.timer == nil {
+		batch.timer = time.AfterFunc(statusBatchWindow, func() {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			session.flushStatusBatch()
+		})
+	}
+}
+
+// Queue a friend's status for the profile if it's online. Expects the global
+// mutex to already be locked.
+func queueFriendStatusToProfileId(from uint32, to uint32, msg string) bool {
+	if session, ok := sessions[to]; ok && session.LoggedIn {
+		queueFriendStatus(from, session, msg)
+		return true
+	}
+
+	logging.Info("GPCM", "Destination", aurora.Cyan(to), "from", aurora.Cyan(from), "is not online")
+	return false
+}
+
+// Write the queued statuses in one go. Expects the global mutex to already be
+// locked.
+func (g *GameSpySession) flushStatusBatch() {
+	batch := g.pendingStatus
+	g.pendingStatus = statusBatch{}
+
+	if !g.LoggedIn || len(batch.order) == 0 {
+		return
+	}
+
+	message := ""
+	for _, from := range batch.order {
+		message += common.CreateGameSpyMessage(common.GameSpyCommand{
+			Command:      "bm",
+			CommandValue: "100",
+			OtherValues: map[string]string{
+				"f":   strconv.FormatUint(uint64(from), 10),
+				"msg": batch.statuses[from],
+			},
+		})
+	}
+
+	g.write([]byte(message))
+}
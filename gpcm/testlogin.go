@@ -0,0 +1,49 @@
+package gpcm
+
+import (
+	"wwfc/common"
+	"wwfc/database"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// User ID just past the range the NAS hands out, reserved for test logins
+const testLoginUserId = 0x80000000000
+
+var (
+	// Accept logins with the reserved user ID against an in-memory database,
+	// so the GPCM flow can be exercised without Postgres. Never enabled
+	// alongside a real database.
+	testLoginEnabled bool
+	testLoginDb      database.Store = database.NewMemoryStore()
+)
+
+// Enable test logins if the config asks for them and has no database to
+// protect. Returns true if enabled.
+func setTestLogin(config common.Config) bool {
+	testLoginEnabled = false
+	if !config.DangerousTestLogin {
+		return false
+	}
+
+	if config.DatabaseAddress != "" {
+		logging.Error("GPCM", aurora.BgRed("Refusing to enable test logins with a database configured"))
+		return false
+	}
+
+	testLoginEnabled = true
+	logging.Warn("GPCM", aurora.BgRed("TEST LOGINS ARE ENABLED - ANYONE CAN LOG IN WITHOUT AN ACCOUNT - DO NOT USE IN PRODUCTION"))
+	return true
+}
+
+// Check if the login uses the reserved test credential while test logins are
+// enabled
+func (g *GameSpySession) isTestLogin(userId uint64) bool {
+	if !testLoginEnabled || userId != testLoginUserId {
+		return false
+	}
+
+	logging.Warn(g.ModuleName, aurora.BgRed("Test login, bypassing the database"))
+	return true
+}
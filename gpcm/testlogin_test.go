@@ -0,0 +1,35 @@
+package gpcm
+
+import (
+	"testing"
+	"wwfc/common"
+	"wwfc/database"
+)
+
+func TestTestLoginWithoutDatabase(t *testing.T) {
+	if setTestLogin(common.Config{DangerousTestLogin: true, DatabaseAddress: "127.0.0.1:5432"}) {
+		t.Fatal("test logins were enabled alongside a database")
+	}
+
+	previousDb, previousTestLoginDb := db, testLoginDb
+	db = nil
+	testLoginDb = database.NewMemoryStore()
+	defer func() {
+		db, testLoginDb = previousDb, previousTestLoginDb
+		testLoginEnabled = false
+	}()
+
+	if !setTestLogin(common.Config{DangerousTestLogin: true}) {
+		t.Fatal("test logins were not enabled")
+	}
+
+	session, _ := loginTestSession(t, testLoginUserId, 9801, "1.2.3.4:5000")
+	defer removeTestSessions(9801)
+
+	if !session.TestLogin || session.User.ProfileId != 9801 {
+		t.Fatalf("expected a test login for profile 9801, got %d", session.User.ProfileId)
+	}
+	if _, exists := testLoginDb.GetProfile(9801); !exists {
+		t.Fatal("the synthetic profile was not created")
+	}
+}
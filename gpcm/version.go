@@ -0,0 +1,63 @@
+package gpcm
+
+import (
+	"strconv"
+	"wwfc/common"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+var (
+	// Logins with an SDK revision below this are logged as outdated, 0 to disable
+	minSDKRevision int
+	// Reject outdated logins instead of only logging them
+	rejectOutdatedSDK bool
+	// Reject logins with a game code that doesn't belong to the game name
+	// instead of only logging them
+	rejectGameCodeMismatch bool
+)
+
+// Record the SDK revision sent with the login and check it against the
+// configured minimum. A missing or malformed revision counts as 0.
+func (g *GameSpySession) checkSDKRevision(command common.GameSpyCommand) bool {
+	g.SDKRevision, _ = strconv.Atoi(command.OtherValues["sdkrevision"])
+
+	if minSDKRevision <= 0 || g.SDKRevision >= minSDKRevision {
+		return true
+	}
+
+	logging.Warn(g.ModuleName, "Outdated SDK revision", aurora.Cyan(g.SDKRevision), "below minimum", aurora.Cyan(minSDKRevision))
+	if !rejectOutdatedSDK {
+		return true
+	}
+
+	g.replyError(GPError{
+		ErrorCode:   ErrLogin.ErrorCode,
+		ErrorString: "The client must be updated to connect.",
+		Fatal:       true,
+		WWFCMessage: WWFCMsgUpdateRequired,
+	})
+	return false
+}
+
+// Check that the game code in the login token matches the game name the
+// client claims to be, which a spoofed or broken client may get wrong
+func (g *GameSpySession) checkGameCode(gameCode string) bool {
+	if common.IsGameCodeConsistent(g.GameName, gameCode) {
+		return true
+	}
+
+	logging.Warn(g.ModuleName, "Game code", aurora.Cyan(gameCode), "does not belong to", aurora.Cyan(g.GameName))
+	if !rejectGameCodeMismatch {
+		return true
+	}
+
+	g.replyError(GPError{
+		ErrorCode:   ErrLogin.ErrorCode,
+		ErrorString: "The game code does not match the game.",
+		Fatal:       true,
+		WWFCMessage: WWFCMsgUnknownLoginError,
+	})
+	return false
+}
@@ -0,0 +1,68 @@
+package gpcm
+
+import (
+	"strings"
+	"testing"
+	"wwfc/common"
+)
+
+func TestOutdatedSDKRevision(t *testing.T) {
+	minSDKRevision = 3
+	defer func() { minSDKRevision, rejectOutdatedSDK = 0, false }()
+
+	login := func(revision string) common.GameSpyCommand {
+		return common.GameSpyCommand{Command: "login", OtherValues: map[string]string{"sdkrevision": revision}}
+	}
+
+	session, conn := newTestSession(9401, "mariokartwii", "1.2.3.4:5000")
+	defer removeTestSessions(9401)
+
+	if !session.checkSDKRevision(login("2")) || session.SDKRevision != 2 {
+		t.Fatal("an outdated login should only be logged when rejection is disabled")
+	}
+
+	rejectOutdatedSDK = true
+	if !session.checkSDKRevision(login("3")) {
+		t.Fatal("a login at the minimum revision should be accepted")
+	}
+	if conn.written() != "" {
+		t.Fatalf("unexpected reply: %s", conn.written())
+	}
+
+	if session.checkSDKRevision(login("2")) {
+		t.Fatal("an outdated login should be rejected")
+	}
+	if !strings.Contains(conn.written(), `\error\`) || !conn.closed {
+		t.Fatalf("expected a fatal error, got %s", conn.written())
+	}
+}
+
+func TestGameCodeMismatch(t *testing.T) {
+	rejectGameCodeMismatch = true
+	defer func() { rejectGameCodeMismatch = false }()
+
+	conn := &mockConn{remote: "1.2.3.4:5000"}
+	session := &GameSpySession{Conn: conn, Challenge: common.RandomString(10)}
+
+	// A Tetris DS token claiming to be Mario Kart Wii
+	authToken, nasChallenge := common.MarshalNASAuthToken("ATDE", 9501, "ATDE", 0, RegionEurope, LangGerman, "Player", UnitCodeDS, false)
+	clientChallenge := common.RandomString(10)
+	session.login(common.GameSpyCommand{
+		Command: "login",
+		OtherValues: map[string]string{
+			"authtoken": authToken,
+			"challenge": clientChallenge,
+			"response":  generateResponse(session.Challenge, nasChallenge, authToken, clientChallenge),
+			"gamename":  "mariokartwii",
+			"id":        "1",
+		},
+	})
+
+	if session.LoggedIn || !strings.Contains(conn.written(), "The game code does not match the game.") {
+		t.Fatalf("mismatched game code was accepted: %s", conn.written())
+	}
+
+	if !common.IsGameCodeConsistent("mariokartwii", "RMCP") || !common.IsGameCodeConsistent("tetrisds", "ATDE") {
+		t.Fatal("consistent game codes were rejected")
+	}
+}
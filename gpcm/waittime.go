@@ -0,0 +1,99 @@
+package gpcm
+
+import (
+	"strconv"
+	"sync"
+	"time"
+	"wwfc/common"
+)
+
+type pairingKey struct {
+	GameName string
+	Region   byte
+}
+
+var (
+	// Pairings older than the window no longer count towards the rate
+	pairingWindow = 10 * time.Minute
+
+	pairingHistory      = map[pairingKey][]time.Time{}
+	pairingHistoryMutex = sync.Mutex{}
+)
+
+// Drop the pairings that fell out of the window. Expects the pairing history
+// mutex to already be locked.
+func prunePairings(key pairingKey, now time.Time) []time.Time {
+	history := pairingHistory[key]
+	first := 0
+	for first < len(history) && now.Sub(history[first]) > pairingWindow {
+		first++
+	}
+
+	history = history[first:]
+	if len(history) == 0 {
+		delete(pairingHistory, key)
+		return nil
+	}
+
+	pairingHistory[key] = history
+	return history
+}
+
+func recordPairing(gameName string, region byte, at time.Time) {
+	pairingHistoryMutex.Lock()
+	defer pairingHistoryMutex.Unlock()
+
+	key := pairingKey{GameName: gameName, Region: region}
+	pairingHistory[key] = append(prunePairings(key, at), at)
+}
+
+// GetPairingCount returns the number of reservations paired for the game and
+// region within the pairing window
+func GetPairingCount(gameName string, region byte) int {
+	pairingHistoryMutex.Lock()
+	defer pairingHistoryMutex.Unlock()
+
+	return len(prunePairings(pairingKey{GameName: gameName, Region: region}, time.Now()))
+}
+
+// Estimate how long a new reservation will wait from the recent pairing rate.
+// Returns false if nothing was paired recently enough to tell.
+func estimateWaitTime(gameName string, region byte) (time.Duration, bool) {
+	count := GetPairingCount(gameName, region)
+	if count == 0 {
+		return 0, false
+	}
+
+	return pairingWindow / time.Duration(count), true
+}
+
+func (g *GameSpySession) getWaitTime(command common.GameSpyCommand) {
+	gameName := g.GameName
+	if value, ok := command.OtherValues["gamename"]; ok && value != "" {
+		gameName = value
+	}
+
+	region := g.Region
+	if value, ok := command.OtherValues["region"]; ok {
+		if parsed, err := strconv.ParseUint(value, 10, 8); err == nil {
+			region = byte(parsed)
+		}
+	}
+
+	// -1 tells the client there is no estimate
+	estimate := "-1"
+	if wait, ok := estimateWaitTime(gameName, region); ok {
+		estimate = strconv.FormatInt(int64(wait/time.Second), 10)
+	}
+
+	g.WriteBuffer += common.CreateGameSpyMessage(common.GameSpyCommand{
+		Command:      "wwfc_waittime",
+		CommandValue: "",
+		OtherValues: map[string]string{
+			"gamename": gameName,
+			"region":   strconv.Itoa(int(region)),
+			"estimate": estimate,
+			"id":       command.OtherValues["id"],
+		},
+	})
+}
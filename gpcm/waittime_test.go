@@ -0,0 +1,45 @@
+package gpcm
+
+import (
+	"testing"
+	"time"
+	"wwfc/common"
+)
+
+func TestWaitTimeEstimate(t *testing.T) {
+	session, _ := newTestSession(8101, "mariokartwii", "1.2.3.4:5000")
+	defer removeTestSessions(8101)
+	session.Region = 2
+
+	defer func() {
+		pairingHistoryMutex.Lock()
+		pairingHistory = map[pairingKey][]time.Time{}
+		pairingHistoryMutex.Unlock()
+	}()
+
+	now := time.Now()
+	recordPairing("mariokartwii", 2, now.Add(-pairingWindow*2))
+	for i := 0; i < 20; i++ {
+		recordPairing("mariokartwii", 2, now.Add(-time.Duration(i)*time.Second))
+	}
+	recordPairing("mariokartwii", 1, now)
+
+	session.getWaitTime(common.GameSpyCommand{Command: "wwfc_waittime", OtherValues: map[string]string{"id": "1"}})
+
+	commands, err := common.ParseGameSpyMessage(session.WriteBuffer)
+	if err != nil || len(commands) != 1 || commands[0].Command != "wwfc_waittime" {
+		t.Fatalf("invalid wait time reply: %s", session.WriteBuffer)
+	}
+
+	// 20 pairings in the 10 minute window
+	if estimate := commands[0].OtherValues["estimate"]; estimate != "30" {
+		t.Errorf("unexpected estimate %s", estimate)
+	}
+
+	session.WriteBuffer = ""
+	session.getWaitTime(common.GameSpyCommand{Command: "wwfc_waittime", OtherValues: map[string]string{"gamename": "tetrisds", "id": "2"}})
+	commands, _ = common.ParseGameSpyMessage(session.WriteBuffer)
+	if len(commands) != 1 || commands[0].OtherValues["estimate"] != "-1" {
+		t.Errorf("expected no estimate without pairings: %s", session.WriteBuffer)
+	}
+}
@@ -0,0 +1,59 @@
+package gpcm
+
+import (
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+const defaultMaxWriteBufferSize = 64 * 1024
+
+// Replies queued past this size are flushed early instead of waiting for the
+// end of the message
+var maxWriteBufferSize = defaultMaxWriteBufferSize
+
+func setMaxWriteBufferSize(size int) {
+	if size <= 0 {
+		size = defaultMaxWriteBufferSize
+	}
+
+	maxWriteBufferSize = size
+}
+
+// Send the queued replies to the client
+func (g *GameSpySession) flushWriteBuffer() {
+	if g.WriteBuffer == "" {
+		return
+	}
+
+	g.write([]byte(g.WriteBuffer))
+	g.WriteBuffer = ""
+}
+
+// Write directly to the connection. A failed write means the client is gone,
+// so the session is marked as closing and the rest of the message is skipped.
+func (g *GameSpySession) write(data []byte) bool {
+	if g.closing.Load() {
+		return false
+	}
+
+	if _, err := g.Conn.Write(data); err != nil {
+		if !g.closing.Swap(true) {
+			logging.Error(g.ModuleName, "Write failed, closing session:", err.Error())
+		}
+		return false
+	}
+
+	return true
+}
+
+// Flush the queued replies early if they've grown past the limit, so a long
+// run of commands in one message can't make the buffer grow without bound
+func (g *GameSpySession) checkWriteBufferSize() {
+	if len(g.WriteBuffer) <= maxWriteBufferSize {
+		return
+	}
+
+	logging.Warn(g.ModuleName, "Write buffer reached", aurora.Cyan(len(g.WriteBuffer)), "bytes, flushing early")
+	g.flushWriteBuffer()
+}
@@ -0,0 +1,40 @@
+package gpcm
+
+import (
+	"strings"
+	"testing"
+	"wwfc/common"
+)
+
+func TestWriteBufferFlushedEarly(t *testing.T) {
+	setMaxWriteBufferSize(64)
+	defer setMaxWriteBufferSize(0)
+
+	session, conn := newTestSession(9301, "mariokartwii", "1.2.3.4:5000")
+	defer removeTestSessions(9301)
+
+	var commands []common.GameSpyCommand
+	for i := 0; i < 10; i++ {
+		commands = append(commands, common.GameSpyCommand{Command: "getprofile", OtherValues: map[string]string{"id": "1"}})
+	}
+
+	session.handleCommand("getprofile", commands, func(command common.GameSpyCommand) {
+		session.WriteBuffer += common.CreateGameSpyMessage(common.GameSpyCommand{
+			Command:      "pi",
+			CommandValue: "",
+			OtherValues:  map[string]string{"padding": strings.Repeat("x", 20)},
+		})
+	})
+
+	if conn.written() == "" {
+		t.Fatal("the write buffer should have been flushed before the end of the message")
+	}
+	if len(session.WriteBuffer) > 64 {
+		t.Fatalf("write buffer grew past the limit: %d bytes", len(session.WriteBuffer))
+	}
+
+	session.flushWriteBuffer()
+	if count := strings.Count(conn.written(), `\pi\`); count != 10 {
+		t.Fatalf("expected 10 replies, got %d", count)
+	}
+}
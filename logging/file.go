@@ -0,0 +1,115 @@
+package logging
+
+import (
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Matches ANSI escape sequences used by aurora for colored output
+var ansiEscapeRegex = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+type rotatingFile struct {
+	mutex   sync.Mutex
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// SetFileOutput writes logs to the file at path in addition to stderr.
+// The file is rotated once it would exceed maxSize bytes or has been open
+// for longer than maxAge; a zero value disables that rotation trigger.
+// Colors are stripped from the file output.
+func SetFileOutput(path string, maxSize int64, maxAge time.Duration) error {
+	file := &rotatingFile{
+		path:    path,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+	}
+
+	if err := file.open(); err != nil {
+		return err
+	}
+
+	log.SetOutput(io.MultiWriter(os.Stderr, file))
+	return nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.file = file
+	r.size = info.Size()
+	r.opened = time.Now()
+	return nil
+}
+
+func (r *rotatingFile) rotate() error {
+	// Find an unused name for the old log
+	rotatedPath := r.path + "." + time.Now().Format("20060102-150405")
+	for i := 1; ; i++ {
+		if _, err := os.Stat(rotatedPath); os.IsNotExist(err) {
+			break
+		}
+		rotatedPath = r.path + "." + time.Now().Format("20060102-150405") + "." + strconv.Itoa(i)
+	}
+
+	// The old file is only closed once another is open, so a failed rotation
+	// never leaves the log without a file
+	old := r.file
+	if err := os.Rename(r.path, rotatedPath); err != nil {
+		// Carry on at the original path, which may have been removed
+		if r.open() == nil {
+			old.Close()
+		}
+		return err
+	}
+
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	old.Close()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stripped := ansiEscapeRegex.ReplaceAll(p, nil)
+
+	var rotateErr error
+	if r.size > 0 && ((r.maxSize > 0 && r.size+int64(len(stripped)) > r.maxSize) || (r.maxAge > 0 && time.Since(r.opened) > r.maxAge)) {
+		rotateErr = r.rotate()
+	}
+
+	n, err := r.file.Write(stripped)
+	r.size += int64(n)
+	if err != nil {
+		return 0, err
+	}
+	if rotateErr != nil {
+		return len(p), rotateErr
+	}
+
+	// Report the original length so the log package doesn't treat stripping as a short write
+	return len(p), nil
+}
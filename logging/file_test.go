@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileOutputRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wwfc.log")
+
+	SetLevel(4)
+	if err := SetFileOutput(path, 256, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer log.SetOutput(os.Stderr)
+
+	for i := 0; i < 10; i++ {
+		Info("TEST", "Log line number", i)
+	}
+
+	files, err := filepath.Glob(path + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 2 {
+		t.Fatalf("expected the log to be rotated, found %d files", len(files))
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) == 0 || len(data) > 256 {
+			t.Errorf("%s has unexpected size %d", file, len(data))
+		}
+		if bytes.Contains(data, []byte("\x1b[")) {
+			t.Errorf("%s contains color escape codes", file)
+		}
+	}
+}
+
+func TestFileOutputFailedRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wwfc.log")
+
+	file := &rotatingFile{path: path, maxSize: 32}
+	if err := file.open(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { file.file.Close() }()
+
+	if _, err := file.Write([]byte("first line\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rename fails once the log has been removed from under us
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("a line long enough to need rotating\n")); err == nil {
+		t.Fatal("expected the rotation to fail")
+	}
+	if _, err := file.Write([]byte("last line\n")); err != nil {
+		t.Fatalf("file output was lost after a failed rotation: %v", err)
+	}
+
+	files, err := filepath.Glob(path + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data []byte
+	for _, name := range files {
+		contents, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, contents...)
+	}
+	if !bytes.Contains(data, []byte("need rotating")) || !bytes.Contains(data, []byte("last line")) {
+		t.Fatalf("lines after the failed rotation are missing: %q", data)
+	}
+}
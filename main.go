@@ -1,13 +1,19 @@
 package main
 
 import (
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 	"wwfc/api"
 	"wwfc/common"
+	"wwfc/database"
 	"wwfc/gamestats"
 	"wwfc/gpcm"
 	"wwfc/gpsp"
 	"wwfc/logging"
+	"wwfc/metrics"
 	"wwfc/nas"
 	"wwfc/natneg"
 	"wwfc/qr2"
@@ -19,6 +25,43 @@ func main() {
 	config := common.GetConfig()
 	logging.SetLevel(*config.LogLevel)
 
+	if config.LogFile != "" {
+		err := logging.SetFileOutput(config.LogFile, config.LogMaxSize, time.Duration(config.LogMaxAge)*time.Hour)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	database.SetSlowQueryThreshold(time.Duration(config.DBSlowQueryThreshold) * time.Millisecond)
+	common.LoadFeatures(config)
+
+	if config.MetricsSink == "prometheus" {
+		metrics.SetSink(metrics.NewPrometheusSink())
+	}
+
+	// Reload the feature flags without restarting
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGHUP)
+		for range signals {
+			if err := common.ReloadFeatures("config.xml"); err != nil {
+				logging.Error("MAIN", "Failed to reload feature flags:", err.Error())
+				continue
+			}
+			logging.Notice("MAIN", "Reloaded feature flags")
+		}
+	}()
+
+	// Let servers notify their clients before exiting
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+
+		natneg.StopServer()
+		os.Exit(0)
+	}()
+
 	wg := &sync.WaitGroup{}
 	actions := []func(){nas.StartServer, gpcm.StartServer, qr2.StartServer, gpsp.StartServer, serverbrowser.StartServer, sake.StartServer, natneg.StartServer, api.StartServer, gamestats.StartServer}
 	wg.Add(5)
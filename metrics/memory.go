@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+)
+
+// Upper bounds of the histogram buckets, in seconds for durations
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type HistogramValue struct {
+	Count uint64
+	Sum   float64
+	// Number of observations in each of DefaultBuckets, not cumulative
+	Buckets []uint64
+}
+
+type metricKey struct {
+	name   string
+	labels string
+}
+
+// MemorySink keeps the latest value of every metric in memory. It is the
+// default sink.
+type MemorySink struct {
+	mutex      sync.Mutex
+	counters   map[metricKey]float64
+	gauges     map[metricKey]float64
+	histograms map[metricKey]*HistogramValue
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{
+		counters:   map[metricKey]float64{},
+		gauges:     map[metricKey]float64{},
+		histograms: map[metricKey]*HistogramValue{},
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Format the labels as name="value" pairs. A trailing name without a value is
+// dropped.
+func formatLabels(labels []string) string {
+	var builder strings.Builder
+	for i := 0; i+1 < len(labels); i += 2 {
+		if builder.Len() != 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(labels[i])
+		builder.WriteString(`="`)
+		builder.WriteString(labelValueEscaper.Replace(labels[i+1]))
+		builder.WriteByte('"')
+	}
+	return builder.String()
+}
+
+func makeMetricKey(name string, labels []string) metricKey {
+	return metricKey{name: name, labels: formatLabels(labels)}
+}
+
+func (s *MemorySink) Counter(name string, delta float64, labels ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.counters[makeMetricKey(name, labels)] += delta
+}
+
+func (s *MemorySink) Gauge(name string, value float64, labels ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.gauges[makeMetricKey(name, labels)] = value
+}
+
+func (s *MemorySink) Histogram(name string, value float64, labels ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := makeMetricKey(name, labels)
+	histogram, exists := s.histograms[key]
+	if !exists {
+		histogram = &HistogramValue{Buckets: make([]uint64, len(DefaultBuckets))}
+		s.histograms[key] = histogram
+	}
+
+	histogram.Count++
+	histogram.Sum += value
+	for i, bound := range DefaultBuckets {
+		if value <= bound {
+			histogram.Buckets[i]++
+			break
+		}
+	}
+}
+
+func (s *MemorySink) GetCounter(name string, labels ...string) float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.counters[makeMetricKey(name, labels)]
+}
+
+func (s *MemorySink) GetGauge(name string, labels ...string) float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.gauges[makeMetricKey(name, labels)]
+}
+
+// Get a copy of a histogram, or an empty one if nothing was observed
+func (s *MemorySink) GetHistogram(name string, labels ...string) HistogramValue {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	histogram, exists := s.histograms[makeMetricKey(name, labels)]
+	if !exists {
+		return HistogramValue{Buckets: make([]uint64, len(DefaultBuckets))}
+	}
+
+	value := *histogram
+	value.Buckets = append([]uint64{}, histogram.Buckets...)
+	return value
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrometheusSink(t *testing.T) {
+	sink := NewPrometheusSink()
+	sink.Counter("logins_total", 1, "game", "mariokartwii")
+	sink.Counter("logins_total", 2, "game", "mariokartwii")
+	sink.Gauge("sessions", 5)
+	sink.Histogram("login_seconds", 0.02)
+	sink.Histogram("login_seconds", 3)
+
+	if value := sink.GetCounter("logins_total", "game", "mariokartwii"); value != 3 {
+		t.Fatalf("expected counter 3, got %v", value)
+	}
+
+	var output strings.Builder
+	sink.WriteTo(&output)
+
+	for _, line := range []string{
+		"# TYPE logins_total counter",
+		`logins_total{game="mariokartwii"} 3`,
+		"# TYPE sessions gauge",
+		"sessions 5",
+		"# TYPE login_seconds histogram",
+		`login_seconds_bucket{le="0.01"} 0`,
+		`login_seconds_bucket{le="0.025"} 1`,
+		`login_seconds_bucket{le="5"} 2`,
+		`login_seconds_bucket{le="+Inf"} 2`,
+		"login_seconds_sum 3.02",
+		"login_seconds_count 2",
+	} {
+		if !strings.Contains(output.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, output.String())
+		}
+	}
+}
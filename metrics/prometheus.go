@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PrometheusSink keeps metrics in memory like MemorySink and serves them in
+// the Prometheus text exposition format
+type PrometheusSink struct {
+	*MemorySink
+}
+
+func NewPrometheusSink() *PrometheusSink {
+	return &PrometheusSink{MemorySink: NewMemorySink()}
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func joinLabels(labels string, extra string) string {
+	if labels == "" {
+		return "{" + extra + "}"
+	}
+	return "{" + labels + "," + extra + "}"
+}
+
+func sortedKeys[V any](values map[metricKey]V) []metricKey {
+	keys := make([]metricKey, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].labels < keys[j].labels
+	})
+	return keys
+}
+
+func writeSimple(builder *strings.Builder, metricType string, values map[metricKey]float64) {
+	lastName := ""
+	for _, key := range sortedKeys(values) {
+		if key.name != lastName {
+			fmt.Fprintf(builder, "# TYPE %s %s\n", key.name, metricType)
+			lastName = key.name
+		}
+
+		labels := ""
+		if key.labels != "" {
+			labels = "{" + key.labels + "}"
+		}
+		fmt.Fprintf(builder, "%s%s %s\n", key.name, labels, formatValue(values[key]))
+	}
+}
+
+// Write every metric in the Prometheus text format
+func (s *PrometheusSink) WriteTo(w io.Writer) (int64, error) {
+	var builder strings.Builder
+
+	s.mutex.Lock()
+	writeSimple(&builder, "counter", s.counters)
+	writeSimple(&builder, "gauge", s.gauges)
+
+	lastName := ""
+	for _, key := range sortedKeys(s.histograms) {
+		if key.name != lastName {
+			fmt.Fprintf(&builder, "# TYPE %s histogram\n", key.name)
+			lastName = key.name
+		}
+
+		histogram := s.histograms[key]
+		cumulative := uint64(0)
+		for i, bound := range DefaultBuckets {
+			cumulative += histogram.Buckets[i]
+			fmt.Fprintf(&builder, "%s_bucket%s %d\n", key.name, joinLabels(key.labels, `le="`+formatValue(bound)+`"`), cumulative)
+		}
+		fmt.Fprintf(&builder, "%s_bucket%s %d\n", key.name, joinLabels(key.labels, `le="+Inf"`), histogram.Count)
+
+		labels := ""
+		if key.labels != "" {
+			labels = "{" + key.labels + "}"
+		}
+		fmt.Fprintf(&builder, "%s_sum%s %s\n", key.name, labels, formatValue(histogram.Sum))
+		fmt.Fprintf(&builder, "%s_count%s %d\n", key.name, labels, histogram.Count)
+	}
+	s.mutex.Unlock()
+
+	n, err := io.WriteString(w, builder.String())
+	return int64(n), err
+}
+
+func (s *PrometheusSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.WriteTo(w)
+}
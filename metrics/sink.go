@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"sync"
+)
+
+// Sink receives the metrics emitted by the servers, so they can be exposed or
+// forwarded however the operator wants. Labels are given as alternating names
+// and values.
+type Sink interface {
+	// Add delta to a counter
+	Counter(name string, delta float64, labels ...string)
+	// Set a gauge to value
+	Gauge(name string, value float64, labels ...string)
+	// Record one observation in a histogram
+	Histogram(name string, value float64, labels ...string)
+}
+
+var (
+	sink      Sink = NewMemorySink()
+	sinkMutex      = sync.RWMutex{}
+)
+
+// Replace the sink metrics are emitted to
+func SetSink(newSink Sink) {
+	sinkMutex.Lock()
+	defer sinkMutex.Unlock()
+
+	sink = newSink
+}
+
+func GetSink() Sink {
+	sinkMutex.RLock()
+	defer sinkMutex.RUnlock()
+
+	return sink
+}
+
+func Counter(name string, delta float64, labels ...string) {
+	GetSink().Counter(name, delta, labels...)
+}
+
+func Gauge(name string, value float64, labels ...string) {
+	GetSink().Gauge(name, value, labels...)
+}
+
+func Histogram(name string, value float64, labels ...string) {
+	GetSink().Histogram(name, value, labels...)
+}
@@ -11,7 +11,6 @@ import (
 	"strconv"
 	"strings"
 	"time"
-	"unicode/utf16"
 	"wwfc/common"
 	"wwfc/database"
 	"wwfc/logging"
@@ -21,6 +20,9 @@ import (
 
 var (
 	dlcDir = "./dlc"
+
+	// Game codes that send in-game names in Shift-JIS
+	shiftJISNameGames = map[string]bool{}
 )
 
 func handleAuthRequest(moduleName string, w http.ResponseWriter, r *http.Request) {
@@ -44,6 +46,21 @@ func handleAuthRequest(moduleName string, w http.ResponseWriter, r *http.Request
 		unitcd = string(unitcdDecoded)
 	}
 
+	// Names are UTF-16 unless the game is known to use Shift-JIS. The DS device
+	// name comes from the system settings and is always UTF-16.
+	byteOrder := binary.ByteOrder(binary.BigEndian)
+	if unitcd == "0" {
+		byteOrder = binary.LittleEndian
+	}
+
+	shiftJIS := false
+	if gamecdValues, ok := r.PostForm["gamecd"]; ok {
+		gamecdDecoded, err := common.Base64DwcEncoding.DecodeString(gamecdValues[0])
+		if err == nil {
+			shiftJIS = shiftJISNameGames[string(gamecdDecoded)]
+		}
+	}
+
 	fields := map[string]string{}
 	for key, values := range r.PostForm {
 		if len(values) != 1 {
@@ -61,18 +78,8 @@ func handleAuthRequest(moduleName string, w http.ResponseWriter, r *http.Request
 			}
 
 			if key == "ingamesn" || key == "devname" || key == "words" {
-				// Special handling required for the UTF-16 string
-				var utf16String []uint16
-				if unitcd == "0" {
-					for i := 0; i < len(parsed)/2; i++ {
-						utf16String = append(utf16String, binary.LittleEndian.Uint16(parsed[i*2:i*2+2]))
-					}
-				} else {
-					for i := 0; i < len(parsed)/2; i++ {
-						utf16String = append(utf16String, binary.BigEndian.Uint16(parsed[i*2:i*2+2]))
-					}
-				}
-				value = string(utf16.Decode(utf16String))
+				// Special handling required for the UTF-16 or Shift-JIS string
+				value = common.DecodeInGameName(parsed, byteOrder, shiftJIS && key != "devname")
 			} else {
 				value = string(parsed)
 			}
@@ -43,6 +43,10 @@ func StartServer() {
 
 	serverName = config.ServerName
 
+	for _, gamecd := range config.ShiftJISNameGames {
+		shiftJISNameGames[gamecd] = true
+	}
+
 	address := *config.NASAddress + ":" + config.NASPort
 
 	if config.EnableHTTPS {
@@ -125,6 +129,12 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check for /api/metrics
+	if r.URL.Path == "/api/metrics" {
+		api.HandleMetrics(w, r)
+		return
+	}
+
 	// Check for /api/ban
 	if r.URL.Path == "/api/ban" {
 		api.HandleBan(w, r)
@@ -143,6 +153,18 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check for /api/export
+	if r.URL.Path == "/api/export" {
+		api.HandleExport(w, r)
+		return
+	}
+
+	// Check for /api/history
+	if r.URL.Path == "/api/history" {
+		api.HandleHistory(w, r)
+		return
+	}
+
 	logging.Info("NAS", aurora.Yellow(r.Method), aurora.Cyan(r.URL), "via", aurora.Cyan(r.Host), "from", aurora.BrightCyan(r.RemoteAddr))
 	replyHTTPError(w, 404, "404 Not Found")
 }
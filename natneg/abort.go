@@ -0,0 +1,43 @@
+package natneg
+
+// Reasons sent at the end of an abort report ack
+const (
+	AbortReasonNone          = 0x00
+	AbortReasonTimeout       = 0x01
+	AbortReasonPeerNotMapped = 0x02
+	AbortReasonSymmetricNAT  = 0x03
+	AbortReasonStalled       = 0x04
+	AbortReasonShutdown      = 0x05
+	AbortReasonErraticPorts  = 0x06
+	AbortReasonPeerRemoved   = 0x07
+)
+
+// Get why a client is being disconnected when the session closes, or
+// AbortReasonNone if it doesn't need to be told. Expects the session mutex to
+// already be locked.
+func (session *NATNEGSession) abortReason(client *NATNEGClient) byte {
+	// Already told when its prediction was abandoned
+	if client.PredictionAbandoned {
+		return AbortReasonNone
+	}
+
+	if client.ConnectingIndex != client.Index {
+		peer, exists := session.Clients[client.ConnectingIndex]
+		if exists && client.NATType == NATTypeSymmetric && peer.NATType == NATTypeSymmetric {
+			return AbortReasonSymmetricNAT
+		}
+		return AbortReasonTimeout
+	}
+
+	// A client that is waiting without having connected to anyone only needs
+	// to be told if no peer ever got mapped
+	if len(client.Connected) != 0 {
+		return AbortReasonNone
+	}
+	for _, other := range session.Clients {
+		if other != client && other.isMapped() {
+			return AbortReasonNone
+		}
+	}
+	return AbortReasonPeerNotMapped
+}
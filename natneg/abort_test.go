@@ -0,0 +1,81 @@
+package natneg
+
+import (
+	"net"
+	"testing"
+)
+
+func lastAbortReason(t *testing.T, conn *mockConn) byte {
+	packet := conn.last(NNReportReply)
+	if len(packet) != 22 {
+		t.Fatalf("expected an abort with a reason, got %x", packet)
+	}
+	return packet[21]
+}
+
+func negotiatingSession(t *testing.T, cookie uint32) (*NATNEGSession, *mockConn) {
+	session, conn := newTestSession(cookie)
+
+	session.Mutex.Lock()
+	defer session.Mutex.Unlock()
+
+	for i := byte(0); i < 2; i++ {
+		addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, i+1), Port: 5000}
+		session.handleInit(conn, addr, makeInitPacket(PortTypeNATNEG1, i, 0, "mariokartwii"), "NATNEG:test", 3)
+	}
+	if session.Clients[0].ConnectingIndex != 1 {
+		t.Fatal("clients are not negotiating")
+	}
+	return session, conn
+}
+
+func TestAbortReasons(t *testing.T) {
+	session, conn := negotiatingSession(t, 0xab000001)
+	session.close(conn, "NATNEG:test")
+	if reason := lastAbortReason(t, conn); reason != AbortReasonTimeout {
+		t.Errorf("timeout: got reason %d", reason)
+	}
+
+	session, conn = negotiatingSession(t, 0xab000002)
+	session.Clients[0].NATType = NATTypeSymmetric
+	session.Clients[1].NATType = NATTypeSymmetric
+	session.close(conn, "NATNEG:test")
+	if reason := lastAbortReason(t, conn); reason != AbortReasonSymmetricNAT {
+		t.Errorf("symmetric NAT: got reason %d", reason)
+	}
+
+	session, conn = negotiatingSession(t, 0xab000003)
+	session.Mutex.Lock()
+	session.abortPair(session.Clients[0], session.Clients[1])
+	session.Mutex.Unlock()
+	if reason := lastAbortReason(t, conn); reason != AbortReasonStalled {
+		t.Errorf("stalled: got reason %d", reason)
+	}
+	session.close(conn, "NATNEG:test")
+
+	session, conn = negotiatingSession(t, 0xab000004)
+	session.closeWithReason(conn, "NATNEG:test", AbortReasonShutdown)
+	if reason := lastAbortReason(t, conn); reason != AbortReasonShutdown {
+		t.Errorf("shutdown: got reason %d", reason)
+	}
+
+	// A client removed by a kick or ban
+	session, conn = negotiatingSession(t, 0xab000006)
+	mutex.Lock()
+	sessions[session.Cookie] = session
+	mutex.Unlock()
+	if closed := CloseSessionsForAddress("10.0.0.1:5000"); closed != 1 {
+		t.Errorf("peer removed: closed %d sessions", closed)
+	}
+	if reason := lastAbortReason(t, conn); reason != AbortReasonPeerRemoved {
+		t.Errorf("peer removed: got reason %d", reason)
+	}
+
+	// A lone client whose peer never showed up
+	session, conn = newTestSession(0xab000005)
+	session.handleInit(conn, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5000}, makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+	session.close(conn, "NATNEG:test")
+	if reason := lastAbortReason(t, conn); reason != AbortReasonPeerNotMapped {
+		t.Errorf("peer not mapped: got reason %d", reason)
+	}
+}
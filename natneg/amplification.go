@@ -0,0 +1,155 @@
+package natneg
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+const (
+	defaultReplyRateLimit = 20
+	replyRateWindow       = time.Second
+)
+
+type replyWindow struct {
+	start  time.Time
+	count  int
+	warned bool
+}
+
+var (
+	// Unauthenticated replies allowed per source IP per window. Consoles behind
+	// one carrier-grade NAT share an IP, so large ones may need a higher limit.
+	replyRateLimit = defaultReplyRateLimit
+
+	replyWindows     = map[string]*replyWindow{}
+	replyWindowMutex = sync.Mutex{}
+)
+
+// Set the unauthenticated replies allowed per source IP per second, 0 for the
+// default
+func setReplyRateLimit(limit int) {
+	if limit <= 0 {
+		limit = defaultReplyRateLimit
+	}
+	replyRateLimit = limit
+}
+
+// Check if a reply may be sent to the address, so the server can't be used to
+// reflect traffic at a spoofed source
+func allowReply(addr net.Addr, moduleName string) bool {
+	host := sourceHost(addr)
+
+	now := time.Now()
+
+	replyWindowMutex.Lock()
+	defer replyWindowMutex.Unlock()
+
+	window, exists := replyWindows[host]
+	if !exists || now.Sub(window.start) >= replyRateWindow {
+		if len(replyWindows) > 4096 {
+			for key, other := range replyWindows {
+				if now.Sub(other.start) >= replyRateWindow {
+					delete(replyWindows, key)
+				}
+			}
+		}
+
+		window = &replyWindow{start: now}
+		replyWindows[host] = window
+	}
+
+	window.count++
+	if window.count <= replyRateLimit {
+		return true
+	}
+
+	if !window.warned {
+		window.warned = true
+		logging.Warn(moduleName, "Suspected amplification attempt from", aurora.BrightCyan(host), "- dropping replies")
+	}
+
+	return false
+}
+
+func handleAddressCheck(conn net.PacketConn, addr net.Addr, buffer []byte, moduleName string, version byte, cookie uint32) {
+	// xx          - Port type
+	// xx          - Client index
+	// xx          - Use game port
+	// xx xx xx xx - Local IP
+	// xx xx       - Local port
+	if len(buffer) < 9 {
+		logging.Error(moduleName, "Invalid packet size")
+		return
+	}
+
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok || udpAddr.IP.To4() == nil {
+		return
+	}
+
+	// The reply has the same layout as the request with the public address filled
+	// in, so with the size check above it's never larger than what was received.
+	// The observed source port takes the place of the local port, so the client
+	// can compare it with the port it sent from to detect a symmetric mapping.
+	reply := createPacketHeader(version, NNAddressCheckReply, cookie)
+	reply = append(reply, buffer[:3]...)
+	reply = append(reply, udpAddr.IP.To4()...)
+	reply = binary.BigEndian.AppendUint16(reply, uint16(udpAddr.Port))
+
+	if !allowReply(addr, moduleName) {
+		return
+	}
+
+	conn.WriteTo(reply, addr)
+}
+
+type ReplyLimiterEntry struct {
+	Address   string
+	Replies   int
+	Throttled bool
+	Since     time.Time
+}
+
+// Get the reply limiter state for each address with a current window
+func GetReplyLimiterState() []ReplyLimiterEntry {
+	now := time.Now()
+
+	replyWindowMutex.Lock()
+	defer replyWindowMutex.Unlock()
+
+	entries := []ReplyLimiterEntry{}
+	for host, window := range replyWindows {
+		if now.Sub(window.start) >= replyRateWindow {
+			continue
+		}
+
+		entries = append(entries, ReplyLimiterEntry{
+			Address:   host,
+			Replies:   window.count,
+			Throttled: window.count > replyRateLimit,
+			Since:     window.start,
+		})
+	}
+
+	return entries
+}
+
+// Reset the reply limit for an IP address. Returns false if the address had
+// no limiter state.
+func ClearReplyLimit(address string) bool {
+	replyWindowMutex.Lock()
+	defer replyWindowMutex.Unlock()
+
+	if _, exists := replyWindows[address]; !exists {
+		return false
+	}
+
+	delete(replyWindows, address)
+	logging.Notice("NATNEG", "Cleared reply limit for", aurora.BrightCyan(address))
+	return true
+}
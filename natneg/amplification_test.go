@@ -0,0 +1,106 @@
+package natneg
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestAddressCheckAmplification(t *testing.T) {
+	conn := &mockConn{}
+	addr := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 5000}
+
+	request := createPacketHeader(3, NNAddressCheckRequest, 0)
+	request = append(request, PortTypeNATNEG1, 0, 0, 0, 0, 0, 0, 0, 0)
+
+	handleConnection(conn, addr, request)
+
+	reply := conn.last(NNAddressCheckReply)
+	if reply == nil {
+		t.Fatal("no address check reply")
+	}
+	if len(reply) > len(request) {
+		t.Fatalf("reply of %d bytes is larger than the %d byte request", len(reply), len(request))
+	}
+	if !net.IP(reply[15:19]).Equal(addr.IP) {
+		t.Fatalf("reply has the wrong public address: %v", net.IP(reply[15:19]))
+	}
+
+	for i := 0; i < replyRateLimit*3; i++ {
+		handleConnection(conn, addr, request)
+	}
+	if count := conn.count(NNAddressCheckReply); count != replyRateLimit {
+		t.Fatalf("expected replies to be limited to %d, got %d", replyRateLimit, count)
+	}
+
+	// A request too short to hold the reply gets nothing back
+	otherAddr := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 8), Port: 5000}
+	handleConnection(conn, otherAddr, createPacketHeader(3, NNAddressCheckRequest, 0))
+	if count := conn.count(NNAddressCheckReply); count != replyRateLimit {
+		t.Fatal("replied to a truncated request")
+	}
+}
+
+func TestClearReplyLimit(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 9), Port: 5000}
+	for i := 0; i <= replyRateLimit; i++ {
+		allowReply(addr, "NATNEG:test")
+	}
+
+	throttled := false
+	for _, entry := range GetReplyLimiterState() {
+		if entry.Address == "203.0.113.9" && entry.Throttled {
+			throttled = true
+		}
+	}
+	if !throttled {
+		t.Fatalf("address not shown as throttled: %+v", GetReplyLimiterState())
+	}
+
+	if !ClearReplyLimit("203.0.113.9") {
+		t.Fatal("address had no limiter state")
+	}
+	if !allowReply(addr, "NATNEG:test") {
+		t.Fatal("address is still throttled after clearing")
+	}
+}
+
+func TestAddressCheckObservedPort(t *testing.T) {
+	conn := &mockConn{}
+	addr := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 8), Port: 54321}
+
+	request := createPacketHeader(3, NNAddressCheckRequest, 0)
+	request = append(request, PortTypeNATNEG1, 0, 0, 192, 168, 1, 2, 0x12, 0x34)
+
+	handleConnection(conn, addr, request)
+
+	reply := conn.last(NNAddressCheckReply)
+	if len(reply) < 21 {
+		t.Fatalf("invalid address check reply: %x", reply)
+	}
+	if port := binary.BigEndian.Uint16(reply[19:21]); port != 54321 {
+		t.Fatalf("expected the observed port 54321 in the reply, got %d", port)
+	}
+}
+
+func TestReplyRateLimitConfigurable(t *testing.T) {
+	_, conn := newTestSession(0)
+	setReplyRateLimit(50)
+	defer setReplyRateLimit(0)
+
+	addr := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 10), Port: 5000}
+	request := createPacketHeader(3, NNAddressCheckRequest, 0)
+	request = append(request, PortTypeNATNEG1, 0, 0, 0, 0, 0, 0, 0, 0)
+
+	for i := 0; i < 60; i++ {
+		handleConnection(conn, addr, request)
+	}
+	if count := conn.count(NNAddressCheckReply); count != 50 {
+		t.Fatalf("expected replies to be limited to the configured 50, got %d", count)
+	}
+
+	setReplyRateLimit(0)
+	if replyRateLimit != defaultReplyRateLimit {
+		t.Fatalf("expected the default limit, got %d", replyRateLimit)
+	}
+}
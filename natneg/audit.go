@@ -0,0 +1,107 @@
+package natneg
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"wwfc/common"
+	"wwfc/database"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+const (
+	SessionOutcomeSucceeded   = "succeeded"
+	SessionOutcomeExpired     = "expired"
+	SessionOutcomeShutdown    = "shutdown"
+	SessionOutcomePeerRemoved = "peer-removed"
+
+	// Events past this many waiting for a flush are dropped, so a database
+	// outage can't grow the queue without bound
+	maxPendingSessionEvents = 4096
+)
+
+var (
+	// Database session events are written to, nil if they aren't recorded
+	auditStore database.Store
+
+	pendingSessionEvents []database.NATNEGSessionEvent
+	droppedSessionEvents int
+	sessionEventsMutex   = sync.Mutex{}
+)
+
+// Record each deleted session in the database, writing the queued events every
+// interval until the server is stopped
+func startSessionAudit(config common.Config, interval time.Duration) {
+	auditStore = connectStore(config)
+
+	go func() {
+		for !stopping.Load() {
+			time.Sleep(interval)
+			flushSessionEvents(auditStore)
+		}
+	}()
+}
+
+func (session *NATNEGSession) gameName() string {
+	for _, client := range session.Clients {
+		if client.GameName != "" {
+			return normalizeGameName(client.GameName)
+		}
+	}
+	return session.ExpectedGame
+}
+
+// Queue an event for a session that is being deleted. Expects the session
+// mutex to already be locked.
+func (session *NATNEGSession) recordSessionEvent(reason byte) {
+	if auditStore == nil {
+		return
+	}
+
+	outcome := SessionOutcomeExpired
+	if reason == AbortReasonShutdown {
+		outcome = SessionOutcomeShutdown
+	} else if reason == AbortReasonPeerRemoved {
+		outcome = SessionOutcomePeerRemoved
+	} else if session.isComplete() {
+		outcome = SessionOutcomeSucceeded
+	}
+
+	event := database.NATNEGSessionEvent{
+		CookieHash: hashObservationIP(fmt.Sprintf("%08x", session.Cookie)),
+		GameName:   session.gameName(),
+		Clients:    len(session.Clients),
+		Outcome:    outcome,
+		Created:    session.Created,
+		Deleted:    time.Now(),
+	}
+
+	sessionEventsMutex.Lock()
+	defer sessionEventsMutex.Unlock()
+
+	if len(pendingSessionEvents) >= maxPendingSessionEvents {
+		droppedSessionEvents++
+		return
+	}
+	pendingSessionEvents = append(pendingSessionEvents, event)
+}
+
+// Write the queued events to the store in one batch
+func flushSessionEvents(store database.Store) {
+	sessionEventsMutex.Lock()
+	events := pendingSessionEvents
+	dropped := droppedSessionEvents
+	pendingSessionEvents = nil
+	droppedSessionEvents = 0
+	sessionEventsMutex.Unlock()
+
+	if dropped != 0 {
+		logging.Warn("NATNEG", "Dropped", aurora.Cyan(dropped), "session events waiting for the database")
+	}
+
+	if len(events) != 0 {
+		store.SaveNATNEGSessionEvents(events)
+	}
+}
@@ -0,0 +1,77 @@
+package natneg
+
+import (
+	"net"
+	"testing"
+	"time"
+	"wwfc/database"
+)
+
+func TestSessionAuditEvents(t *testing.T) {
+	store := database.NewMemoryStore()
+	auditStore = store
+	defer func() { auditStore = nil }()
+
+	_, conn := newTestSession(0)
+
+	mutex.Lock()
+	session := createSession(conn, 0x0a0d1700, 3, "NATNEG:test")
+	mutex.Unlock()
+
+	addrs := [2]*net.UDPAddr{
+		{IP: net.IPv4(1, 2, 3, 4), Port: 5000},
+		{IP: net.IPv4(5, 6, 7, 8), Port: 5001},
+	}
+
+	session.Mutex.Lock()
+	for i := byte(0); i < 2; i++ {
+		session.handleInit(conn, addrs[i], makeInitPacket(PortTypeNATNEG1, i, 0, "mariokartwii"), "NATNEG:test", 3)
+	}
+	for i := byte(0); i < 2; i++ {
+		report := []byte{PortTypeNATNEG1, i, NNResultSuccess, NATTypeFullCone, 0, 0, 0, NATMappingConsistent, 0}
+		session.handleReport(conn, addrs[i], report, "NATNEG:test", 3)
+	}
+	session.Mutex.Unlock()
+
+	select {
+	case <-session.Done:
+	case <-time.After(time.Second):
+		t.Fatal("completed session was not closed")
+	}
+
+	// A session that never completes
+	mutex.Lock()
+	expired := createSession(conn, 0x0a0d1701, 3, "NATNEG:test")
+	mutex.Unlock()
+	expired.Mutex.Lock()
+	expired.handleInit(conn, addrs[0], makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+	expired.Mutex.Unlock()
+	expired.close(conn, "NATNEG:test")
+
+	// Wait for the early close to finish recording its event
+	session.Mutex.Lock()
+	session.Mutex.Unlock()
+
+	if events := store.GetNATNEGSessionEvents(10); len(events) != 0 {
+		t.Fatalf("events were written before the flush: %+v", events)
+	}
+
+	flushSessionEvents(store)
+
+	outcomes := map[string]database.NATNEGSessionEvent{}
+	for _, event := range store.GetNATNEGSessionEvents(10) {
+		outcomes[event.Outcome] = event
+	}
+
+	succeeded, ok := outcomes[SessionOutcomeSucceeded]
+	if !ok || succeeded.Clients != 2 || succeeded.GameName != "mariokartwii" || succeeded.Created.IsZero() || succeeded.Deleted.Before(succeeded.Created) {
+		t.Fatalf("no event for the completed session: %+v", outcomes)
+	}
+	if succeeded.CookieHash == "" || succeeded.CookieHash == "0a0d1700" {
+		t.Fatalf("cookie was not hashed: %q", succeeded.CookieHash)
+	}
+
+	if event, ok := outcomes[SessionOutcomeExpired]; !ok || event.Clients != 1 || event.CookieHash == succeeded.CookieHash {
+		t.Fatalf("no event for the expired session: %+v", outcomes)
+	}
+}
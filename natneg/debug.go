@@ -0,0 +1,51 @@
+package natneg
+
+import (
+	"encoding/hex"
+	"sync"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+var (
+	debugCookies = map[uint32]bool{}
+	debugMutex   = sync.RWMutex{}
+)
+
+// Enable or disable verbose logging for a single session cookie
+func SetCookieDebug(cookie uint32, on bool) {
+	debugMutex.Lock()
+	defer debugMutex.Unlock()
+
+	if on {
+		debugCookies[cookie] = true
+		logging.Notice("NATNEG", "Enabled verbose logging for cookie", aurora.Cyan(cookie))
+	} else {
+		delete(debugCookies, cookie)
+	}
+}
+
+func isCookieDebug(cookie uint32) bool {
+	debugMutex.RLock()
+	defer debugMutex.RUnlock()
+
+	return debugCookies[cookie]
+}
+
+// Log a message only if verbose logging is enabled for the cookie
+func logDebug(cookie uint32, moduleName string, arguments ...any) {
+	if !isCookieDebug(cookie) {
+		return
+	}
+
+	logging.Info(moduleName, arguments...)
+}
+
+func logDebugPacket(cookie uint32, moduleName string, command byte, buffer []byte) {
+	if !isCookieDebug(cookie) {
+		return
+	}
+
+	logging.Info(moduleName, "Packet", aurora.Yellow(command), hex.EncodeToString(buffer))
+}
@@ -0,0 +1,53 @@
+package natneg
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"wwfc/logging"
+)
+
+func TestCookieDebug(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+	logging.SetLevel(4)
+
+	SetCookieDebug(0x0000d0d0, true)
+	defer SetCookieDebug(0x0000d0d0, false)
+
+	conn := &mockConn{}
+	natnegConn = conn
+	defer func() {
+		mutex.Lock()
+		delete(sessions, 0x0000d0d0)
+		delete(sessions, 0x0000e0e0)
+		mutex.Unlock()
+	}()
+
+	addr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}
+	for _, cookie := range []uint32{0x0000d0d0, 0x0000e0e0} {
+		packet := createPacketHeader(3, NNInitRequest, cookie)
+		packet = append(packet, makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii")...)
+		handleConnection(conn, addr, packet)
+	}
+
+	debugLines := 0
+	for _, line := range strings.Split(output.String(), "\n") {
+		if !strings.Contains(line, "Packet") {
+			continue
+		}
+
+		debugLines++
+		if !strings.Contains(line, "0000d0d0") {
+			t.Errorf("verbose log for a cookie without debug enabled: %s", line)
+		}
+	}
+
+	if debugLines != 1 {
+		t.Fatalf("expected 1 verbose packet log, got %d", debugLines)
+	}
+}
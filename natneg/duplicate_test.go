@@ -0,0 +1,51 @@
+package natneg
+
+import (
+	"net"
+	"testing"
+)
+
+func TestDuplicateReportIgnored(t *testing.T) {
+	session, conn := newTestSession(0x0d0b1e00)
+	defer closeTestSession(session)
+
+	addrs := []*net.UDPAddr{
+		{IP: net.IPv4(1, 2, 3, 4), Port: 5000},
+		{IP: net.IPv4(5, 6, 7, 8), Port: 5001},
+		{IP: net.IPv4(9, 10, 11, 12), Port: 5002},
+	}
+
+	session.Mutex.Lock()
+	defer session.Mutex.Unlock()
+
+	for i := byte(0); i < 2; i++ {
+		session.handleInit(conn, addrs[i], makeInitPacket(PortTypeNATNEG1, i, 0, "mariokartwii"), "NATNEG:test", 3)
+	}
+
+	report := []byte{PortTypeNATNEG1, 0, NNResultSuccess, NATTypeFullCone, 0, 0, 0, NATMappingConsistent, 0}
+	session.handleReport(conn, addrs[0], report, "NATNEG:test", 3)
+
+	// A third client arrives and client 0 moves on to it before the
+	// retransmitted report for client 1 comes in
+	session.handleInit(conn, addrs[2], makeInitPacket(PortTypeNATNEG1, 2, 0, "mariokartwii"), "NATNEG:test", 3)
+	client := session.Clients[0]
+	if client.ConnectingIndex != 2 {
+		t.Fatalf("client 0 was not paired with client 2: %d", client.ConnectingIndex)
+	}
+
+	session.handleReport(conn, addrs[0], report, "NATNEG:test", 3)
+
+	if client.ConnectingIndex != 2 || client.Connected[2] || session.Clients[2].ConnectingIndex != 0 {
+		t.Fatal("duplicate report changed the pairing state")
+	}
+	if count := conn.count(NNReportReply); count != 2 {
+		t.Fatalf("expected the duplicate report to be acked, got %d acks", count)
+	}
+
+	// Once the new connect request is acknowledged, the next report counts
+	session.handleConnectReply(conn, addrs[0], []byte{PortTypeGamePort, 0}, "NATNEG:test", 3)
+	session.handleReport(conn, addrs[0], report, "NATNEG:test", 3)
+	if !client.Connected[2] || client.ConnectingIndex != 0 {
+		t.Fatal("report after the connect ack was not applied")
+	}
+}
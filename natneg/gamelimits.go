@@ -0,0 +1,52 @@
+package natneg
+
+import (
+	"sync"
+	"wwfc/common"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+type gameClientLimit struct {
+	Min byte
+	Max byte
+}
+
+var (
+	gameClientLimits      = map[string]gameClientLimit{}
+	gameClientLimitsMutex = sync.RWMutex{}
+)
+
+func setGameClientLimits(limits []common.NATNEGGameClientLimit) {
+	gameClientLimitsMutex.Lock()
+	defer gameClientLimitsMutex.Unlock()
+
+	gameClientLimits = map[string]gameClientLimit{}
+	for _, limit := range limits {
+		gameClientLimits[normalizeGameName(limit.GameName)] = gameClientLimit{Min: limit.Min, Max: limit.Max}
+	}
+}
+
+func getGameClientLimit(gameName string) gameClientLimit {
+	gameClientLimitsMutex.RLock()
+	defer gameClientLimitsMutex.RUnlock()
+
+	return gameClientLimits[gameName]
+}
+
+// Check that a new client index fits within the game's maximum client count.
+// Expects the session mutex to already be locked.
+func (session *NATNEGSession) allowsNewClient(gameName string, clientIndex byte, moduleName string) bool {
+	limit := getGameClientLimit(gameName)
+	if limit.Max == 0 {
+		return true
+	}
+
+	if clientIndex >= limit.Max || len(session.Clients) >= int(limit.Max) {
+		logging.Error(moduleName, "Rejecting client index", aurora.Cyan(clientIndex), "past the maximum of", aurora.Cyan(limit.Max), "clients for", aurora.Cyan(gameName))
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,27 @@
+package natneg
+
+import (
+	"net"
+	"testing"
+	"wwfc/common"
+)
+
+func TestGameClientLimit(t *testing.T) {
+	setGameClientLimits([]common.NATNEGGameClientLimit{{GameName: "MarioKartWii", Min: 2, Max: 2}})
+	defer setGameClientLimits(nil)
+
+	session, conn := newTestSession(0x6a3e0002)
+	defer closeTestSession(session)
+
+	for i := byte(0); i < 3; i++ {
+		addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, i+1), Port: 5000}
+		session.handleInit(conn, addr, makeInitPacket(PortTypeNATNEG1, i, 0, "mariokartwii"), "NATNEG:test", 3)
+	}
+
+	if _, exists := session.Clients[2]; exists || len(session.Clients) != 2 {
+		t.Fatalf("third client was accepted, %d clients", len(session.Clients))
+	}
+	if count := conn.count(NNInitReply); count != 2 {
+		t.Fatalf("expected 2 init acks, got %d", count)
+	}
+}
@@ -0,0 +1,67 @@
+package natneg
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestSamePublicIPUsesLocalEndpoints(t *testing.T) {
+	session, conn := newTestSession(0x4a1e0001)
+	defer closeTestSession(session)
+
+	session.Mutex.Lock()
+	defer session.Mutex.Unlock()
+
+	// Both clients are behind the same NAT, the third is elsewhere
+	clients := []struct {
+		public *net.UDPAddr
+		local  net.IP
+	}{
+		{&net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}, net.IPv4(192, 168, 1, 2)},
+		{&net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5001}, net.IPv4(192, 168, 1, 3)},
+		{&net.UDPAddr{IP: net.IPv4(5, 6, 7, 8), Port: 5002}, net.IPv4(192, 168, 1, 4)},
+	}
+
+	for i, client := range clients {
+		packet := []byte{PortTypeNATNEG1, byte(i), 0}
+		packet = append(packet, client.local.To4()...)
+		packet = binary.BigEndian.AppendUint16(packet, 0x1234)
+		packet = append(packet, []byte("mariokartwii\x00")...)
+		session.handleInit(conn, client.public, packet, "NATNEG:test", 3)
+	}
+
+	tests := []struct {
+		from     byte
+		to       byte
+		endpoint string
+	}{
+		{0, 1, "192.168.1.2:4660"},
+		{1, 0, "192.168.1.3:4660"},
+		{0, 2, "1.2.3.4:5000"},
+		{2, 0, "5.6.7.8:5002"},
+	}
+
+	for _, test := range tests {
+		session.Clients[test.from].sendConnectRequestPacket(conn, session.Clients[test.to], 3)
+
+		packet := conn.last(NNConnectRequest)
+		if len(packet) < 18 {
+			t.Fatalf("%d -> %d: missing connect request", test.from, test.to)
+		}
+
+		endpoint := (&net.UDPAddr{IP: net.IP(packet[12:16]), Port: int(binary.BigEndian.Uint16(packet[16:18]))}).String()
+		if endpoint != test.endpoint {
+			t.Errorf("%d -> %d: expected endpoint %s, got %s", test.from, test.to, test.endpoint, endpoint)
+		}
+	}
+
+	// Without a LAN endpoint the public one is left to hairpinning
+	session.Clients[0].LocalIP = ""
+	if address := session.Clients[0].connectAddress(session.Clients[1]); address != "1.2.3.4:5000" {
+		t.Errorf("expected the public endpoint without a LAN endpoint, got %s", address)
+	}
+	if strategy := session.Clients[0].connectStrategy(session.Clients[1]); strategy != StrategyHairpin {
+		t.Errorf("expected the hairpin strategy, got %s", strategy)
+	}
+}
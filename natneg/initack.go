@@ -0,0 +1,38 @@
+package natneg
+
+import (
+	"encoding/hex"
+	"wwfc/common"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// Bytes after the port type and client index in an init ack, for versions
+// without their own payload
+var defaultInitAckPayload = []byte{0xff, 0xff, 0x6d, 0x16, 0xb5, 0x7d, 0xea}
+
+// Init ack payloads for specific protocol versions
+var initAckPayloads = map[byte][]byte{}
+
+func setInitAckPayloads(payloads []common.NATNEGInitAckPayload) {
+	initAckPayloads = map[byte][]byte{}
+
+	for _, payload := range payloads {
+		data, err := hex.DecodeString(payload.Payload)
+		if err != nil {
+			logging.Warn("NATNEG", "Ignoring invalid init ack payload for version", aurora.Cyan(payload.Version))
+			continue
+		}
+
+		initAckPayloads[payload.Version] = data
+	}
+}
+
+func getInitAckPayload(version byte) []byte {
+	if payload, exists := initAckPayloads[version]; exists {
+		return payload
+	}
+
+	return defaultInitAckPayload
+}
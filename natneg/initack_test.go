@@ -0,0 +1,39 @@
+package natneg
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"wwfc/common"
+)
+
+func TestInitAckPayloadByVersion(t *testing.T) {
+	setInitAckPayloads([]common.NATNEGInitAckPayload{{Version: 2, Payload: "0102030405"}})
+	defer setInitAckPayloads(nil)
+
+	tests := []struct {
+		version byte
+		payload []byte
+	}{
+		{2, []byte{0x01, 0x02, 0x03, 0x04, 0x05}},
+		{3, defaultInitAckPayload},
+	}
+
+	for i, test := range tests {
+		session, conn := newTestSession(0x1a0c0001 + uint32(i))
+		session.Version = test.version
+
+		addr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000 + i}
+		session.Mutex.Lock()
+		session.handleInit(conn, addr, makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii"), "NATNEG:test", test.version)
+		session.Mutex.Unlock()
+
+		ack := conn.last(NNInitReply)
+		if ack == nil || ack[6] != test.version {
+			t.Fatalf("version %d: missing init ack", test.version)
+		}
+		if payload := ack[14:]; !bytes.Equal(payload, test.payload) {
+			t.Errorf("version %d: expected payload %x, got %x", test.version, test.payload, payload)
+		}
+	}
+}
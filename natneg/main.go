@@ -3,12 +3,17 @@ package natneg
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"wwfc/common"
 	"wwfc/logging"
+	"wwfc/metrics"
 
 	"github.com/logrusorgru/aurora/v3"
 )
@@ -32,6 +37,17 @@ const (
 	NNPreInitRequest      = 0x0F
 	NNPreInitReply        = 0x10
 
+	// Report result
+	NNResultNoResult        = 0x00
+	NNResultSuccess         = 0x01
+	NNResultDeadbeatPartner = 0x02
+	NNResultInitTimeout     = 0x03
+	NNResultPingTimeout     = 0x04
+	NNResultUnknownError    = 0x05
+
+	// Connect exchanges tried per pair before giving up
+	maxConnectAttempts = 3
+
 	// Port type
 	PortTypeGamePort = 0x00
 	PortTypeNATNEG1  = 0x01
@@ -56,29 +72,84 @@ const (
 )
 
 type NATNEGSession struct {
-	Open    bool
-	Version byte
-	Cookie  uint32
-	Mutex   sync.RWMutex
-	Clients map[byte]*NATNEGClient
+	Open            bool
+	Version         byte
+	Cookie          uint32
+	Mutex           sync.RWMutex
+	Clients         map[byte]*NATNEGClient
+	ExpectedGame    string
+	ExpectedClients byte
+	Prepared        bool
+	Created         time.Time
+
+	// Closed when the session is closed, to stop the connect request goroutines
+	Done      chan struct{}
+	exchanges sync.WaitGroup
+	// Pairs of client indexes with a connect request goroutine running
+	runningExchanges map[[2]byte]bool
+
+	// Number of times each pair of client indexes was paired
+	Exchanges map[[2]byte]int
 }
 
 type NATNEGClient struct {
-	Cookie          uint32
-	Index           byte
-	ConnectingIndex byte
-	ConnectAck      bool
-	Connected       map[byte]bool
-	NegotiateIP     string
-	LocalIP         string
-	ServerIP        string
-	GameName        string
+	Cookie              uint32
+	Index               byte
+	ConnectingIndex     byte
+	ConnectAck          bool
+	Connected           map[byte]bool
+	Attempts            map[byte]int
+	Outcomes            map[byte]bool
+	NATType             byte
+	MappingScheme       byte
+	NegotiateIP         string
+	NegotiatePortType   byte
+	Endpoints           map[byte]string
+	LocalIP             string
+	ServerIP            string
+	ObservedIP          string
+	GamePortFallback    bool
+	PredictionAbandoned bool
+	Reported            bool
+	PeerStrategy        string
+	GameName            string
+	Region              byte
+	SourceHost          string
 }
 
 var (
 	sessions   = map[uint32]*NATNEGSession{}
 	mutex      = sync.RWMutex{}
 	natnegConn net.PacketConn
+	probeConns = map[byte]net.PacketConn{}
+
+	// Games that only expect the init ack once the client is mapped
+	deferInitAckGames = map[string]bool{}
+
+	// How long to wait for an init from the game port before falling back to
+	// the NATNEG port endpoint
+	gamePortFallbackDelay = 3 * time.Second
+
+	// Read buffers reused across packets
+	readBufferPool = sync.Pool{
+		New: func() any {
+			buffer := make([]byte, 1024)
+			return &buffer
+		},
+	}
+
+	// Set to make the read loops exit
+	stopping    atomic.Bool
+	readTimeout = 500 * time.Millisecond
+
+	// Dropped zero cookie packets, logged at most once per second
+	zeroCookieDropped   uint64
+	zeroCookieLastWarn  time.Time
+	zeroCookieWarnMutex = sync.Mutex{}
+
+	// Connect replies that confirmed or contradicted the predicted endpoint
+	predictionHits   atomic.Uint64
+	predictionMisses atomic.Uint64
 )
 
 func StartServer() {
@@ -92,20 +163,146 @@ func StartServer() {
 	}
 
 	natnegConn = conn
+	stopping.Store(false)
+
+	if config.NATNEGReadTimeout > 0 {
+		readTimeout = time.Duration(config.NATNEGReadTimeout) * time.Millisecond
+	}
+
+	maxStrayBytes = config.NATNEGMaxStrayBytes
+	setReplyRateLimit(config.NATNEGReplyRateLimit)
+	setInitAckPayloads(config.NATNEGInitAckPayloads)
+	setEndpointRewrites(config.NATNEGEndpointRewrites)
+	maxSessionsPerSource = config.NATNEGMaxSessionsPerIP
+	maxPortDelta = config.NATNEGMaxPortDelta
+	setGameClientLimits(config.NATNEGGameClientLimits)
+
+	if config.NATNEGStatsFlushInterval > 0 {
+		startStatsPersistence(config, time.Duration(config.NATNEGStatsFlushInterval)*time.Second)
+	}
+	if config.NATNEGSessionAuditInterval > 0 {
+		startSessionAudit(config, time.Duration(config.NATNEGSessionAuditInterval)*time.Second)
+	}
+
+	for _, gameName := range config.RegionLockedGames {
+		regionLockedGames[normalizeGameName(gameName)] = true
+	}
+
+	for _, gameName := range config.NATNEGDeferInitAckGames {
+		deferInitAckGames[normalizeGameName(gameName)] = true
+	}
+
+	// Extra probe ports, for clients that negotiate on the NATNEG2 and NATNEG3 port types
+	for i, port := range config.NATNEGProbePorts {
+		portType := byte(PortTypeNATNEG2 + i)
+		if portType > PortTypeNATNEG3 {
+			logging.Warn("NATNEG", "Ignoring extra probe port", aurora.Cyan(port))
+			continue
+		}
+
+		probeAddress := *config.GameSpyAddress + ":" + strconv.Itoa(port)
+		probeConn, err := net.ListenPacket("udp", probeAddress)
+		if err != nil {
+			panic(err)
+		}
+
+		probeConns[portType] = probeConn
+		logging.Notice("NATNEG", "Listening on", probeAddress, "for", aurora.Cyan(getPortTypeName(portType)))
+		go serve(probeConn)
+	}
 
-	// Close the listener when the application closes.
-	defer conn.Close()
 	logging.Notice("NATNEG", "Listening on", address)
 
-	for {
-		buffer := make([]byte, 1024)
-		size, addr, err := conn.ReadFrom(buffer)
+	serve(conn)
+}
+
+// Read packets until the server is stopped. Reads use a deadline so the stop
+// flag is checked regularly, and the connection is closed on return.
+func serve(conn net.PacketConn) {
+	defer conn.Close()
+
+	for !stopping.Load() {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+
+		buffer, size, addr, err := readPacket(conn)
 		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			logging.Error("NATNEG", "Read error:", err.Error())
 			continue
 		}
 
-		go handleConnection(conn, addr, buffer[:size])
+		go handlePooledConnection(conn, addr, buffer, size)
+	}
+}
+
+// Read a packet into a buffer from the pool. The buffer is only taken when
+// the read succeeds, and must be released once the packet is handled.
+func readPacket(conn net.PacketConn) (*[]byte, int, net.Addr, error) {
+	buffer := readBufferPool.Get().(*[]byte)
+	size, addr, err := conn.ReadFrom(*buffer)
+	if err != nil {
+		readBufferPool.Put(buffer)
+		return nil, 0, nil, err
+	}
+
+	return buffer, size, addr, nil
+}
+
+// Handle a packet read with readPacket and give its buffer back to the pool.
+// Handlers must copy anything they keep out of the packet, which they already
+// do as sessions only store strings.
+func handlePooledConnection(conn net.PacketConn, addr net.Addr, buffer *[]byte, size int) {
+	defer readBufferPool.Put(buffer)
+	handleConnection(conn, addr, (*buffer)[:size])
+}
+
+// Get the socket to send from to reach a client on the port type it negotiated
+// with, as a restrictive NAT only lets in packets from the address it sent to
+func connForPortType(portType byte) net.PacketConn {
+	if conn, exists := probeConns[portType]; exists {
+		return conn
+	}
+
+	return natnegConn
+}
+
+// StopServer closes every open session, telling clients that are still
+// negotiating to give up instead of waiting for a timeout, then stops the read
+// loops
+func StopServer() {
+	if natnegConn == nil {
+		return
+	}
+
+	var openSessions []*NATNEGSession
+
+	mutex.Lock()
+	for _, session := range sessions {
+		openSessions = append(openSessions, session)
 	}
+	mutex.Unlock()
+
+	for _, session := range openSessions {
+		session.closeWithReason(natnegConn, "NATNEG:"+fmt.Sprintf("%08x", session.Cookie), AbortReasonShutdown)
+	}
+
+	if statsStore != nil {
+		flushStats(statsStore)
+	}
+	if auditStore != nil {
+		flushSessionEvents(auditStore)
+	}
+
+	// The read loops exit and close their sockets on their next read timeout
+	stopping.Store(true)
+	logging.Notice("NATNEG", "Stopped server, closed", aurora.Cyan(len(openSessions)), "sessions")
 }
 
 func handleConnection(conn net.PacketConn, addr net.Addr, buffer []byte) {
@@ -126,51 +323,43 @@ func handleConnection(conn net.PacketConn, addr net.Addr, buffer []byte) {
 	cookie := binary.BigEndian.Uint32(buffer[8:12])
 
 	moduleName := "NATNEG:" + fmt.Sprintf("%08x/", cookie) + addr.String()
+	logDebugPacket(cookie, moduleName, command, buffer)
+
+	if observePacket(addr, command, cookie, buffer, moduleName) {
+		return
+	}
+
+	if isStraySourceThrottled(addr) {
+		return
+	}
 
 	var session *NATNEGSession
 
 	if command != NNNatifyRequest && command != NNAddressCheckRequest {
+		// Matchmaking never hands out a zero cookie, and letting one through would
+		// put every client that sends it in the same session
+		if cookie == 0 {
+			logZeroCookie(addr)
+			return
+		}
+
 		mutex.Lock()
 		var exists bool
 		session, exists = sessions[cookie]
 		if !exists {
-			logging.Info(moduleName, "Creating session")
-			session = &NATNEGSession{
-				Open:    true,
-				Version: version,
-				Cookie:  cookie,
-				Mutex:   sync.RWMutex{},
-				Clients: map[byte]*NATNEGClient{},
-			}
-			sessions[cookie] = session
-
-			// Session has TTL of 30 seconds
-			time.AfterFunc(30*time.Second, func() {
-				session.Open = false
-
-				mutex.Lock()
-				delete(sessions, cookie)
+			// Only an init may open a session; anything else for an unknown
+			// cookie is a straggler from a session that already expired
+			if command != NNInitRequest {
 				mutex.Unlock()
+				logging.Info(moduleName, "Dropping command", aurora.Cyan(command), "for unknown session")
+				return
+			}
 
-				session.Mutex.Lock()
-				defer session.Mutex.Unlock()
-
-				// Disconnect each client
-				for _, client := range session.Clients {
-					if client.ConnectingIndex == client.Index {
-						continue
-					}
-
-					logging.Info(moduleName, "Disconnecting client", aurora.Cyan(client.Index))
-					// Send report ack, which will cause the client to cancel
-					reportAck := createPacketHeader(version, NNReportReply, session.Cookie)
-					reportAck = append(reportAck, 0x00, client.Index, 0x00)
-					reportAck = append(reportAck, 0x00, 0x00, 0x00, 0x06, 0x00, 0x00)
-					conn.WriteTo(reportAck, addr)
-				}
-
-				logging.Info(moduleName, "Deleted session")
-			})
+			logging.Info(moduleName, "Creating session")
+			session = createSession(conn, cookie, version, moduleName)
+		} else if session.Version == 0 {
+			// Prepared sessions take the version of the first packet
+			session.Version = version
 		}
 		mutex.Unlock()
 
@@ -207,6 +396,7 @@ func handleConnection(conn net.PacketConn, addr net.Addr, buffer []byte) {
 
 	case NNStateUpdate:
 		logging.Info(moduleName, "Command:", aurora.Yellow("NN_STATEUPDATE"))
+		session.handleStateUpdate(conn, addr, buffer[12:], moduleName, version)
 		break
 
 	case NNConnectRequest:
@@ -232,6 +422,8 @@ func handleConnection(conn net.PacketConn, addr net.Addr, buffer []byte) {
 
 	case NNAddressCheckRequest:
 		logging.Info(moduleName, "Command:", aurora.Yellow("NN_ADDRESS_CHECK"))
+		observeProbePacket(addr, buffer[12:])
+		handleAddressCheck(conn, addr, buffer[12:], moduleName, version, cookie)
 		break
 
 	case NNAddressCheckReply:
@@ -240,6 +432,7 @@ func handleConnection(conn net.PacketConn, addr net.Addr, buffer []byte) {
 
 	case NNNatifyRequest:
 		logging.Info(moduleName, "Command:", aurora.Yellow("NN_NATIFY_REQUEST"))
+		observeProbePacket(addr, buffer[12:])
 		break
 
 	case NNReportRequest:
@@ -261,6 +454,200 @@ func handleConnection(conn net.PacketConn, addr net.Addr, buffer []byte) {
 	}
 }
 
+func logZeroCookie(addr net.Addr) {
+	zeroCookieWarnMutex.Lock()
+	defer zeroCookieWarnMutex.Unlock()
+
+	zeroCookieDropped++
+	if time.Since(zeroCookieLastWarn) < time.Second {
+		return
+	}
+
+	logging.Warn("NATNEG:"+addr.String(), "Dropped", aurora.Cyan(zeroCookieDropped), "packets with a zero cookie")
+	zeroCookieDropped = 0
+	zeroCookieLastWarn = time.Now()
+}
+
+// Create and register a session. Expects the global mutex to already be locked.
+func createSession(conn net.PacketConn, cookie uint32, version byte, moduleName string) *NATNEGSession {
+	session := &NATNEGSession{
+		Open:    true,
+		Version: version,
+		Cookie:  cookie,
+		Mutex:   sync.RWMutex{},
+		Clients: map[byte]*NATNEGClient{},
+		Done:    make(chan struct{}),
+		Created: time.Now(),
+	}
+	sessions[cookie] = session
+	metrics.Counter("natneg_sessions_total", 1)
+	metrics.Gauge("natneg_sessions", float64(len(sessions)))
+
+	// Session has TTL of 30 seconds
+	time.AfterFunc(30*time.Second, func() {
+		session.close(conn, moduleName)
+	})
+
+	return session
+}
+
+// Game names are compared in lowercase without surrounding whitespace, so
+// "MarioKartWii " and "mariokartwii" are the same game
+func normalizeGameName(gameName string) string {
+	return strings.ToLower(strings.TrimSpace(gameName))
+}
+
+func isValidGameName(gameName string) bool {
+	if gameName == "" {
+		return false
+	}
+
+	for _, c := range gameName {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// Create a session ahead of the first packet, for a cookie given out by the
+// matchmaker. Inits for another game or with a client index outside of the
+// expected client count are rejected.
+func PrepareSession(cookie uint32, expectedGame string, expectedClients byte) {
+	moduleName := "NATNEG:" + fmt.Sprintf("%08x", cookie)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	session, exists := sessions[cookie]
+	if !exists {
+		session = createSession(natnegConn, cookie, 0, moduleName)
+	}
+
+	session.Mutex.Lock()
+	session.ExpectedGame = normalizeGameName(expectedGame)
+	session.ExpectedClients = expectedClients
+	session.Prepared = true
+	session.Mutex.Unlock()
+
+	logging.Info(moduleName, "Prepared session for", aurora.Cyan(expectedClients), "clients of", aurora.Cyan(expectedGame))
+}
+
+// AbortSession closes the session for the cookie before it times out, such as
+// when the matchmaker cancels the match it was prepared for. Returns false if
+// there is no such session.
+func AbortSession(cookie uint32) bool {
+	mutex.Lock()
+	session, exists := sessions[cookie]
+	mutex.Unlock()
+
+	if !exists {
+		return false
+	}
+
+	session.close(natnegConn, "NATNEG:"+fmt.Sprintf("%08x", cookie))
+	return true
+}
+
+// Close the session and send a report ack to each client that is still
+// negotiating, which will cause the client to cancel
+func (session *NATNEGSession) close(conn net.PacketConn, moduleName string) {
+	session.closeWithReason(conn, moduleName, AbortReasonNone)
+}
+
+// Close the session, sending the abort reason to each client that is told to
+// cancel. With AbortReasonNone the reason is worked out for each client.
+func (session *NATNEGSession) closeWithReason(conn net.PacketConn, moduleName string, reason byte) {
+	mutex.Lock()
+	if sessions[session.Cookie] == session {
+		delete(sessions, session.Cookie)
+		metrics.Gauge("natneg_sessions", float64(len(sessions)))
+	}
+	mutex.Unlock()
+
+	session.Mutex.Lock()
+	defer session.Mutex.Unlock()
+
+	if !session.Open {
+		return
+	}
+	session.Open = false
+	close(session.Done)
+	session.recordObservations()
+	session.recordSessionEvent(reason)
+	session.reportOutcome(reason)
+
+	for _, client := range session.Clients {
+		if client.SourceHost != "" {
+			releaseSourceSession(client.SourceHost)
+		}
+	}
+
+	// Disconnect each client
+	for _, client := range session.Clients {
+		clientReason := session.abortReason(client)
+		if clientReason == AbortReasonNone {
+			continue
+		}
+		if reason != AbortReasonNone {
+			clientReason = reason
+		}
+
+		logging.Info(moduleName, "Disconnecting client", aurora.Cyan(client.Index), "reason:", aurora.Cyan(clientReason))
+		session.sendAbort(conn, client, clientReason)
+	}
+
+	logging.Info(moduleName, "Deleted session")
+}
+
+// Send a report ack to the client, which makes it cancel the negotiation. The
+// reason is appended for modded clients to show, others ignore it.
+func (session *NATNEGSession) sendAbort(conn net.PacketConn, client *NATNEGClient, reason byte) {
+	destAddr, err := net.ResolveUDPAddr("udp", client.NegotiateIP)
+	if err != nil {
+		return
+	}
+
+	reportAck := createPacketHeader(session.Version, NNReportReply, session.Cookie)
+	reportAck = append(reportAck, 0x00, client.Index, 0x00)
+	reportAck = append(reportAck, 0x00, 0x00, 0x00, 0x06, 0x00, 0x00, reason)
+	conn.WriteTo(reportAck, destAddr)
+}
+
+// CloseSessionsForAddress closes every session with a client whose game port
+// is at the address, and returns the number of sessions closed.
+func CloseSessionsForAddress(address string) int {
+	var matching []*NATNEGSession
+
+	mutex.Lock()
+	for _, session := range sessions {
+		matching = append(matching, session)
+	}
+	mutex.Unlock()
+
+	closed := 0
+	for _, session := range matching {
+		session.Mutex.RLock()
+		found := false
+		for _, client := range session.Clients {
+			if client.ServerIP == address {
+				found = true
+				break
+			}
+		}
+		session.Mutex.RUnlock()
+
+		if !found {
+			continue
+		}
+
+		session.closeWithReason(natnegConn, "NATNEG:"+fmt.Sprintf("%08x", session.Cookie), AbortReasonPeerRemoved)
+		closed++
+	}
+
+	return closed
+}
+
 func getPortTypeName(portType byte) string {
 	switch portType {
 	default:
@@ -291,15 +678,21 @@ func (session *NATNEGSession) handleInit(conn net.PacketConn, addr net.Addr, buf
 	useGamePort := buffer[2]
 	localIPBytes := buffer[3:7]
 	localPort := binary.BigEndian.Uint16(buffer[7:9])
-	gameName, err := common.GetString(buffer[9:])
+	rawGameName, err := common.GetString(buffer[9:])
 	if err != nil {
 		logging.Error(moduleName, "Invalid gameName")
 		return
 	}
 
-	expectedSize := 9 + len(gameName) + 1
-	if len(buffer) != expectedSize {
-		logging.Warn(moduleName, "Stray", aurora.BrightCyan(len(buffer)-expectedSize), "bytes after packet")
+	expectedSize := 9 + len(rawGameName) + 1
+	if !checkStrayBytes(addr, len(buffer)-expectedSize, moduleName) {
+		return
+	}
+
+	gameName := normalizeGameName(rawGameName)
+	if !isValidGameName(gameName) {
+		logging.Error(moduleName, "Invalid gameName", aurora.Cyan(strconv.Quote(rawGameName)))
+		return
 	}
 
 	localIPStr := fmt.Sprintf("%d.%d.%d.%d:%d", localIPBytes[0], localIPBytes[1], localIPBytes[2], localIPBytes[3], localPort)
@@ -317,19 +710,38 @@ func (session *NATNEGSession) handleInit(conn net.PacketConn, addr net.Addr, buf
 		return
 	}
 
-	// Write the init acknowledgement to the requester address
-	ackHeader := createPacketHeader(version, NNInitReply, session.Cookie)
-	ackHeader = append(ackHeader, portType, clientIndex)
-	ackHeader = append(ackHeader, 0xff, 0xff, 0x6d, 0x16, 0xb5, 0x7d, 0xea)
-	conn.WriteTo(ackHeader, addr)
+	if session.ExpectedGame != "" && gameName != session.ExpectedGame {
+		logging.Error(moduleName, "Rejecting init for", aurora.Cyan(gameName), "in session prepared for", aurora.Cyan(session.ExpectedGame))
+		return
+	}
+	if session.ExpectedClients != 0 && clientIndex >= session.ExpectedClients {
+		logging.Error(moduleName, "Rejecting unexpected client index", aurora.Cyan(clientIndex))
+		return
+	}
 
+	// Write the init acknowledgement to the requester address. This is always
+	// sent, even for a retransmitted init, as the client may have lost the
+	// previous ack. Some games expect it only once the client is mapped.
 	sender, exists := session.Clients[clientIndex]
+	if !exists && !session.allowsNewClient(gameName, clientIndex, moduleName) {
+		return
+	}
+	if !exists && !reserveSourceSession(addr, moduleName) {
+		return
+	}
+
+	deferAck := deferInitAckGames[gameName]
+	if !deferAck {
+		session.sendInitAck(conn, addr, version, portType, clientIndex)
+	}
+
 	if !exists {
 		logging.Notice(moduleName, "Creating client index", aurora.Cyan(clientIndex))
 
 		for _, other := range session.Clients {
 			if other.GameName != gameName {
 				logging.Error(moduleName, "Game name mismatch", aurora.Cyan(other.GameName), "!=", aurora.Cyan(gameName))
+				releaseSourceSession(sourceHost(addr))
 				return
 			}
 		}
@@ -339,18 +751,27 @@ func (session *NATNEGSession) handleInit(conn net.PacketConn, addr net.Addr, buf
 			Index:           clientIndex,
 			ConnectingIndex: clientIndex,
 			Connected:       map[byte]bool{},
+			Attempts:        map[byte]int{},
+			Outcomes:        map[byte]bool{},
+			NATType:         NATTypeUnknown,
 			NegotiateIP:     "",
+			Endpoints:       map[byte]string{},
 			LocalIP:         "",
 			ServerIP:        "",
 			GameName:        "",
+			SourceHost:      sourceHost(addr),
 		}
 		session.Clients[clientIndex] = sender
 	}
 
 	sender.GameName = gameName
 
+	wasMapped := sender.isMapped()
+	previousNegotiateIP := sender.NegotiateIP
+	previousServerIP := sender.ServerIP
+
 	if portType != PortTypeGamePort {
-		sender.NegotiateIP = addr.String()
+		sender.setEndpoint(portType, addr.String())
 	}
 	if localPort != 0 {
 		sender.LocalIP = localIPStr
@@ -360,14 +781,73 @@ func (session *NATNEGSession) handleInit(conn net.PacketConn, addr net.Addr, buf
 	}
 
 	if !sender.isMapped() {
+		if sender.NegotiateIP != "" && sender.ServerIP == "" && !sender.GamePortFallback {
+			sender.GamePortFallback = true
+			time.AfterFunc(gamePortFallbackDelay, func() {
+				session.useNegotiateIPAsServer(sender, moduleName)
+			})
+		}
+		return
+	}
+
+	if deferAck {
+		session.sendInitAck(conn, addr, version, portType, clientIndex)
+	}
+
+	sender.updateRegion()
+	session.checkProbePorts(sender, moduleName)
+
+	// A retransmitted init that doesn't change the mapping must not start the
+	// connect exchange again
+	if wasMapped && sender.NegotiateIP == previousNegotiateIP && sender.ServerIP == previousServerIP {
 		return
 	}
-	// logging.Info(moduleName, "Mapped", aurora.BrightCyan(sender.NegotiateIP), aurora.BrightCyan(sender.LocalIP), aurora.BrightCyan(sender.ServerIP))
+	logDebug(session.Cookie, moduleName, "Mapped", aurora.BrightCyan(sender.NegotiateIP), aurora.BrightCyan(sender.LocalIP), aurora.BrightCyan(sender.ServerIP))
 
 	// Send the connect requests
 	session.sendConnectRequests(moduleName)
 }
 
+// Fall back to the NATNEG port endpoint if the client never sent an init from
+// its game port, so the negotiation doesn't get stuck
+func (session *NATNEGSession) useNegotiateIPAsServer(sender *NATNEGClient, moduleName string) {
+	session.Mutex.Lock()
+	defer session.Mutex.Unlock()
+
+	if !session.Open || sender.ServerIP != "" || sender.NegotiateIP == "" {
+		return
+	}
+
+	logging.Warn(moduleName, "No game port init from client", aurora.Cyan(sender.Index), "- using", aurora.BrightCyan(sender.NegotiateIP), "as the server endpoint")
+	sender.ServerIP = sender.NegotiateIP
+	sender.updateRegion()
+	session.sendConnectRequests(moduleName)
+}
+
+func (session *NATNEGSession) sendInitAck(conn net.PacketConn, addr net.Addr, version byte, portType byte, clientIndex byte) {
+	ackHeader := createPacketHeader(version, NNInitReply, session.Cookie)
+	ackHeader = append(ackHeader, portType, clientIndex)
+	ackHeader = append(ackHeader, getInitAckPayload(session.Version)...)
+	if allowReply(addr, "NATNEG:"+fmt.Sprintf("%08x/", session.Cookie)+addr.String()) {
+		conn.WriteTo(ackHeader, addr)
+	}
+}
+
+// Record the endpoint observed on a NATNEG port and pick the one used for
+// negotiation. Each probe port keeps its own endpoint, since a symmetric NAT
+// maps them differently; the NATNEG1 endpoint is preferred when it exists.
+func (client *NATNEGClient) setEndpoint(portType byte, endpoint string) {
+	client.Endpoints[portType] = endpoint
+
+	for _, preferred := range []byte{PortTypeNATNEG1, PortTypeNATNEG2, PortTypeNATNEG3} {
+		if endpoint, exists := client.Endpoints[preferred]; exists {
+			client.NegotiateIP = endpoint
+			client.NegotiatePortType = preferred
+			return
+		}
+	}
+}
+
 func (client *NATNEGClient) isMapped() bool {
 	if client.NegotiateIP == "" || client.ServerIP == "" {
 		return false
@@ -381,9 +861,29 @@ func createPacketHeader(version byte, command byte, cookie uint32) []byte {
 	return binary.BigEndian.AppendUint32(header, cookie)
 }
 
+// Fixed part of a report: port type, client index, result, NAT type and
+// mapping scheme
+const reportSize = 9
+
+// Build the acknowledgement of a report. The reply echoes the report with the
+// result cleared.
+func createReportReply(version byte, cookie uint32, report []byte) ([]byte, bool) {
+	if len(report) < reportSize {
+		return nil, false
+	}
+
+	portType := report[0]
+	clientIndex := report[1]
+	natInfo := report[3:reportSize]
+
+	response := createPacketHeader(version, NNReportReply, cookie)
+	response = append(response, portType, clientIndex, 0)
+	return append(response, natInfo...), true
+}
+
 func (session *NATNEGSession) sendConnectRequests(moduleName string) {
 	for id, sender := range session.Clients {
-		if !sender.isMapped() || sender.ConnectingIndex != id {
+		if !sender.isMapped() || sender.ConnectingIndex != id || sender.PredictionAbandoned {
 			continue
 		}
 
@@ -392,45 +892,107 @@ func (session *NATNEGSession) sendConnectRequests(moduleName string) {
 				continue
 			}
 
+			if destination.PredictionAbandoned {
+				continue
+			}
+
+			if sender.Attempts[destID] >= maxConnectAttempts || destination.Attempts[id] >= maxConnectAttempts {
+				continue
+			}
+
+			if !isRegionCompatible(sender, destination) {
+				logging.Warn(moduleName, "Not pairing", aurora.BrightCyan(id), "and", aurora.BrightCyan(destID), "due to a region mismatch")
+				continue
+			}
+
+			if !session.countExchange(sender, destination, moduleName) {
+				continue
+			}
+
 			logging.Notice(moduleName, "Exchange connect requests between", aurora.BrightCyan(id), "and", aurora.BrightCyan(destID))
 			sender.ConnectingIndex = destID
 			sender.ConnectAck = false
 			destination.ConnectingIndex = id
 			destination.ConnectAck = false
+			sender.PeerStrategy = destination.connectStrategy(sender)
+			destination.PeerStrategy = sender.connectStrategy(destination)
+
+			session.startExchange(sender, destination)
+
+			// The sender is busy now; pairing it again would leave the
+			// destination waiting on a client that moved on
+			break
+		}
+	}
+}
+
+// Start sending connect requests between the pair, unless they are already
+// being sent, in which case the running exchange picks up any reset connect
+// acks. Expects the session mutex to already be locked.
+func (session *NATNEGSession) startExchange(sender *NATNEGClient, destination *NATNEGClient) {
+	key := makePairKey(sender.Index, destination.Index)
+	if session.runningExchanges[key] {
+		return
+	}
 
-			go func(session *NATNEGSession, sender *NATNEGClient, destination *NATNEGClient) {
-				for {
-					if !session.Open {
-						return
-					}
+	if session.runningExchanges == nil {
+		session.runningExchanges = map[[2]byte]bool{}
+	}
+	session.runningExchanges[key] = true
 
-					check := false
+	session.exchanges.Add(1)
+	go func() {
+		defer session.exchanges.Done()
+		session.exchangeConnectRequests(sender, destination)
+	}()
+}
 
-					if !destination.ConnectAck && destination.ConnectingIndex == sender.Index {
-						check = true
-						sender.sendConnectRequestPacket(natnegConn, destination, session.Version)
-					}
+// Send connect requests to both clients until each acknowledges, or until the
+// session is closed
+func (session *NATNEGSession) exchangeConnectRequests(sender *NATNEGClient, destination *NATNEGClient) {
+	for {
+		session.Mutex.Lock()
+		if !session.Open {
+			session.Mutex.Unlock()
+			return
+		}
 
-					if !sender.ConnectAck && sender.ConnectingIndex == destination.Index {
-						check = true
-						destination.sendConnectRequestPacket(natnegConn, sender, session.Version)
-					}
+		check := false
 
-					if !check {
-						return
-					}
+		if !destination.ConnectAck && destination.ConnectingIndex == sender.Index {
+			check = true
+			sender.sendConnectRequestPacket(connForPortType(destination.NegotiatePortType), destination, session.Version)
+		}
+
+		if !sender.ConnectAck && sender.ConnectingIndex == destination.Index {
+			check = true
+			destination.sendConnectRequestPacket(connForPortType(sender.NegotiatePortType), sender, session.Version)
+		}
 
-					time.Sleep(500 * time.Millisecond)
-				}
-			}(session, sender, destination)
+		// Cleared while still locked, so a retry started after this check
+		// can't be mistaken for this exchange
+		if !check {
+			delete(session.runningExchanges, makePairKey(sender.Index, destination.Index))
+		}
+		session.Mutex.Unlock()
+
+		if !check {
+			return
+		}
+
+		select {
+		case <-session.Done:
+			return
+		case <-time.After(500 * time.Millisecond):
 		}
 	}
 }
 
 func (client *NATNEGClient) sendConnectRequestPacket(conn net.PacketConn, destination *NATNEGClient, version byte) {
 	connectHeader := createPacketHeader(version, NNConnectRequest, destination.Cookie)
-	connectHeader = append(connectHeader, common.IPFormatBytes(client.ServerIP)...)
-	_, port := common.IPFormatToInt(client.ServerIP)
+	connectAddress := client.connectAddress(destination)
+	connectHeader = append(connectHeader, common.IPFormatBytes(connectAddress)...)
+	_, port := common.IPFormatToInt(connectAddress)
 	connectHeader = binary.BigEndian.AppendUint16(connectHeader, port)
 	// Two bytes: "gotyourdata" and "finished"
 	connectHeader = append(connectHeader, 0x42, 0x00)
@@ -440,41 +1002,256 @@ func (client *NATNEGClient) sendConnectRequestPacket(conn net.PacketConn, destin
 		panic(err)
 	}
 	conn.WriteTo(connectHeader, destIPAddr)
+	destination.PeerStrategy = client.connectStrategy(destination)
 }
 
 func (session *NATNEGSession) handleConnectReply(conn net.PacketConn, addr net.Addr, buffer []byte, moduleName string, version byte) {
-	// portType := buffer[0]
+	// xx          - Port type
+	// xx          - Client index
+	// xx          - Use game port
+	// xx xx xx xx - Observed IP
+	// xx xx       - Observed port
+	if len(buffer) < 2 {
+		logging.Error(moduleName, "Invalid packet size")
+		return
+	}
+
 	clientIndex := buffer[1]
-	// useGamePort := buffer[2]
-	// localIPBytes := buffer[3:7]
 
-	if client, exists := session.Clients[clientIndex]; exists {
-		client.ConnectAck = true
+	client, exists := session.Clients[clientIndex]
+	if !exists {
+		return
+	}
+
+	client.ConnectAck = true
+	logDebug(session.Cookie, moduleName, "Connect ack from", aurora.Cyan(clientIndex))
+
+	// Older clients only send the port type and client index
+	if len(buffer) < 9 {
+		return
 	}
+
+	observedIPBytes := buffer[3:7]
+	observedPort := binary.BigEndian.Uint16(buffer[7:9])
+	if observedPort == 0 || binary.BigEndian.Uint32(observedIPBytes) == 0 {
+		return
+	}
+
+	observedIP := fmt.Sprintf("%d.%d.%d.%d:%d", observedIPBytes[0], observedIPBytes[1], observedIPBytes[2], observedIPBytes[3], observedPort)
+	if observedIP == client.ServerIP {
+		predictionHits.Add(1)
+		return
+	}
+
+	predictionMisses.Add(1)
+	if client.ObservedIP != observedIP {
+		logging.Notice(moduleName, "Client", aurora.Cyan(clientIndex), "observed at", aurora.BrightCyan(observedIP), "instead of", aurora.BrightCyan(client.ServerIP))
+		client.ObservedIP = observedIP
+	}
+}
+
+// Get how many connect replies confirmed or contradicted the predicted endpoint
+func GetPredictionStats() (hits uint64, misses uint64) {
+	return predictionHits.Load(), predictionMisses.Load()
+}
+
+// Get the address to give the destination in connect requests. Clients behind
+// the same public IP get each other's LAN endpoint, since reaching the public
+// one needs NAT hairpinning, which many routers don't support. Otherwise an
+// endpoint the client reported as working is preferred over the predicted one.
+func (client *NATNEGClient) connectAddress(destination *NATNEGClient) string {
+	if client.sharesPublicIP(destination) && client.hasLocalEndpoint() {
+		return client.LocalIP
+	}
+
+	if client.ObservedIP != "" {
+		return RewriteEndpoint(client.ObservedIP)
+	}
+
+	return RewriteEndpoint(client.ServerIP)
+}
+
+// Check if both clients are behind the same public IP
+func (client *NATNEGClient) sharesPublicIP(other *NATNEGClient) bool {
+	return client.ServerIP != "" && endpointHost(client.ServerIP) == endpointHost(other.ServerIP)
+}
+
+// Check if the client reported a usable LAN endpoint
+func (client *NATNEGClient) hasLocalEndpoint() bool {
+	host, port, err := net.SplitHostPort(client.LocalIP)
+	if err != nil || port == "0" {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && !ip.IsUnspecified()
 }
 
 func (session *NATNEGSession) handleReport(conn net.PacketConn, addr net.Addr, buffer []byte, _ string, version byte) {
-	response := createPacketHeader(version, NNReportReply, session.Cookie)
-	response = append(response, buffer[:9]...)
-	response[14] = 0
-	conn.WriteTo(response, addr)
+	moduleName := "NATNEG:" + fmt.Sprintf("%08x/", session.Cookie) + addr.String()
 
-	// portType := buffer[0]
+	response, ok := createReportReply(version, session.Cookie, buffer)
+	if !ok {
+		logging.Error(moduleName, "Invalid packet size")
+		return
+	}
+
+	if allowReply(addr, moduleName) {
+		conn.WriteTo(response, addr)
+	}
+
+	portType := buffer[0]
 	clientIndex := buffer[1]
 	result := buffer[2]
-	// natType := buffer[3]
-	// mappingScheme := buffer[7]
+	natType := buffer[3]
+	mappingScheme := buffer[7]
 	// gameName, err := common.GetString(buffer[11:])
 
-	moduleName := "NATNEG:" + fmt.Sprintf("%08x/", session.Cookie) + addr.String()
 	logging.Notice(moduleName, "Report from", aurora.BrightCyan(clientIndex), "result:", aurora.Cyan(result))
 
-	if client, exists := session.Clients[clientIndex]; exists {
-		client.Connected[client.ConnectingIndex] = true
-		client.ConnectingIndex = clientIndex
-		client.ConnectAck = false
+	if portType > 0x03 {
+		logging.Error(moduleName, "Invalid port type in report")
+		return
+	}
+
+	client, exists := session.Clients[clientIndex]
+	if !exists {
+		logging.Error(moduleName, "Report for unknown client index", aurora.Cyan(clientIndex))
+		return
 	}
 
+	// Only accept a report for a negotiation that is actually in progress, so a
+	// spoofed report can't mark a pair as connected early
+	if client.ConnectingIndex == client.Index {
+		logging.Warn(moduleName, "Ignoring report from client", aurora.Cyan(clientIndex), "which is not negotiating")
+		return
+	}
+	peer, exists := session.Clients[client.ConnectingIndex]
+	if !exists {
+		logging.Warn(moduleName, "Ignoring report from client", aurora.Cyan(clientIndex), "for unknown peer", aurora.Cyan(client.ConnectingIndex))
+		return
+	}
+
+	// Clients retransmit a report until they see the ack, which was already
+	// sent above. A repeat must not touch the pairing state again.
+	if client.isRetransmittedReport() {
+		logDebug(session.Cookie, moduleName, "Ignoring retransmitted report from client", aurora.Cyan(clientIndex))
+		return
+	}
+
+	if natType <= NATTypeUnknown {
+		client.NATType = natType
+	}
+	if mappingScheme <= NATMappingMixed {
+		client.MappingScheme = mappingScheme
+	}
+
+	client.Reported = true
+
+	if result == NNResultSuccess {
+		client.Connected[peer.Index] = true
+	} else {
+		// Both clients report a failed attempt. A client that hasn't
+		// acknowledged the retry's connect request yet is still reporting on
+		// the attempt its peer's report already retried.
+		if !client.ConnectAck && session.runningExchanges[makePairKey(clientIndex, peer.Index)] {
+			logDebug(session.Cookie, moduleName, "Retry between", aurora.BrightCyan(clientIndex), "and", aurora.BrightCyan(peer.Index), "is already running")
+			return
+		}
+
+		// Attempts are counted for the pair, not for each client reporting
+		attempts := client.Attempts[peer.Index] + 1
+		if peer.Attempts[clientIndex] >= attempts {
+			attempts = peer.Attempts[clientIndex] + 1
+		}
+		client.Attempts[peer.Index] = attempts
+		peer.Attempts[clientIndex] = attempts
+
+		if attempts < maxConnectAttempts {
+			logging.Warn(moduleName, "Connection between", aurora.BrightCyan(clientIndex), "and", aurora.BrightCyan(peer.Index), "failed, retrying")
+
+			// Punch again with the same pair
+			client.ConnectAck = false
+			if peer.ConnectingIndex == clientIndex {
+				peer.ConnectAck = false
+			}
+			session.startExchange(client, peer)
+			return
+		}
+
+		logging.Error(moduleName, "Giving up on connection between", aurora.BrightCyan(clientIndex), "and", aurora.BrightCyan(peer.Index))
+		if client.sharesPublicIP(peer) {
+			logging.Warn(moduleName, "Clients", aurora.BrightCyan(clientIndex), "and", aurora.BrightCyan(peer.Index), "share the public IP", aurora.BrightCyan(endpointHost(client.ServerIP)), "but reached each other neither on the LAN nor through NAT hairpinning")
+			metrics.Counter("natneg_same_ip_failures_total", 1)
+		}
+	}
+
+	// Record the outcome once both sides have given their final report
+	client.Outcomes[peer.Index] = result == NNResultSuccess
+	if peerOutcome, reported := peer.Outcomes[clientIndex]; reported {
+		success := client.Outcomes[peer.Index] && peerOutcome
+		recordNATTypeOutcome(client.NATType, peer.NATType, success)
+
+		outcome := "failure"
+		if success {
+			outcome = "success"
+		}
+		metrics.Counter("natneg_negotiations_total", 1, "result", outcome)
+		if success {
+			recordStrategySuccess(pairStrategy(client, peer))
+		}
+		if !session.Created.IsZero() {
+			metrics.Histogram("natneg_negotiation_seconds", time.Since(session.Created).Seconds(), "result", outcome)
+		}
+	}
+
+	client.ConnectingIndex = clientIndex
+	client.ConnectAck = false
+
 	// Send remaining requests
 	session.sendConnectRequests(moduleName)
+
+	// Nothing is left to negotiate once every pair connected, so free the
+	// session now rather than at the end of its TTL. The clients are all idle,
+	// so closing it doesn't abort anyone.
+	if session.isComplete() {
+		logging.Notice(moduleName, "All clients connected, closing session")
+		go session.close(conn, "NATNEG:"+fmt.Sprintf("%08x", session.Cookie))
+	}
+}
+
+// Check if a report can only be a retransmit of the client's previous one.
+// Once a client has reported, it can't report on its next negotiation or on a
+// retry before acknowledging the new connect request; until then the report
+// must be for the attempt it already reported on.
+func (client *NATNEGClient) isRetransmittedReport() bool {
+	return client.Reported && !client.ConnectAck
+}
+
+// Check if every client is idle and connected to every other client. Expects
+// the session mutex to already be locked.
+func (session *NATNEGSession) isComplete() bool {
+	if len(session.Clients) < 2 || len(session.Clients) < int(session.ExpectedClients) {
+		return false
+	}
+
+	for _, client := range session.Clients {
+		if len(session.Clients) < int(getGameClientLimit(client.GameName).Min) {
+			return false
+		}
+		break
+	}
+
+	for id, client := range session.Clients {
+		if client.ConnectingIndex != id {
+			return false
+		}
+
+		for otherID := range session.Clients {
+			if otherID != id && !client.Connected[otherID] {
+				return false
+			}
+		}
+	}
+	return true
 }
@@ -0,0 +1,832 @@
+package natneg
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+	"wwfc/qr2"
+)
+
+type mockPacket struct {
+	data []byte
+	addr net.Addr
+}
+
+type mockConn struct {
+	mutex   sync.Mutex
+	packets []mockPacket
+}
+
+func (c *mockConn) ReadFrom(p []byte) (int, net.Addr, error) { return 0, nil, net.ErrClosed }
+func (c *mockConn) Close() error                             { return nil }
+func (c *mockConn) LocalAddr() net.Addr                      { return &net.UDPAddr{} }
+func (c *mockConn) SetDeadline(t time.Time) error            { return nil }
+func (c *mockConn) SetReadDeadline(t time.Time) error        { return nil }
+func (c *mockConn) SetWriteDeadline(t time.Time) error       { return nil }
+
+func (c *mockConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.packets = append(c.packets, mockPacket{data: append([]byte{}, p...), addr: addr})
+	return len(p), nil
+}
+
+func (c *mockConn) count(command byte) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	count := 0
+	for _, packet := range c.packets {
+		if len(packet.data) > 7 && packet.data[7] == command {
+			count++
+		}
+	}
+	return count
+}
+
+func (c *mockConn) last(command byte) []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for i := len(c.packets) - 1; i >= 0; i-- {
+		if len(c.packets[i].data) > 7 && c.packets[i].data[7] == command {
+			return c.packets[i].data
+		}
+	}
+	return nil
+}
+
+func newTestSession(cookie uint32) (*NATNEGSession, *mockConn) {
+	conn := &mockConn{}
+	natnegConn = conn
+
+	replyWindowMutex.Lock()
+	replyWindows = map[string]*replyWindow{}
+	replyWindowMutex.Unlock()
+
+	straySourceMutex.Lock()
+	straySources = map[string]*straySource{}
+	straySourceMutex.Unlock()
+
+	return &NATNEGSession{
+		Open:    true,
+		Version: 3,
+		Cookie:  cookie,
+		Clients: map[byte]*NATNEGClient{},
+		Done:    make(chan struct{}),
+	}, conn
+}
+
+// Close a test session without reporting it and wait for its connect request
+// exchanges to exit, so they don't outlive the test
+func closeTestSession(session *NATNEGSession) {
+	session.Mutex.Lock()
+	if session.Open {
+		session.Open = false
+		close(session.Done)
+	}
+	session.Mutex.Unlock()
+
+	session.exchanges.Wait()
+}
+
+func makeInitPacket(portType byte, clientIndex byte, useGamePort byte, gameName string) []byte {
+	buffer := []byte{portType, clientIndex, useGamePort, 192, 168, 1, 2}
+	buffer = binary.BigEndian.AppendUint16(buffer, 0x1234)
+	buffer = append(buffer, []byte(gameName)...)
+	return append(buffer, 0x00)
+}
+
+func TestInitAckOnRetransmit(t *testing.T) {
+	session, conn := newTestSession(0x11223344)
+	defer closeTestSession(session)
+
+	addr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}
+	packet := makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii")
+
+	for i := 1; i <= 3; i++ {
+		session.handleInit(conn, addr, packet, "NATNEG:test", 3)
+		if count := conn.count(NNInitReply); count != i {
+			t.Fatalf("expected %d init acks, got %d", i, count)
+		}
+	}
+}
+
+func TestStateUpdateChangesEndpoint(t *testing.T) {
+	session, conn := newTestSession(0x55667788)
+	defer closeTestSession(session)
+
+	addr0 := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}
+	addr1 := &net.UDPAddr{IP: net.IPv4(5, 6, 7, 8), Port: 5001}
+	session.handleInit(conn, addr0, makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+	session.handleInit(conn, addr1, makeInitPacket(PortTypeNATNEG1, 1, 0, "mariokartwii"), "NATNEG:test", 3)
+
+	newAddr := &net.UDPAddr{IP: net.IPv4(5, 6, 7, 9), Port: 6000}
+	update := []byte{PortTypeNATNEG1, 1, 0, 192, 168, 1, 3, 0x12, 0x35}
+	session.handleStateUpdate(conn, newAddr, update, "NATNEG:test", 3)
+
+	client := session.Clients[1]
+	if client.ServerIP != newAddr.String() || client.NegotiateIP != newAddr.String() {
+		t.Fatalf("client endpoints not updated: %s %s", client.ServerIP, client.NegotiateIP)
+	}
+
+	connect := conn.last(NNConnectRequest)
+	if connect == nil {
+		t.Fatal("no connect request sent")
+	}
+	if !net.IP(connect[12:16]).Equal(newAddr.IP) || binary.BigEndian.Uint16(connect[16:18]) != uint16(newAddr.Port) {
+		t.Fatalf("connect request has stale endpoint: %v:%d", net.IP(connect[12:16]), binary.BigEndian.Uint16(connect[16:18]))
+	}
+}
+
+func TestStateUpdateAfterExchangeRetried(t *testing.T) {
+	session, conn := newTestSession(0x55667789)
+	defer closeTestSession(session)
+
+	addr0 := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}
+	addr1 := &net.UDPAddr{IP: net.IPv4(5, 6, 7, 8), Port: 5001}
+
+	session.Mutex.Lock()
+	session.handleInit(conn, addr0, makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+	session.handleInit(conn, addr1, makeInitPacket(PortTypeNATNEG1, 1, 0, "mariokartwii"), "NATNEG:test", 3)
+	session.Clients[0].ConnectAck = true
+	session.Clients[1].ConnectAck = true
+	session.Mutex.Unlock()
+
+	// Let the exchange see the acks and exit
+	time.Sleep(600 * time.Millisecond)
+
+	newAddr := &net.UDPAddr{IP: net.IPv4(5, 6, 7, 9), Port: 6000}
+	update := []byte{PortTypeNATNEG1, 1, 0, 192, 168, 1, 3, 0x12, 0x35}
+	session.Mutex.Lock()
+	session.handleStateUpdate(conn, newAddr, update, "NATNEG:test", 3)
+	session.Mutex.Unlock()
+	sent := conn.count(NNConnectRequest)
+
+	// The first request is lost, so the peer must be sent it again
+	time.Sleep(600 * time.Millisecond)
+	if conn.count(NNConnectRequest) <= sent {
+		t.Fatal("the connect request with the new endpoint was not retried")
+	}
+}
+
+func TestConnectReplyObservedEndpoint(t *testing.T) {
+	session, conn := newTestSession(0x0badf00d)
+	defer closeTestSession(session)
+
+	addr0 := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}
+	addr1 := &net.UDPAddr{IP: net.IPv4(5, 6, 7, 8), Port: 5001}
+	session.handleInit(conn, addr0, makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+	session.handleInit(conn, addr1, makeInitPacket(PortTypeNATNEG1, 1, 0, "mariokartwii"), "NATNEG:test", 3)
+
+	_, missesBefore := GetPredictionStats()
+
+	reply := []byte{PortTypeGamePort, 1, 0, 5, 6, 7, 8, 0x17, 0x72}
+	session.handleConnectReply(conn, addr1, reply, "NATNEG:test", 3)
+
+	client := session.Clients[1]
+	if !client.ConnectAck {
+		t.Fatal("connect reply was not acknowledged")
+	}
+	if client.ObservedIP != "5.6.7.8:6002" {
+		t.Fatalf("observed endpoint not captured: %q", client.ObservedIP)
+	}
+	if _, misses := GetPredictionStats(); misses != missesBefore+1 {
+		t.Fatalf("expected a prediction miss to be counted, got %d -> %d", missesBefore, misses)
+	}
+
+	client.sendConnectRequestPacket(conn, session.Clients[0], session.Version)
+	connect := conn.last(NNConnectRequest)
+	if binary.BigEndian.Uint16(connect[16:18]) != 6002 {
+		t.Fatalf("connect request does not use the observed endpoint: %d", binary.BigEndian.Uint16(connect[16:18]))
+	}
+}
+
+func TestFourClientMesh(t *testing.T) {
+	session, conn := newTestSession(0x44444444)
+	defer closeTestSession(session)
+
+	for i := byte(0); i < 4; i++ {
+		addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, i+1), Port: 5000 + int(i)}
+		session.handleInit(conn, addr, makeInitPacket(PortTypeNATNEG1, i, 0, "mariokartwii"), "NATNEG:test", 3)
+	}
+
+	// Simulate all four clients becoming mapped at the same time
+	for _, client := range session.Clients {
+		client.ConnectingIndex = client.Index
+	}
+	session.sendConnectRequests("NATNEG:test")
+
+	checkPairs := func() {
+		for _, client := range session.Clients {
+			if client.ConnectingIndex != client.Index && session.Clients[client.ConnectingIndex].ConnectingIndex != client.Index {
+				t.Fatalf("client %d is negotiating with %d, which is negotiating with %d", client.Index, client.ConnectingIndex, session.Clients[client.ConnectingIndex].ConnectingIndex)
+			}
+		}
+	}
+	checkPairs()
+
+	// Report success from every negotiating client until nobody is left negotiating
+	for round := 0; round < 10; round++ {
+		negotiating := false
+		for i := byte(0); i < 4; i++ {
+			client := session.Clients[i]
+			if client.ConnectingIndex == client.Index {
+				continue
+			}
+
+			negotiating = true
+			addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, i+1), Port: 5000 + int(i)}
+			// Clients acknowledge the connect request before reporting on it
+			session.handleConnectReply(conn, addr, []byte{PortTypeGamePort, i}, "NATNEG:test", 3)
+			report := []byte{PortTypeNATNEG1, i, 1, NATTypeFullCone, 0, 0, 0, NATMappingConsistent, 0}
+			session.handleReport(conn, addr, report, "NATNEG:test", 3)
+		}
+
+		if !negotiating {
+			break
+		}
+	}
+
+	for i := byte(0); i < 4; i++ {
+		for j := i + 1; j < 4; j++ {
+			if !session.Clients[i].Connected[j] || !session.Clients[j].Connected[i] {
+				t.Errorf("pair %d-%d did not complete", i, j)
+			}
+		}
+	}
+}
+
+func TestFailedReportRetries(t *testing.T) {
+	session, conn := newTestSession(0x0fa11ed0)
+	defer closeTestSession(session)
+
+	addr0 := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}
+	addr1 := &net.UDPAddr{IP: net.IPv4(5, 6, 7, 8), Port: 5001}
+	session.handleInit(conn, addr0, makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+	session.handleInit(conn, addr1, makeInitPacket(PortTypeNATNEG1, 1, 0, "mariokartwii"), "NATNEG:test", 3)
+
+	session.Mutex.Lock()
+	session.Clients[0].ConnectAck = true
+	session.Clients[1].ConnectAck = true
+	session.Mutex.Unlock()
+	time.Sleep(600 * time.Millisecond)
+	before := conn.count(NNConnectRequest)
+
+	report := []byte{PortTypeNATNEG1, 0, NNResultPingTimeout, NATTypeFullCone, 0, 0, 0, NATMappingConsistent, 0}
+	session.Mutex.Lock()
+	session.handleReport(conn, addr0, report, "NATNEG:test", 3)
+	session.Mutex.Unlock()
+
+	client := session.Clients[0]
+	if client.Connected[1] {
+		t.Fatal("failed report marked the pair connected")
+	}
+	if client.ConnectingIndex != 1 {
+		t.Fatalf("client stopped negotiating after a failed report: %d", client.ConnectingIndex)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if conn.count(NNConnectRequest) <= before {
+		t.Fatal("no connect request was retried")
+	}
+}
+
+func TestBothFailedReportsRetryOnce(t *testing.T) {
+	session, conn := newTestSession(0x0fa11ed1)
+	defer closeTestSession(session)
+
+	addrs := [2]*net.UDPAddr{
+		{IP: net.IPv4(1, 2, 3, 4), Port: 5000},
+		{IP: net.IPv4(5, 6, 7, 8), Port: 5001},
+	}
+
+	session.Mutex.Lock()
+	for i := byte(0); i < 2; i++ {
+		session.handleInit(conn, addrs[i], makeInitPacket(PortTypeNATNEG1, i, 0, "mariokartwii"), "NATNEG:test", 3)
+	}
+	session.Clients[0].ConnectAck = true
+	session.Clients[1].ConnectAck = true
+	session.Mutex.Unlock()
+
+	// Let the first exchange see the acks and exit
+	time.Sleep(600 * time.Millisecond)
+	before := conn.count(NNConnectRequest)
+
+	session.Mutex.Lock()
+	for i := byte(0); i < 2; i++ {
+		report := []byte{PortTypeNATNEG1, i, NNResultPingTimeout, NATTypeFullCone, 0, 0, 0, NATMappingConsistent, 0}
+		session.handleReport(conn, addrs[i], report, "NATNEG:test", 3)
+	}
+	attempts := [2]int{session.Clients[0].Attempts[1], session.Clients[1].Attempts[0]}
+	session.Mutex.Unlock()
+
+	if attempts != [2]int{1, 1} {
+		t.Fatalf("expected one attempt counted for the pair, got %v", attempts)
+	}
+
+	// One request to each client, not one from each retry
+	time.Sleep(100 * time.Millisecond)
+	if sent := conn.count(NNConnectRequest) - before; sent != 2 {
+		t.Fatalf("expected 2 connect requests for the retry, got %d", sent)
+	}
+}
+
+func TestGamePortInitFallback(t *testing.T) {
+	session, conn := newTestSession(0x9a3e9047)
+	gamePortFallbackDelay = 50 * time.Millisecond
+	defer func() { gamePortFallbackDelay = 3 * time.Second }()
+	// Runs before the delay is restored, once the fallback timers have fired
+	defer closeTestSession(session)
+
+	addr0 := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}
+	addr1 := &net.UDPAddr{IP: net.IPv4(5, 6, 7, 8), Port: 5001}
+
+	// Only the NATNEG port inits arrive
+	session.Mutex.Lock()
+	session.handleInit(conn, addr0, makeInitPacket(PortTypeNATNEG1, 0, 1, "mariokartwii"), "NATNEG:test", 3)
+	session.handleInit(conn, addr1, makeInitPacket(PortTypeNATNEG1, 1, 1, "mariokartwii"), "NATNEG:test", 3)
+	mapped := session.Clients[0].isMapped() || session.Clients[1].isMapped()
+	session.Mutex.Unlock()
+
+	if mapped || conn.count(NNConnectRequest) != 0 {
+		t.Fatal("client was mapped before the fallback window")
+	}
+
+	// Wait for both fallback timers to fire and the exchange to start
+	deadline := time.Now().Add(time.Second)
+	for {
+		session.Mutex.Lock()
+		mapped = session.Clients[0].isMapped() && session.Clients[1].isMapped()
+		session.Mutex.Unlock()
+
+		if (mapped && conn.count(NNConnectRequest) != 0) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	session.Mutex.Lock()
+	defer session.Mutex.Unlock()
+
+	for index, addr := range []*net.UDPAddr{addr0, addr1} {
+		if session.Clients[byte(index)].ServerIP != addr.String() {
+			t.Fatalf("client %d did not fall back to the NATNEG endpoint: %q", index, session.Clients[byte(index)].ServerIP)
+		}
+	}
+	if conn.count(NNConnectRequest) == 0 {
+		t.Fatal("no connect requests sent after the fallback")
+	}
+}
+
+func TestPreparedSession(t *testing.T) {
+	_, conn := newTestSession(0)
+	const cookie = 0x9e9a9ed0
+	defer func() {
+		mutex.Lock()
+		delete(sessions, cookie)
+		mutex.Unlock()
+	}()
+
+	PrepareSession(cookie, "mariokartwii", 2)
+
+	send := func(clientIndex byte, gameName string, port int) {
+		addr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: port}
+		packet := createPacketHeader(3, NNInitRequest, cookie)
+		packet = append(packet, makeInitPacket(PortTypeNATNEG1, clientIndex, 0, gameName)...)
+		handleConnection(conn, addr, packet)
+	}
+
+	send(0, "mariokartwii", 5000)
+	send(1, "otherGame", 5001)
+	send(2, "mariokartwii", 5002)
+
+	mutex.Lock()
+	session := sessions[cookie]
+	mutex.Unlock()
+
+	if _, exists := session.Clients[0]; !exists {
+		t.Fatal("expected init was rejected")
+	}
+	if _, exists := session.Clients[1]; exists {
+		t.Fatal("init for another game was accepted")
+	}
+	if _, exists := session.Clients[2]; exists {
+		t.Fatal("init with an unexpected client index was accepted")
+	}
+	if session.Version != 3 || conn.count(NNInitReply) != 1 {
+		t.Fatalf("expected one init ack for version 3, got %d for version %d", conn.count(NNInitReply), session.Version)
+	}
+}
+
+func TestConnectRequestSourceSocket(t *testing.T) {
+	session, conn := newTestSession(0x50c4e700)
+	defer closeTestSession(session)
+
+	probeConn := &mockConn{}
+	probeConns[PortTypeNATNEG2] = probeConn
+	defer delete(probeConns, PortTypeNATNEG2)
+
+	addr0 := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}
+	addr1 := &net.UDPAddr{IP: net.IPv4(5, 6, 7, 8), Port: 5001}
+
+	session.Mutex.Lock()
+	session.handleInit(probeConn, addr0, makeInitPacket(PortTypeNATNEG2, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+	session.handleInit(conn, addr1, makeInitPacket(PortTypeNATNEG1, 1, 0, "mariokartwii"), "NATNEG:test", 3)
+	session.Mutex.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+
+	sentTo := func(c *mockConn, addr net.Addr) bool {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		for _, packet := range c.packets {
+			if packet.data[7] == NNConnectRequest && packet.addr.String() == addr.String() {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !sentTo(probeConn, addr0) || sentTo(conn, addr0) {
+		t.Fatal("connect request for the NATNEG2 client was not sent from the NATNEG2 socket")
+	}
+	if !sentTo(conn, addr1) || sentTo(probeConn, addr1) {
+		t.Fatal("connect request for the NATNEG1 client was not sent from the NATNEG1 socket")
+	}
+}
+
+func TestReportFromIdleClientIgnored(t *testing.T) {
+	session, conn := newTestSession(0x99aabbcc)
+	defer closeTestSession(session)
+
+	addr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}
+	session.handleInit(conn, addr, makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+
+	report := []byte{PortTypeNATNEG1, 0, 1, NATTypeFullCone, 0, 0, 0, NATMappingConsistent, 0}
+	session.handleReport(conn, addr, report, "NATNEG:test", 3)
+
+	client := session.Clients[0]
+	if len(client.Connected) != 0 {
+		t.Fatalf("report from a client with no negotiation should be ignored: %v", client.Connected)
+	}
+	if conn.count(NNReportReply) != 1 {
+		t.Fatal("report should still be acknowledged")
+	}
+}
+
+func TestRegionLockedGameNotPaired(t *testing.T) {
+	session, conn := newTestSession(0x12121212)
+	defer closeTestSession(session)
+
+	regionLockedGames["mariokartwii"] = true
+	defer delete(regionLockedGames, "mariokartwii")
+
+	addr0 := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}
+	addr1 := &net.UDPAddr{IP: net.IPv4(5, 6, 7, 8), Port: 5001}
+	getGameCode = func(addr string) (string, bool) {
+		if addr == addr0.String() {
+			return "RMCJ", true
+		}
+		return "RMCE", true
+	}
+	defer func() { getGameCode = qr2.GetGameCode }()
+
+	session.handleInit(conn, addr0, makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+	session.handleInit(conn, addr1, makeInitPacket(PortTypeNATNEG1, 1, 0, "mariokartwii"), "NATNEG:test", 3)
+
+	time.Sleep(50 * time.Millisecond)
+	if count := conn.count(NNConnectRequest); count != 0 {
+		t.Fatalf("expected no connect requests, got %d", count)
+	}
+}
+
+func TestDeferredInitAck(t *testing.T) {
+	for _, deferred := range []bool{false, true} {
+		session, conn := newTestSession(0x34343434)
+
+		if deferred {
+			deferInitAckGames["mariokartwii"] = true
+		}
+
+		addr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}
+		gameAddr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5001}
+
+		// Not mapped until the game port init arrives
+		session.handleInit(conn, addr, makeInitPacket(PortTypeNATNEG1, 0, 1, "mariokartwii"), "NATNEG:test", 3)
+		expected := 1
+		if deferred {
+			expected = 0
+		}
+		if count := conn.count(NNInitReply); count != expected {
+			t.Errorf("deferred=%v: expected %d acks before mapping, got %d", deferred, expected, count)
+		}
+
+		session.handleInit(conn, gameAddr, makeInitPacket(PortTypeGamePort, 0, 1, "mariokartwii"), "NATNEG:test", 3)
+		if count := conn.count(NNInitReply); count != expected+1 {
+			t.Errorf("deferred=%v: expected %d acks after mapping, got %d", deferred, expected+1, count)
+		}
+
+		delete(deferInitAckGames, "mariokartwii")
+		closeTestSession(session)
+	}
+}
+
+func TestStopServerAbortsNegotiations(t *testing.T) {
+	session, conn := newTestSession(0x56565656)
+
+	addr0 := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}
+	addr1 := &net.UDPAddr{IP: net.IPv4(5, 6, 7, 8), Port: 5001}
+	session.handleInit(conn, addr0, makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+	session.handleInit(conn, addr1, makeInitPacket(PortTypeNATNEG1, 1, 0, "mariokartwii"), "NATNEG:test", 3)
+
+	mutex.Lock()
+	sessions[session.Cookie] = session
+	mutex.Unlock()
+
+	StopServer()
+
+	if count := conn.count(NNReportReply); count != 2 {
+		t.Fatalf("expected 2 abort packets, got %d", count)
+	}
+
+	mutex.Lock()
+	_, exists := sessions[session.Cookie]
+	mutex.Unlock()
+	if exists || session.Open {
+		t.Fatal("session was not closed")
+	}
+}
+
+type timeoutConn struct {
+	mockConn
+	deadline time.Time
+	closed   bool
+}
+
+func (c *timeoutConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func (c *timeoutConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	time.Sleep(time.Until(c.deadline))
+	return 0, nil, os.ErrDeadlineExceeded
+}
+
+func (c *timeoutConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestServeStopsOnFlag(t *testing.T) {
+	readTimeout = 20 * time.Millisecond
+	defer func() { readTimeout = 500 * time.Millisecond }()
+	stopping.Store(false)
+	defer stopping.Store(false)
+
+	conn := &timeoutConn{}
+	done := make(chan struct{})
+	go func() {
+		serve(conn)
+		close(done)
+	}()
+
+	// Timeouts alone must not end the loop
+	time.Sleep(60 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("read loop exited on a read timeout")
+	default:
+	}
+
+	stopping.Store(true)
+	select {
+	case <-done:
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("read loop did not exit after the stop flag was set")
+	}
+
+	if !conn.closed {
+		t.Fatal("connection was not closed")
+	}
+}
+
+func TestGameNameNormalized(t *testing.T) {
+	session, conn := newTestSession(0x6a3e0001)
+	defer closeTestSession(session)
+
+	addr0 := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}
+	addr1 := &net.UDPAddr{IP: net.IPv4(5, 6, 7, 8), Port: 5001}
+
+	session.Mutex.Lock()
+	session.handleInit(conn, addr0, makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+	session.handleInit(conn, addr1, makeInitPacket(PortTypeNATNEG1, 1, 0, "MarioKartWii "), "NATNEG:test", 3)
+	session.Mutex.Unlock()
+
+	client, exists := session.Clients[1]
+	if !exists {
+		t.Fatal("init with a differently cased game name was rejected")
+	}
+	if client.GameName != session.Clients[0].GameName {
+		t.Fatalf("game names were not normalized: %q != %q", client.GameName, session.Clients[0].GameName)
+	}
+
+	// A blank game name is not valid
+	session.Mutex.Lock()
+	session.handleInit(conn, addr1, makeInitPacket(PortTypeNATNEG1, 2, 0, "  "), "NATNEG:test", 3)
+	session.Mutex.Unlock()
+
+	if _, exists := session.Clients[2]; exists {
+		t.Fatal("init with a blank game name was accepted")
+	}
+}
+
+func TestCloseStopsConnectRequests(t *testing.T) {
+	session, conn := newTestSession(0x0c105ed0)
+	defer closeTestSession(session)
+
+	addr0 := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}
+	addr1 := &net.UDPAddr{IP: net.IPv4(5, 6, 7, 8), Port: 5001}
+	session.Mutex.Lock()
+	session.handleInit(conn, addr0, makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+	session.handleInit(conn, addr1, makeInitPacket(PortTypeNATNEG1, 1, 0, "mariokartwii"), "NATNEG:test", 3)
+	session.Mutex.Unlock()
+
+	// Let the exchange send its first requests and go to sleep
+	time.Sleep(50 * time.Millisecond)
+	if conn.count(NNConnectRequest) == 0 {
+		t.Fatal("no connect requests were sent")
+	}
+
+	// Closing signals Done, which must wake the exchange well before its next
+	// retry is due
+	session.close(conn, "NATNEG:test")
+
+	exited := make(chan struct{})
+	go func() {
+		session.exchanges.Wait()
+		close(exited)
+	}()
+
+	select {
+	case <-exited:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("connect request goroutine did not exit after the session was closed")
+	}
+
+	// Nothing is left to send once the exchange has exited
+	sent := conn.count(NNConnectRequest)
+	session.Mutex.Lock()
+	open := session.Open
+	session.Mutex.Unlock()
+
+	if open {
+		t.Fatal("session is still open after being closed")
+	}
+	if count := conn.count(NNConnectRequest); count != sent {
+		t.Fatalf("%d connect requests were sent after the session was closed", count-sent)
+	}
+}
+
+func TestProbePortEndpointsRetained(t *testing.T) {
+	session, conn := newTestSession(0x3e9d0001)
+	defer closeTestSession(session)
+
+	addrs := map[byte]*net.UDPAddr{
+		PortTypeNATNEG1: {IP: net.IPv4(1, 2, 3, 4), Port: 5000},
+		PortTypeNATNEG2: {IP: net.IPv4(1, 2, 3, 4), Port: 5001},
+		PortTypeNATNEG3: {IP: net.IPv4(1, 2, 3, 4), Port: 5002},
+	}
+
+	session.Mutex.Lock()
+	for _, portType := range []byte{PortTypeNATNEG2, PortTypeNATNEG1, PortTypeNATNEG3} {
+		session.handleInit(conn, addrs[portType], makeInitPacket(portType, 0, 1, "mariokartwii"), "NATNEG:test", 3)
+	}
+	session.Mutex.Unlock()
+
+	client := session.Clients[0]
+	for portType, addr := range addrs {
+		if client.Endpoints[portType] != addr.String() {
+			t.Errorf("port type %d: expected endpoint %s, got %s", portType, addr, client.Endpoints[portType])
+		}
+	}
+
+	if client.NegotiateIP != addrs[PortTypeNATNEG1].String() || client.NegotiatePortType != PortTypeNATNEG1 {
+		t.Fatalf("expected the NATNEG1 endpoint to be used for negotiation, got %s (%d)", client.NegotiateIP, client.NegotiatePortType)
+	}
+}
+
+func TestZeroCookieRejected(t *testing.T) {
+	_, conn := newTestSession(0)
+
+	addr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}
+	packet := append(createPacketHeader(3, NNInitRequest, 0), makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii")...)
+	handleConnection(conn, addr, packet)
+
+	mutex.Lock()
+	_, exists := sessions[0]
+	mutex.Unlock()
+
+	if exists {
+		t.Fatal("a session was created for a zero cookie")
+	}
+	if count := conn.count(NNInitReply); count != 0 {
+		t.Fatalf("sent %d init acks for a zero cookie", count)
+	}
+}
+
+func TestLateReportDoesNotCreateSession(t *testing.T) {
+	_, conn := newTestSession(0x7e7e7e7e)
+
+	addr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}
+	report := []byte{PortTypeGamePort, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	report = append(report, []byte("mariokartwii")...)
+	handleConnection(conn, addr, append(createPacketHeader(3, NNReportRequest, 0x7e7e7e7e), report...))
+
+	mutex.Lock()
+	_, exists := sessions[0x7e7e7e7e]
+	mutex.Unlock()
+
+	if exists {
+		t.Fatal("a report for an unknown cookie created a session")
+	}
+	if len(conn.packets) != 0 {
+		t.Fatalf("replied to a report for an unknown cookie: %d packets", len(conn.packets))
+	}
+}
+
+func TestCompletedSessionClosedEarly(t *testing.T) {
+	_, conn := newTestSession(0)
+
+	mutex.Lock()
+	session := createSession(conn, 0x0c105ed0, 3, "NATNEG:test")
+	mutex.Unlock()
+
+	addrs := [2]*net.UDPAddr{
+		{IP: net.IPv4(1, 2, 3, 4), Port: 5000},
+		{IP: net.IPv4(5, 6, 7, 8), Port: 5001},
+	}
+
+	session.Mutex.Lock()
+	for i := byte(0); i < 2; i++ {
+		session.handleInit(conn, addrs[i], makeInitPacket(PortTypeNATNEG1, i, 0, "mariokartwii"), "NATNEG:test", 3)
+	}
+	for i := byte(0); i < 2; i++ {
+		report := []byte{PortTypeNATNEG1, i, NNResultSuccess, NATTypeFullCone, 0, 0, 0, NATMappingConsistent, 0}
+		session.handleReport(conn, addrs[i], report, "NATNEG:test", 3)
+	}
+	session.Mutex.Unlock()
+
+	select {
+	case <-session.Done:
+	case <-time.After(time.Second):
+		t.Fatal("completed session was not closed")
+	}
+
+	mutex.Lock()
+	_, exists := sessions[0x0c105ed0]
+	mutex.Unlock()
+	if exists {
+		t.Fatal("completed session is still registered")
+	}
+
+	// Only the two report acks, no aborts
+	if count := conn.count(NNReportReply); count != 2 {
+		t.Fatalf("expected 2 report replies, got %d", count)
+	}
+}
+
+type packetSourceConn struct {
+	mockConn
+	packet []byte
+	addr   net.Addr
+}
+
+func (c *packetSourceConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	return copy(p, c.packet), c.addr, nil
+}
+
+func TestReadPacketReusesBuffers(t *testing.T) {
+	conn := &packetSourceConn{
+		packet: createPacketHeader(3, NNInitRequest, 0x12345678),
+		addr:   &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000},
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		buffer, size, _, err := readPacket(conn)
+		if err != nil || size != len(conn.packet) {
+			t.Fatalf("read failed: %v (%d bytes)", err, size)
+		}
+		readBufferPool.Put(buffer)
+	})
+
+	if allocs >= 1 {
+		t.Fatalf("expected no allocations per packet, got %.1f", allocs)
+	}
+}
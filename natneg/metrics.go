@@ -0,0 +1,73 @@
+package natneg
+
+import (
+	"sync"
+)
+
+// A pair of NAT types, ordered so that A <= B
+type NATTypePair struct {
+	A byte
+	B byte
+}
+
+type NATTypeOutcome struct {
+	Successes uint64
+	Failures  uint64
+}
+
+var (
+	natTypeNames = map[byte]string{
+		NATTypeNoNat:              "no-nat",
+		NATTypeFirewallOnly:       "firewall-only",
+		NATTypeFullCone:           "full-cone",
+		NATTypeRestrictedCone:     "restricted-cone",
+		NATTypePortRestrictedCone: "port-restricted-cone",
+		NATTypeSymmetric:          "symmetric",
+		NATTypeUnknown:            "unknown",
+	}
+
+	natTypeOutcomes      = map[NATTypePair]*NATTypeOutcome{}
+	natTypeOutcomesMutex = sync.Mutex{}
+)
+
+func (pair NATTypePair) String() string {
+	return natTypeNames[pair.A] + "<->" + natTypeNames[pair.B]
+}
+
+func makeNATTypePair(a byte, b byte) NATTypePair {
+	if a > b {
+		a, b = b, a
+	}
+	return NATTypePair{A: a, B: b}
+}
+
+func recordNATTypeOutcome(a byte, b byte, success bool) {
+	natTypeOutcomesMutex.Lock()
+	defer natTypeOutcomesMutex.Unlock()
+
+	pair := makeNATTypePair(a, b)
+	outcome, exists := natTypeOutcomes[pair]
+	if !exists {
+		outcome = &NATTypeOutcome{}
+		natTypeOutcomes[pair] = outcome
+	}
+
+	if success {
+		outcome.Successes++
+	} else {
+		outcome.Failures++
+	}
+}
+
+// Get a copy of the negotiation outcomes for each NAT type pair. A negotiation
+// only counts as a success if both clients reported success.
+func GetNATTypeOutcomes() map[NATTypePair]NATTypeOutcome {
+	natTypeOutcomesMutex.Lock()
+	defer natTypeOutcomesMutex.Unlock()
+
+	outcomes := map[NATTypePair]NATTypeOutcome{}
+	for pair, outcome := range natTypeOutcomes {
+		outcomes[pair] = *outcome
+	}
+	return outcomes
+}
@@ -0,0 +1,56 @@
+package natneg
+
+import (
+	"net"
+	"testing"
+	"time"
+	"wwfc/metrics"
+)
+
+func TestNegotiationMetrics(t *testing.T) {
+	sink := metrics.NewMemorySink()
+	previousSink := metrics.GetSink()
+	metrics.SetSink(sink)
+	defer metrics.SetSink(previousSink)
+
+	_, conn := newTestSession(0)
+
+	mutex.Lock()
+	session := createSession(conn, 0x3e781c50, 3, "NATNEG:test")
+	mutex.Unlock()
+
+	if sink.GetGauge("natneg_sessions") < 1 {
+		t.Error("session gauge was not set")
+	}
+
+	addrs := [2]*net.UDPAddr{
+		{IP: net.IPv4(1, 2, 3, 4), Port: 5000},
+		{IP: net.IPv4(5, 6, 7, 8), Port: 5001},
+	}
+
+	session.Mutex.Lock()
+	for i := byte(0); i < 2; i++ {
+		session.handleInit(conn, addrs[i], makeInitPacket(PortTypeNATNEG1, i, 0, "mariokartwii"), "NATNEG:test", 3)
+	}
+	for i := byte(0); i < 2; i++ {
+		report := []byte{PortTypeNATNEG1, i, NNResultSuccess, NATTypeFullCone, 0, 0, 0, NATMappingConsistent, 0}
+		session.handleReport(conn, addrs[i], report, "NATNEG:test", 3)
+	}
+	session.Mutex.Unlock()
+
+	select {
+	case <-session.Done:
+	case <-time.After(time.Second):
+		t.Fatal("completed session was not closed")
+	}
+
+	if sink.GetCounter("natneg_sessions_total") != 1 {
+		t.Error("session was not counted")
+	}
+	if sink.GetCounter("natneg_negotiations_total", "result", "success") != 1 {
+		t.Error("negotiation was not counted")
+	}
+	if sink.GetHistogram("natneg_negotiation_seconds", "result", "success").Count == 0 {
+		t.Error("negotiation duration was not observed")
+	}
+}
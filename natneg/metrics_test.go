@@ -0,0 +1,65 @@
+package natneg
+
+import (
+	"net"
+	"testing"
+)
+
+func negotiatePair(t *testing.T, cookie uint32, natTypes [2]byte, results [2]byte) {
+	session, conn := newTestSession(cookie)
+	defer closeTestSession(session)
+
+	addrs := [2]*net.UDPAddr{
+		{IP: net.IPv4(1, 2, 3, 4), Port: 5000},
+		{IP: net.IPv4(5, 6, 7, 8), Port: 5001},
+	}
+
+	session.Mutex.Lock()
+	defer session.Mutex.Unlock()
+
+	for i := byte(0); i < 2; i++ {
+		session.handleInit(conn, addrs[i], makeInitPacket(PortTypeNATNEG1, i, 0, "mariokartwii"), "NATNEG:test", 3)
+	}
+	for i := byte(0); i < 2; i++ {
+		if session.Clients[i].ConnectingIndex != 1-i {
+			t.Fatalf("client %d is not negotiating", i)
+		}
+		// Fail straight away instead of retrying
+		session.Clients[i].Attempts[1-i] = maxConnectAttempts - 1
+		// Clients acknowledge the connect request before reporting on it
+		session.Clients[i].ConnectAck = true
+	}
+
+	for i := byte(0); i < 2; i++ {
+		report := []byte{PortTypeNATNEG1, i, results[i], natTypes[i], 0, 0, 0, NATMappingConsistent, 0}
+		session.handleReport(conn, addrs[i], report, "NATNEG:test", 3)
+	}
+}
+
+func TestNATTypeOutcomes(t *testing.T) {
+	before := GetNATTypeOutcomes()
+
+	negotiatePair(t, 0x0a7a0001, [2]byte{NATTypeFullCone, NATTypeSymmetric}, [2]byte{NNResultSuccess, NNResultSuccess})
+	negotiatePair(t, 0x0a7a0002, [2]byte{NATTypeSymmetric, NATTypeFullCone}, [2]byte{NNResultSuccess, NNResultPingTimeout})
+	negotiatePair(t, 0x0a7a0003, [2]byte{NATTypeSymmetric, NATTypeSymmetric}, [2]byte{NNResultPingTimeout, NNResultPingTimeout})
+
+	after := GetNATTypeOutcomes()
+
+	tests := []struct {
+		pair      NATTypePair
+		successes uint64
+		failures  uint64
+	}{
+		{makeNATTypePair(NATTypeFullCone, NATTypeSymmetric), 1, 1},
+		{makeNATTypePair(NATTypeSymmetric, NATTypeSymmetric), 0, 1},
+		{makeNATTypePair(NATTypeFullCone, NATTypeFullCone), 0, 0},
+	}
+
+	for _, test := range tests {
+		successes := after[test.pair].Successes - before[test.pair].Successes
+		failures := after[test.pair].Failures - before[test.pair].Failures
+		if successes != test.successes || failures != test.failures {
+			t.Errorf("%s: expected %d/%d successes/failures, got %d/%d", test.pair, test.successes, test.failures, successes, failures)
+		}
+	}
+}
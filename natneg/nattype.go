@@ -0,0 +1,143 @@
+package natneg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Public ports seen by the address check and natify requests from one address
+type probeObservation struct {
+	LocalIP string
+	Ports   map[byte]uint16
+	Updated time.Time
+}
+
+const (
+	probeObservationTTL = 10 * time.Minute
+	// The oldest observation is dropped to make room past this many addresses
+	maxProbeObservations = 4096
+)
+
+var (
+	probeObservations      = map[string]*probeObservation{}
+	probeObservationsMutex = sync.Mutex{}
+	lastProbePrune         time.Time
+)
+
+// Record the public endpoint a probe request arrived from, along with the
+// local address the client reported in it
+func ObserveProbe(addr *net.UDPAddr, portType byte, localIP string) {
+	if addr.IP.To4() == nil || portType > PortTypeNATNEG3 {
+		return
+	}
+
+	probeObservationsMutex.Lock()
+	defer probeObservationsMutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(lastProbePrune) > time.Minute {
+		for ip, observation := range probeObservations {
+			if now.Sub(observation.Updated) > probeObservationTTL {
+				delete(probeObservations, ip)
+			}
+		}
+		lastProbePrune = now
+	}
+
+	ip := addr.IP.To4().String()
+	observation, exists := probeObservations[ip]
+	if !exists && len(probeObservations) >= maxProbeObservations {
+		evictOldestProbe()
+	}
+	if !exists || now.Sub(observation.Updated) > probeObservationTTL {
+		observation = &probeObservation{Ports: map[byte]uint16{}}
+		probeObservations[ip] = observation
+	}
+
+	observation.LocalIP = localIP
+	observation.Ports[portType] = uint16(addr.Port)
+	observation.Updated = now
+}
+
+// Must be called with probeObservationsMutex held
+func evictOldestProbe() {
+	oldestIP := ""
+	var oldest time.Time
+	for ip, observation := range probeObservations {
+		if oldestIP == "" || observation.Updated.Before(oldest) {
+			oldestIP = ip
+			oldest = observation.Updated
+		}
+	}
+	delete(probeObservations, oldestIP)
+}
+
+func observeProbePacket(addr net.Addr, buffer []byte) {
+	// xx          - Port type
+	// xx          - Client index
+	// xx          - Use game port
+	// xx xx xx xx - Local IP
+	// xx xx       - Local port
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok || len(buffer) < 9 {
+		return
+	}
+
+	localIP := fmt.Sprintf("%d.%d.%d.%d:%d", buffer[3], buffer[4], buffer[5], buffer[6], binary.BigEndian.Uint16(buffer[7:9]))
+	ObserveProbe(udpAddr, buffer[0], localIP)
+}
+
+// Get the NAT type of a public IP from the probes it sent recently. Only the
+// mapping behaviour can be seen this way, not the filtering, so a consistent
+// mapping is reported as a port restricted cone, the strictest cone type.
+func GetObservedNATType(ip string) byte {
+	probeObservationsMutex.Lock()
+	defer probeObservationsMutex.Unlock()
+
+	observation, exists := probeObservations[ip]
+	if !exists || time.Since(observation.Updated) > probeObservationTTL {
+		return NATTypeUnknown
+	}
+
+	if len(observation.Ports) == 0 {
+		return NATTypeUnknown
+	}
+
+	noNat := true
+	for _, port := range observation.Ports {
+		if observation.LocalIP != fmt.Sprintf("%s:%d", ip, port) {
+			noNat = false
+			break
+		}
+	}
+	if noNat {
+		return NATTypeNoNat
+	}
+
+	// The mapping can only be compared with probes to at least two ports
+	if len(observation.Ports) < 2 {
+		return NATTypeUnknown
+	}
+
+	var firstPort uint16
+	for _, port := range observation.Ports {
+		if firstPort == 0 {
+			firstPort = port
+		} else if port != firstPort {
+			return NATTypeSymmetric
+		}
+	}
+
+	return NATTypePortRestrictedCone
+}
+
+func GetNATTypeName(natType byte) string {
+	if name, exists := natTypeNames[natType]; exists {
+		return name
+	}
+
+	return natTypeNames[NATTypeUnknown]
+}
@@ -0,0 +1,68 @@
+package natneg
+
+import (
+	"net"
+	"testing"
+)
+
+func TestObservedNATTypeFromAddressChecks(t *testing.T) {
+	conn := &mockConn{}
+	replyWindowMutex.Lock()
+	replyWindows = map[string]*replyWindow{}
+	replyWindowMutex.Unlock()
+
+	probeObservationsMutex.Lock()
+	probeObservations = map[string]*probeObservation{}
+	probeObservationsMutex.Unlock()
+
+	addressCheck := func(addr *net.UDPAddr, portType byte, localIP net.IP, localPort uint16) {
+		request := createPacketHeader(3, NNAddressCheckRequest, 0)
+		request = append(request, portType, 0, 0)
+		request = append(request, localIP.To4()...)
+		request = append(request, byte(localPort>>8), byte(localPort))
+		handleConnection(conn, addr, request)
+	}
+
+	// Public and local address are the same
+	open := net.IPv4(203, 0, 113, 20)
+	addressCheck(&net.UDPAddr{IP: open, Port: 6000}, PortTypeNATNEG1, open, 6000)
+	if natType := GetObservedNATType(open.String()); natType != NATTypeNoNat {
+		t.Errorf("expected no NAT, got %s", GetNATTypeName(natType))
+	}
+
+	// Different public ports for each server port
+	symmetric := net.IPv4(203, 0, 113, 21)
+	addressCheck(&net.UDPAddr{IP: symmetric, Port: 6000}, PortTypeNATNEG1, net.IPv4(192, 168, 1, 2), 5000)
+	if natType := GetObservedNATType(symmetric.String()); natType != NATTypeUnknown {
+		t.Errorf("a single probe should not be enough to tell the NAT type, got %s", GetNATTypeName(natType))
+	}
+	addressCheck(&net.UDPAddr{IP: symmetric, Port: 6001}, PortTypeNATNEG2, net.IPv4(192, 168, 1, 2), 5000)
+	if natType := GetObservedNATType(symmetric.String()); natType != NATTypeSymmetric {
+		t.Errorf("expected a symmetric NAT, got %s", GetNATTypeName(natType))
+	}
+}
+
+func TestProbeObservationsBounded(t *testing.T) {
+	probeObservationsMutex.Lock()
+	probeObservations = map[string]*probeObservation{}
+	probeObservationsMutex.Unlock()
+
+	probeIP := func(i int) net.IP {
+		return net.IPv4(10, byte(i>>16), byte(i>>8), byte(i))
+	}
+	for i := 0; i <= maxProbeObservations; i++ {
+		ObserveProbe(&net.UDPAddr{IP: probeIP(i), Port: 6000}, PortTypeNATNEG1, "192.168.1.2:5000")
+	}
+
+	probeObservationsMutex.Lock()
+	count := len(probeObservations)
+	_, newestKept := probeObservations[probeIP(maxProbeObservations).String()]
+	probeObservationsMutex.Unlock()
+
+	if count != maxProbeObservations {
+		t.Fatalf("expected %d observations, got %d", maxProbeObservations, count)
+	}
+	if !newestKept {
+		t.Fatal("the newest observation was evicted")
+	}
+}
@@ -0,0 +1,53 @@
+package natneg
+
+import (
+	"fmt"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// Immediately send a fresh connect request to both clients of every pair in
+// the session that is mapped but not yet connected, rather than waiting for
+// the next retry. Meant for ops to unstick a pair after a network hiccup
+// dropped the earlier packets. Returns the number of pairs nudged.
+func NudgeSession(cookie uint32) int {
+	mutex.RLock()
+	session, exists := sessions[cookie]
+	mutex.RUnlock()
+
+	if !exists {
+		return 0
+	}
+
+	session.Mutex.Lock()
+	defer session.Mutex.Unlock()
+
+	if !session.Open {
+		return 0
+	}
+
+	moduleName := "NATNEG:" + fmt.Sprintf("%08x", session.Cookie)
+
+	nudged := 0
+	for id, client := range session.Clients {
+		peer, exists := session.Clients[client.ConnectingIndex]
+		if !exists || client.ConnectingIndex == id || peer.ConnectingIndex != id || id > peer.Index {
+			continue
+		}
+
+		if !client.isMapped() || !peer.isMapped() || (client.Connected[peer.Index] && peer.Connected[id]) {
+			continue
+		}
+
+		logging.Notice(moduleName, "Nudging connect requests between", aurora.BrightCyan(id), "and", aurora.BrightCyan(peer.Index))
+		client.sendConnectRequestPacket(connForPortType(peer.NegotiatePortType), peer, session.Version)
+		peer.sendConnectRequestPacket(connForPortType(client.NegotiatePortType), client, session.Version)
+		nudged++
+	}
+
+	// Pair up any mapped clients that are still waiting for a peer
+	session.sendConnectRequests(moduleName)
+
+	return nudged
+}
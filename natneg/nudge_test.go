@@ -0,0 +1,67 @@
+package natneg
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNudgeSession(t *testing.T) {
+	session, conn := newTestSession(0x4e0d6e00)
+	defer func() {
+		mutex.Lock()
+		delete(sessions, session.Cookie)
+		mutex.Unlock()
+		closeTestSession(session)
+	}()
+
+	mutex.Lock()
+	sessions[session.Cookie] = session
+	mutex.Unlock()
+
+	addrs := [2]*net.UDPAddr{
+		{IP: net.IPv4(1, 2, 3, 4), Port: 5000},
+		{IP: net.IPv4(5, 6, 7, 8), Port: 5001},
+	}
+
+	// Pair the clients, then stop the regular retries by acknowledging
+	// before the exchange gets to send anything
+	session.Mutex.Lock()
+	for i := byte(0); i < 2; i++ {
+		session.handleInit(conn, addrs[i], makeInitPacket(PortTypeNATNEG1, i, 0, "mariokartwii"), "NATNEG:test", 3)
+	}
+	for i := byte(0); i < 2; i++ {
+		if session.Clients[i].ConnectingIndex != 1-i {
+			t.Fatalf("client %d is not negotiating", i)
+		}
+		session.Clients[i].ConnectAck = true
+	}
+	session.Mutex.Unlock()
+	session.exchanges.Wait()
+
+	if count := conn.count(NNConnectRequest); count != 0 {
+		t.Fatalf("expected no connect requests before the nudge, got %d", count)
+	}
+
+	if nudged := NudgeSession(session.Cookie); nudged != 1 {
+		t.Fatalf("expected 1 pair to be nudged, got %d", nudged)
+	}
+	if count := conn.count(NNConnectRequest); count != 2 {
+		t.Fatalf("expected a connect request to each client, got %d", count)
+	}
+
+	// Connected pairs and unknown sessions are left alone
+	session.Mutex.Lock()
+	session.Clients[0].Connected[1] = true
+	session.Clients[1].Connected[0] = true
+	session.Mutex.Unlock()
+
+	if nudged := NudgeSession(session.Cookie); nudged != 0 {
+		t.Fatalf("expected a connected pair not to be nudged, got %d", nudged)
+	}
+	if nudged := NudgeSession(0x4e0d6e01); nudged != 0 {
+		t.Fatalf("expected an unknown session not to be nudged, got %d", nudged)
+	}
+	if count := conn.count(NNConnectRequest); count != 2 {
+		t.Fatalf("expected no further connect requests, got %d", count)
+	}
+}
@@ -0,0 +1,125 @@
+package natneg
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"sync"
+)
+
+// An anonymized summary of one client's negotiation, kept after its session
+// is deleted
+type NatObservation struct {
+	IPHash        string
+	NATType       string
+	MappingScheme string
+	Outcome       string
+}
+
+const maxNatObservations = 1024
+
+var (
+	mappingSchemeNames = map[byte]string{
+		NATMappingUnknown:           "unknown",
+		NATMappingSamePrivatePublic: "same-private-public",
+		NATMappingConsistent:        "consistent",
+		NATMappingIncremental:       "incremental",
+		NATMappingMixed:             "mixed",
+	}
+
+	// Ring buffer of the most recent observations
+	natObservations      = make([]NatObservation, 0, maxNatObservations)
+	natObservationNext   int
+	natObservationsMutex = sync.Mutex{}
+
+	// Random per-process salt so the hashes can't be reversed by hashing every
+	// IPv4 address
+	natObservationSalt = makeObservationSalt()
+)
+
+func makeObservationSalt() []byte {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	return salt
+}
+
+func hashObservationIP(ip string) string {
+	hash := sha256.New()
+	hash.Write(natObservationSalt)
+	hash.Write([]byte(ip))
+	return hex.EncodeToString(hash.Sum(nil)[:8])
+}
+
+func getMappingSchemeName(mappingScheme byte) string {
+	if name, exists := mappingSchemeNames[mappingScheme]; exists {
+		return name
+	}
+
+	return mappingSchemeNames[NATMappingUnknown]
+}
+
+// Get the outcome of every negotiation the client finished: success only if
+// they all succeeded
+func (client *NATNEGClient) outcomeName() string {
+	if len(client.Outcomes) == 0 {
+		return "incomplete"
+	}
+
+	for _, success := range client.Outcomes {
+		if !success {
+			return "failure"
+		}
+	}
+	return "success"
+}
+
+// Keep a summary of each client in a session that is being deleted. Expects
+// the session mutex to already be locked.
+func (session *NATNEGSession) recordObservations() {
+	natObservationsMutex.Lock()
+	defer natObservationsMutex.Unlock()
+
+	for _, client := range session.Clients {
+		address := client.NegotiateIP
+		if address == "" {
+			address = client.ServerIP
+		}
+		if address == "" {
+			continue
+		}
+
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			address = host
+		}
+
+		observation := NatObservation{
+			IPHash:        hashObservationIP(address),
+			NATType:       GetNATTypeName(client.NATType),
+			MappingScheme: getMappingSchemeName(client.MappingScheme),
+			Outcome:       client.outcomeName(),
+		}
+
+		if len(natObservations) < maxNatObservations {
+			natObservations = append(natObservations, observation)
+		} else {
+			natObservations[natObservationNext] = observation
+		}
+		natObservationNext = (natObservationNext + 1) % maxNatObservations
+	}
+}
+
+// Export the retained observations of completed or expired sessions, oldest
+// first
+func ExportNatObservations() []NatObservation {
+	natObservationsMutex.Lock()
+	defer natObservationsMutex.Unlock()
+
+	if len(natObservations) < maxNatObservations {
+		return append([]NatObservation{}, natObservations...)
+	}
+
+	observations := make([]NatObservation, 0, maxNatObservations)
+	observations = append(observations, natObservations[natObservationNext:]...)
+	return append(observations, natObservations[:natObservationNext]...)
+}
@@ -0,0 +1,63 @@
+package natneg
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestExportNatObservations(t *testing.T) {
+	session, conn := newTestSession(0x0b5e0001)
+
+	addrs := [2]*net.UDPAddr{
+		{IP: net.IPv4(1, 2, 3, 4), Port: 5000},
+		{IP: net.IPv4(5, 6, 7, 8), Port: 5001},
+	}
+
+	// Taken first, since the completed session is closed as soon as both report
+	before := len(ExportNatObservations())
+
+	session.Mutex.Lock()
+	for i := byte(0); i < 2; i++ {
+		session.handleInit(conn, addrs[i], makeInitPacket(PortTypeNATNEG1, i, 0, "mariokartwii"), "NATNEG:test", 3)
+	}
+	for i := byte(0); i < 2; i++ {
+		report := []byte{PortTypeNATNEG1, i, NNResultSuccess, NATTypeFullCone + i, 0, 0, 0, NATMappingConsistent + i, 0}
+		session.handleReport(conn, addrs[i], report, "NATNEG:test", 3)
+	}
+	session.Mutex.Unlock()
+
+	// A session that expires without any negotiation
+	expired, _ := newTestSession(0x0b5e0002)
+	expired.Mutex.Lock()
+	expired.handleInit(conn, &net.UDPAddr{IP: net.IPv4(9, 9, 9, 9), Port: 5002}, makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+	expired.Mutex.Unlock()
+
+	session.close(conn, "NATNEG:test")
+	expired.close(conn, "NATNEG:test")
+
+	observations := ExportNatObservations()
+	if len(observations)-before != 3 {
+		t.Fatalf("expected 3 new observations, got %d", len(observations)-before)
+	}
+
+	found := map[string]bool{}
+	for _, observation := range observations[before:] {
+		for _, addr := range addrs {
+			if strings.Contains(observation.IPHash, addr.IP.String()) {
+				t.Fatalf("observation contains a raw IP: %+v", observation)
+			}
+		}
+		found[observation.NATType+"/"+observation.MappingScheme+"/"+observation.Outcome] = true
+	}
+
+	for _, expected := range []string{"full-cone/consistent/success", "restricted-cone/incremental/success", "unknown/unknown/incomplete"} {
+		if !found[expected] {
+			t.Errorf("missing observation %s in %v", expected, found)
+		}
+	}
+
+	if hashObservationIP("1.2.3.4") != hashObservationIP("1.2.3.4") || hashObservationIP("1.2.3.4") == hashObservationIP("5.6.7.8") {
+		t.Error("IP hashes should be stable and distinct")
+	}
+}
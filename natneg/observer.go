@@ -0,0 +1,80 @@
+package natneg
+
+import (
+	"encoding/hex"
+	"net"
+	"sync"
+	"time"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// A packet captured for an observed cookie
+type ObservedPacket struct {
+	Time    time.Time
+	Address string
+	Command byte
+	Data    []byte
+}
+
+// Keep at most this many packets per observed cookie
+const maxObservedPackets = 256
+
+var (
+	observedCookies = map[uint32][]ObservedPacket{}
+	observerMutex   = sync.Mutex{}
+)
+
+// Enable or disable observer mode for a session cookie. Packets for an
+// observed cookie are logged and recorded but never replied to, so a
+// negotiation happening through another server can be captured without
+// interfering with it.
+func SetCookieObserved(cookie uint32, on bool) {
+	observerMutex.Lock()
+	defer observerMutex.Unlock()
+
+	if on {
+		if _, exists := observedCookies[cookie]; !exists {
+			observedCookies[cookie] = []ObservedPacket{}
+		}
+		logging.Notice("NATNEG", "Observing cookie", aurora.Cyan(cookie))
+	} else {
+		delete(observedCookies, cookie)
+	}
+}
+
+// Get the packets recorded for an observed cookie, oldest first
+func GetObservedPackets(cookie uint32) []ObservedPacket {
+	observerMutex.Lock()
+	defer observerMutex.Unlock()
+
+	return append([]ObservedPacket{}, observedCookies[cookie]...)
+}
+
+// Record the packet if its cookie is observed. Returns false if the packet
+// should be handled normally.
+func observePacket(addr net.Addr, command byte, cookie uint32, buffer []byte, moduleName string) bool {
+	observerMutex.Lock()
+	defer observerMutex.Unlock()
+
+	packets, observed := observedCookies[cookie]
+	if !observed {
+		return false
+	}
+
+	logging.Info(moduleName, "Observed packet", aurora.Yellow(command), hex.EncodeToString(buffer))
+
+	if len(packets) >= maxObservedPackets {
+		packets = packets[1:]
+	}
+
+	// The read buffer is reused after the packet is handled
+	observedCookies[cookie] = append(packets, ObservedPacket{
+		Time:    time.Now(),
+		Address: addr.String(),
+		Command: command,
+		Data:    append([]byte{}, buffer...),
+	})
+	return true
+}
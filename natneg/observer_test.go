@@ -0,0 +1,44 @@
+package natneg
+
+import (
+	"net"
+	"testing"
+)
+
+func TestObserverModeSendsNoReplies(t *testing.T) {
+	SetCookieObserved(0x0000f0f0, true)
+	defer SetCookieObserved(0x0000f0f0, false)
+
+	conn := &mockConn{}
+	natnegConn = conn
+
+	addr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}
+	packet := createPacketHeader(3, NNInitRequest, 0x0000f0f0)
+	packet = append(packet, makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii")...)
+	handleConnection(conn, addr, packet)
+
+	report := createPacketHeader(3, NNReportRequest, 0x0000f0f0)
+	handleConnection(conn, addr, append(report, make([]byte, 10)...))
+
+	conn.mutex.Lock()
+	sent := len(conn.packets)
+	conn.mutex.Unlock()
+	if sent != 0 {
+		t.Fatalf("sent %d replies to observed packets", sent)
+	}
+
+	mutex.Lock()
+	_, exists := sessions[0x0000f0f0]
+	mutex.Unlock()
+	if exists {
+		t.Fatal("a session was created for an observed cookie")
+	}
+
+	packets := GetObservedPackets(0x0000f0f0)
+	if len(packets) != 2 || packets[0].Command != NNInitRequest || packets[1].Command != NNReportRequest {
+		t.Fatalf("observed packets were not recorded: %+v", packets)
+	}
+	if packets[0].Address != addr.String() || len(packets[0].Data) != len(packet) {
+		t.Fatalf("observed packet is wrong: %+v", packets[0])
+	}
+}
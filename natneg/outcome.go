@@ -0,0 +1,78 @@
+package natneg
+
+import (
+	"sync"
+)
+
+// The final outcome of a session prepared by the matchmaker
+type SessionOutcome struct {
+	Cookie  uint32
+	Success bool
+	// The abort reason for a failed session, AbortReasonNone on success
+	Reason byte
+}
+
+// Told the outcome of each prepared session once it ends, so the matchmaker
+// can re-queue the players or mark the match failed. Implemented outside of
+// natneg, which can't import the matchmaker.
+type OutcomeListener interface {
+	NATNEGSessionEnded(outcome SessionOutcome)
+}
+
+var (
+	outcomeListener      OutcomeListener
+	outcomeListenerMutex = sync.RWMutex{}
+)
+
+// Set the listener told about prepared sessions, nil to stop reporting
+func SetOutcomeListener(listener OutcomeListener) {
+	outcomeListenerMutex.Lock()
+	outcomeListener = listener
+	outcomeListenerMutex.Unlock()
+}
+
+// Tell the listener how a prepared session ended. Expects the session mutex
+// to already be locked. The listener is called on its own goroutine, since it
+// may take locks held by whoever is closing the session.
+func (session *NATNEGSession) reportOutcome(reason byte) {
+	if !session.Prepared {
+		return
+	}
+
+	outcomeListenerMutex.RLock()
+	listener := outcomeListener
+	outcomeListenerMutex.RUnlock()
+
+	if listener == nil {
+		return
+	}
+
+	outcome := SessionOutcome{Cookie: session.Cookie, Reason: reason}
+	if reason == AbortReasonNone {
+		if session.isComplete() {
+			outcome.Success = true
+		} else {
+			outcome.Reason = session.failureReason()
+		}
+	}
+
+	go listener.NATNEGSessionEnded(outcome)
+}
+
+// Get the reason an incomplete session failed, from the first client that
+// would be told to cancel. Expects the session mutex to already be locked.
+func (session *NATNEGSession) failureReason() byte {
+	for _, client := range session.Clients {
+		if client.PredictionAbandoned {
+			return AbortReasonErraticPorts
+		}
+		if reason := session.abortReason(client); reason != AbortReasonNone {
+			return reason
+		}
+	}
+
+	if len(session.Clients) < 2 {
+		return AbortReasonPeerNotMapped
+	}
+	return AbortReasonTimeout
+}
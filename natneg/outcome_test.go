@@ -0,0 +1,94 @@
+package natneg
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type fakeOutcomeListener chan SessionOutcome
+
+func (l fakeOutcomeListener) NATNEGSessionEnded(outcome SessionOutcome) {
+	l <- outcome
+}
+
+func (l fakeOutcomeListener) wait(t *testing.T) SessionOutcome {
+	select {
+	case outcome := <-l:
+		return outcome
+	case <-time.After(2 * time.Second):
+		t.Fatal("the outcome was not reported")
+		return SessionOutcome{}
+	}
+}
+
+func TestPreparedSessionOutcome(t *testing.T) {
+	listener := make(fakeOutcomeListener, 4)
+	SetOutcomeListener(listener)
+	defer SetOutcomeListener(nil)
+
+	_, conn := newTestSession(0)
+
+	// Both clients connect, which closes the session
+	PrepareSession(0x0c0e0001, "mariokartwii", 2)
+	mutex.Lock()
+	session := sessions[0x0c0e0001]
+	mutex.Unlock()
+
+	addrs := [2]*net.UDPAddr{
+		{IP: net.IPv4(1, 2, 3, 4), Port: 5000},
+		{IP: net.IPv4(5, 6, 7, 8), Port: 5001},
+	}
+
+	session.Mutex.Lock()
+	for i := byte(0); i < 2; i++ {
+		session.handleInit(conn, addrs[i], makeInitPacket(PortTypeNATNEG1, i, 0, "mariokartwii"), "NATNEG:test", 3)
+	}
+	for i := byte(0); i < 2; i++ {
+		session.Clients[i].ConnectAck = true
+		report := []byte{PortTypeNATNEG1, i, NNResultSuccess, NATTypeFullCone, 0, 0, 0, NATMappingConsistent, 0}
+		session.handleReport(conn, addrs[i], report, "NATNEG:test", 3)
+	}
+	session.Mutex.Unlock()
+
+	if outcome := listener.wait(t); outcome.Cookie != 0x0c0e0001 || !outcome.Success || outcome.Reason != AbortReasonNone {
+		t.Fatalf("expected a successful outcome, got %+v", outcome)
+	}
+
+	// A client that never got a peer fails the match
+	PrepareSession(0x0c0e0002, "mariokartwii", 2)
+	mutex.Lock()
+	session = sessions[0x0c0e0002]
+	mutex.Unlock()
+
+	session.Mutex.Lock()
+	session.handleInit(conn, addrs[0], makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+	session.Mutex.Unlock()
+	session.close(conn, "NATNEG:test")
+
+	if outcome := listener.wait(t); outcome.Cookie != 0x0c0e0002 || outcome.Success || outcome.Reason != AbortReasonPeerNotMapped {
+		t.Fatalf("expected a failure without a peer, got %+v", outcome)
+	}
+
+	// Aborting a prepared session reports it as failed
+	PrepareSession(0x0c0e0004, "mariokartwii", 2)
+	if !AbortSession(0x0c0e0004) {
+		t.Fatal("prepared session was not found to abort")
+	}
+	if outcome := listener.wait(t); outcome.Cookie != 0x0c0e0004 || outcome.Success {
+		t.Fatalf("expected a failure for an aborted session, got %+v", outcome)
+	}
+	if AbortSession(0x0c0e0004) {
+		t.Fatal("aborted a session that was already closed")
+	}
+
+	// Sessions the matchmaker didn't prepare aren't reported
+	unprepared, _ := newTestSession(0x0c0e0003)
+	unprepared.close(conn, "NATNEG:test")
+
+	select {
+	case outcome := <-listener:
+		t.Fatalf("unexpected outcome for an unprepared session: %+v", outcome)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
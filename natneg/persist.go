@@ -0,0 +1,116 @@
+package natneg
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"wwfc/common"
+	"wwfc/database"
+	"wwfc/logging"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/logrusorgru/aurora/v3"
+)
+
+const predictionStat = "prediction"
+
+var (
+	// Database connection shared by everything natneg persists
+	persistStore database.Store
+
+	// Database the aggregate stats are flushed to, nil if they aren't persisted
+	statsStore database.Store
+)
+
+func natTypeStat(pair NATTypePair) string {
+	return fmt.Sprintf("nattype:%d-%d", pair.A, pair.B)
+}
+
+// Connect to the database the first time something needs to be persisted
+func connectStore(config common.Config) database.Store {
+	if persistStore != nil {
+		return persistStore
+	}
+
+	dbString := fmt.Sprintf("postgres://%s:%s@%s/%s", config.Username, config.Password, config.DatabaseAddress, config.DatabaseName)
+	dbConf, err := pgxpool.ParseConfig(dbString)
+	if err != nil {
+		panic(err)
+	}
+
+	ctx := context.Background()
+	pool, err := pgxpool.ConnectConfig(ctx, dbConf)
+	if err != nil {
+		panic(err)
+	}
+
+	database.UpdateTables(pool, ctx)
+	persistStore = database.NewPostgresStore(pool, ctx)
+	return persistStore
+}
+
+// Connect to the database and restore the stats saved before the last
+// restart, then flush them every interval until the server is stopped
+func startStatsPersistence(config common.Config, interval time.Duration) {
+	statsStore = connectStore(config)
+	loadStats(statsStore)
+
+	go func() {
+		for !stopping.Load() {
+			time.Sleep(interval)
+			flushStats(statsStore)
+		}
+	}()
+}
+
+// Add the stats saved in the store to the counters
+func loadStats(store database.Store) {
+	stats := store.GetNATNEGStats()
+
+	natTypeOutcomesMutex.Lock()
+	for name, stat := range stats {
+		var a, b byte
+		if _, err := fmt.Sscanf(name, "nattype:%d-%d", &a, &b); err != nil {
+			continue
+		}
+
+		pair := makeNATTypePair(a, b)
+		outcome, exists := natTypeOutcomes[pair]
+		if !exists {
+			outcome = &NATTypeOutcome{}
+			natTypeOutcomes[pair] = outcome
+		}
+		outcome.Successes += stat.Successes
+		outcome.Failures += stat.Failures
+	}
+	natTypeOutcomesMutex.Unlock()
+
+	prediction := stats[predictionStat]
+	predictionHits.Add(prediction.Successes)
+	predictionMisses.Add(prediction.Failures)
+
+	logging.Notice("NATNEG", "Restored", aurora.Cyan(len(stats)), "saved stats")
+}
+
+// Save the current totals to the store
+func flushStats(store database.Store) {
+	stats := map[string]database.NATNEGStat{}
+	for pair, outcome := range GetNATTypeOutcomes() {
+		stats[natTypeStat(pair)] = database.NATNEGStat{Successes: outcome.Successes, Failures: outcome.Failures}
+	}
+
+	hits, misses := GetPredictionStats()
+	stats[predictionStat] = database.NATNEGStat{Successes: hits, Failures: misses}
+
+	store.SaveNATNEGStats(stats)
+}
+
+// GetStoredStats returns the aggregate stats as last flushed to the database,
+// keyed by stat name
+func GetStoredStats() map[string]database.NATNEGStat {
+	if statsStore == nil {
+		return map[string]database.NATNEGStat{}
+	}
+
+	return statsStore.GetNATNEGStats()
+}
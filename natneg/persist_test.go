@@ -0,0 +1,41 @@
+package natneg
+
+import (
+	"testing"
+	"wwfc/database"
+)
+
+func TestStatsSurviveRestart(t *testing.T) {
+	store := database.NewMemoryStore()
+
+	recordNATTypeOutcome(NATTypeSymmetric, NATTypeNoNat, true)
+	recordNATTypeOutcome(NATTypeNoNat, NATTypeSymmetric, false)
+	predictionHits.Add(3)
+	predictionMisses.Add(1)
+
+	outcomes := GetNATTypeOutcomes()
+	hits, misses := GetPredictionStats()
+	flushStats(store)
+
+	// Simulate a restart
+	natTypeOutcomesMutex.Lock()
+	natTypeOutcomes = map[NATTypePair]*NATTypeOutcome{}
+	natTypeOutcomesMutex.Unlock()
+	predictionHits.Store(0)
+	predictionMisses.Store(0)
+
+	loadStats(store)
+
+	restored := GetNATTypeOutcomes()
+	pair := makeNATTypePair(NATTypeNoNat, NATTypeSymmetric)
+	if restored[pair] != outcomes[pair] || restored[pair].Successes == 0 || restored[pair].Failures == 0 {
+		t.Fatalf("NAT type outcomes not restored: %+v, expected %+v", restored[pair], outcomes[pair])
+	}
+	if len(restored) != len(outcomes) {
+		t.Fatalf("restored %d NAT type pairs, expected %d", len(restored), len(outcomes))
+	}
+
+	if restoredHits, restoredMisses := GetPredictionStats(); restoredHits != hits || restoredMisses != misses {
+		t.Fatalf("prediction stats not restored: %d/%d, expected %d/%d", restoredHits, restoredMisses, hits, misses)
+	}
+}
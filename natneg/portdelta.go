@@ -0,0 +1,74 @@
+package natneg
+
+import (
+	"net"
+	"strconv"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// Largest spread between the public ports a client's probes arrived from
+// before its mapping is treated as unpredictable, 0 for no limit. The probes
+// all come from the same local socket, so a NAT that can be predicted maps them
+// to the same port or to nearby ones.
+var maxPortDelta int
+
+// Get the spread between the public ports seen on each NATNEG probe port.
+// Returns false if there are not at least two to compare.
+func (client *NATNEGClient) probePortSpread() (int, bool) {
+	minPort, maxPort := -1, -1
+	count := 0
+	for _, endpoint := range client.Endpoints {
+		_, portStr, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			continue
+		}
+
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			continue
+		}
+
+		if minPort == -1 || port < minPort {
+			minPort = port
+		}
+		if port > maxPort {
+			maxPort = port
+		}
+		count++
+	}
+
+	if count < 2 {
+		return 0, false
+	}
+	return maxPort - minPort, true
+}
+
+// Stop negotiating for a client whose probe ports vary too much to predict,
+// and tell it to fall back straight away instead of waiting for connect
+// requests to an endpoint that won't work. A peer it was already paired with is
+// freed to pair with someone else. Expects the session mutex to already be
+// locked.
+func (session *NATNEGSession) checkProbePorts(client *NATNEGClient, moduleName string) {
+	if maxPortDelta <= 0 || client.PredictionAbandoned {
+		return
+	}
+
+	spread, ok := client.probePortSpread()
+	if !ok || spread <= maxPortDelta {
+		return
+	}
+
+	logging.Warn(moduleName, "Client", aurora.Cyan(client.Index), "probe ports differ by", aurora.Cyan(spread), "- abandoning prediction")
+	client.PredictionAbandoned = true
+
+	if peer, exists := session.Clients[client.ConnectingIndex]; exists && peer != client && peer.ConnectingIndex == client.Index {
+		peer.ConnectingIndex = peer.Index
+		peer.ConnectAck = false
+	}
+	client.ConnectingIndex = client.Index
+	client.ConnectAck = false
+
+	session.sendAbort(natnegConn, client, AbortReasonErraticPorts)
+}
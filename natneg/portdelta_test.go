@@ -0,0 +1,55 @@
+package natneg
+
+import (
+	"net"
+	"testing"
+)
+
+func TestErraticProbePortsAbandonPrediction(t *testing.T) {
+	maxPortDelta = 100
+	defer func() { maxPortDelta = 0 }()
+
+	session, conn := newTestSession(0x0de17a00)
+	defer closeTestSession(session)
+
+	session.Mutex.Lock()
+	defer session.Mutex.Unlock()
+
+	// A peer with a stable mapping
+	session.handleInit(conn, &net.UDPAddr{IP: net.IPv4(5, 6, 7, 8), Port: 5000}, makeInitPacket(PortTypeNATNEG1, 1, 0, "mariokartwii"), "NATNEG:test", 3)
+
+	session.handleInit(conn, &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 1000}, makeInitPacket(PortTypeNATNEG2, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+	session.handleInit(conn, &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 41000}, makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+
+	client := session.Clients[0]
+	if !client.PredictionAbandoned {
+		t.Fatal("prediction was not abandoned for erratic probe ports")
+	}
+
+	if client.ConnectingIndex != client.Index || session.Clients[1].ConnectingIndex != 1 {
+		t.Fatal("a client with an unpredictable mapping was paired")
+	}
+
+	abort := conn.last(NNReportReply)
+	if abort == nil || abort[len(abort)-1] != AbortReasonErraticPorts {
+		t.Fatalf("client was not told to fall back: %x", abort)
+	}
+
+	// Probe ports within the bound are still negotiated normally
+	session, _ = newTestSession(0x0de17a01)
+	defer closeTestSession(session)
+
+	session.Mutex.Lock()
+	defer session.Mutex.Unlock()
+
+	session.handleInit(conn, &net.UDPAddr{IP: net.IPv4(5, 6, 7, 8), Port: 5000}, makeInitPacket(PortTypeNATNEG1, 1, 0, "mariokartwii"), "NATNEG:test", 3)
+	session.handleInit(conn, &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 1000}, makeInitPacket(PortTypeNATNEG2, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+	session.handleInit(conn, &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 1002}, makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+
+	if session.Clients[0].PredictionAbandoned {
+		t.Fatal("prediction was abandoned for ports within the bound")
+	}
+	if session.Clients[0].ConnectingIndex != 1 {
+		t.Fatal("a client with a predictable mapping was not paired")
+	}
+}
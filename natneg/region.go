@@ -0,0 +1,36 @@
+package natneg
+
+import (
+	"wwfc/qr2"
+)
+
+var (
+	// Games that can't be matched across regions
+	regionLockedGames = map[string]bool{}
+
+	// Look up the game code for a client's game port address, used to derive its region
+	getGameCode = qr2.GetGameCode
+)
+
+func (client *NATNEGClient) updateRegion() {
+	if client.ServerIP == "" {
+		return
+	}
+
+	if gameCode, ok := getGameCode(client.ServerIP); ok && len(gameCode) == 4 {
+		client.Region = gameCode[3]
+	}
+}
+
+func isRegionCompatible(sender *NATNEGClient, destination *NATNEGClient) bool {
+	if !regionLockedGames[sender.GameName] {
+		return true
+	}
+
+	// The region is unknown if the client isn't registered with QR2, so don't block it
+	if sender.Region == 0 || destination.Region == 0 {
+		return true
+	}
+
+	return sender.Region == destination.Region
+}
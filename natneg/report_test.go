@@ -0,0 +1,36 @@
+package natneg
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestReportReply(t *testing.T) {
+	report := []byte{PortTypeNATNEG1, 1, NNResultSuccess, 0x00, 0x00, 0x00, 0x02, NATMappingConsistent, 0x00, 'm', 'k', 0x00}
+	reply, ok := createReportReply(3, 0x01020304, report)
+	if !ok {
+		t.Fatal("valid report was rejected")
+	}
+
+	expected := append(createPacketHeader(3, NNReportReply, 0x01020304), PortTypeNATNEG1, 1, 0x00, 0x00, 0x00, 0x00, 0x02, NATMappingConsistent, 0x00)
+	if !bytes.Equal(reply, expected) {
+		t.Fatalf("unexpected report reply:\n got %x\nwant %x", reply, expected)
+	}
+
+	for size := 0; size < reportSize; size++ {
+		if _, ok := createReportReply(3, 0x01020304, report[:size]); ok {
+			t.Errorf("report of %d bytes was accepted", size)
+		}
+	}
+
+	session, conn := newTestSession(0x0e0e0e0e)
+	addr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}
+	for size := 0; size <= reportSize; size++ {
+		session.handleReport(conn, addr, report[:size], "", 3)
+	}
+
+	if count := conn.count(NNReportReply); count != 1 {
+		t.Fatalf("expected one report reply, got %d", count)
+	}
+}
@@ -0,0 +1,66 @@
+package natneg
+
+import (
+	"net"
+	"sync"
+	"wwfc/common"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// Endpoints observed in a network that peers should be told to reach at
+// another address, for deployments where the address the server sees isn't
+// the one peers can connect to
+type endpointRewrite struct {
+	network *net.IPNet
+	ip      net.IP
+}
+
+var (
+	endpointRewrites      []endpointRewrite
+	endpointRewritesMutex = sync.RWMutex{}
+)
+
+func setEndpointRewrites(rewrites []common.NATNEGEndpointRewrite) {
+	var parsed []endpointRewrite
+	for _, rewrite := range rewrites {
+		_, network, err := net.ParseCIDR(rewrite.From)
+		ip := net.ParseIP(rewrite.To).To4()
+		if err != nil || ip == nil {
+			logging.Warn("NATNEG", "Ignoring invalid endpoint rewrite", aurora.Cyan(rewrite.From), "->", aurora.Cyan(rewrite.To))
+			continue
+		}
+
+		parsed = append(parsed, endpointRewrite{network: network, ip: ip})
+	}
+
+	endpointRewritesMutex.Lock()
+	endpointRewrites = parsed
+	endpointRewritesMutex.Unlock()
+}
+
+// Rewrite an observed endpoint before it's given to a peer. The IP of the
+// first matching rewrite replaces the observed one; the port is kept.
+func RewriteEndpoint(observed string) string {
+	host, port, err := net.SplitHostPort(observed)
+	if err != nil {
+		return observed
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return observed
+	}
+
+	endpointRewritesMutex.RLock()
+	defer endpointRewritesMutex.RUnlock()
+
+	for _, rewrite := range endpointRewrites {
+		if rewrite.network.Contains(ip) {
+			return net.JoinHostPort(rewrite.ip.String(), port)
+		}
+	}
+
+	return observed
+}
@@ -0,0 +1,44 @@
+package natneg
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"wwfc/common"
+)
+
+func TestConnectRequestEndpointRewritten(t *testing.T) {
+	setEndpointRewrites([]common.NATNEGEndpointRewrite{{From: "10.0.0.0/8", To: "203.0.113.5"}})
+	defer setEndpointRewrites(nil)
+
+	session, conn := newTestSession(0x5e770001)
+	defer closeTestSession(session)
+
+	session.Mutex.Lock()
+	defer session.Mutex.Unlock()
+
+	session.handleInit(conn, &net.UDPAddr{IP: net.IPv4(10, 1, 2, 3), Port: 5000}, makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+	session.handleInit(conn, &net.UDPAddr{IP: net.IPv4(5, 6, 7, 8), Port: 5001}, makeInitPacket(PortTypeNATNEG1, 1, 0, "mariokartwii"), "NATNEG:test", 3)
+
+	tests := []struct {
+		from     byte
+		endpoint string
+	}{
+		{0, "203.0.113.5:5000"},
+		{1, "5.6.7.8:5001"},
+	}
+
+	for _, test := range tests {
+		session.Clients[test.from].sendConnectRequestPacket(conn, session.Clients[1-test.from], 3)
+
+		packet := conn.last(NNConnectRequest)
+		if len(packet) < 18 {
+			t.Fatalf("client %d: missing connect request", test.from)
+		}
+
+		endpoint := (&net.UDPAddr{IP: net.IP(packet[12:16]), Port: int(binary.BigEndian.Uint16(packet[16:18]))}).String()
+		if endpoint != test.endpoint {
+			t.Errorf("client %d: expected endpoint %s, got %s", test.from, test.endpoint, endpoint)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package natneg
+
+import (
+	"net"
+	"sync"
+	"time"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// Rejected sources are logged at most once per interval
+const sourceSessionsWarnInterval = time.Minute
+
+var (
+	// Maximum number of open sessions a source IP can be a client in at once,
+	// 0 for no limit
+	maxSessionsPerSource = 0
+
+	sourceSessions       = map[string]int{}
+	sourceSessionsWarned = map[string]time.Time{}
+	sourceSessionsMutex  = sync.Mutex{}
+)
+
+// Reserve a session slot for a new client from the address. Returns false if
+// the source is already in too many sessions.
+func reserveSourceSession(addr net.Addr, moduleName string) bool {
+	host := sourceHost(addr)
+
+	sourceSessionsMutex.Lock()
+	defer sourceSessionsMutex.Unlock()
+
+	if maxSessionsPerSource > 0 && sourceSessions[host] >= maxSessionsPerSource {
+		now := time.Now()
+		if now.Sub(sourceSessionsWarned[host]) >= sourceSessionsWarnInterval {
+			if len(sourceSessionsWarned) > 4096 {
+				sourceSessionsWarned = map[string]time.Time{}
+			}
+			sourceSessionsWarned[host] = now
+			logging.Warn(moduleName, "Rejecting init from", aurora.BrightCyan(host), "which is already in", aurora.Cyan(sourceSessions[host]), "sessions")
+		}
+		return false
+	}
+
+	sourceSessions[host]++
+	return true
+}
+
+func releaseSourceSession(host string) {
+	sourceSessionsMutex.Lock()
+	defer sourceSessionsMutex.Unlock()
+
+	if sourceSessions[host] <= 1 {
+		delete(sourceSessions, host)
+		return
+	}
+	sourceSessions[host]--
+}
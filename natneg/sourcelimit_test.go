@@ -0,0 +1,48 @@
+package natneg
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSessionsPerSourceLimited(t *testing.T) {
+	maxSessionsPerSource = 2
+	defer func() { maxSessionsPerSource = 0 }()
+
+	addr := &net.UDPAddr{IP: net.IPv4(7, 7, 7, 7), Port: 5000}
+
+	join := func(cookie uint32) (*NATNEGSession, bool) {
+		session, conn := newTestSession(cookie)
+		session.Mutex.Lock()
+		session.handleInit(conn, addr, makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+		session.Mutex.Unlock()
+
+		_, joined := session.Clients[0]
+		if joined != (conn.count(NNInitReply) == 1) {
+			t.Fatalf("session %08x: init ack sent for a rejected init, or missing", cookie)
+		}
+		return session, joined
+	}
+
+	first, joined := join(0x50c00001)
+	if !joined {
+		t.Fatal("first join should be accepted")
+	}
+	second, joined := join(0x50c00002)
+	if !joined {
+		t.Fatal("second join should be accepted")
+	}
+	defer second.close(natnegConn, "NATNEG:test")
+
+	if _, joined := join(0x50c00003); joined {
+		t.Fatal("join over the limit should be rejected")
+	}
+
+	first.close(natnegConn, "NATNEG:test")
+
+	fourth, joined := join(0x50c00004)
+	if !joined {
+		t.Fatal("join after a session closed should be accepted")
+	}
+	defer fourth.close(natnegConn, "NATNEG:test")
+}
@@ -0,0 +1,49 @@
+package natneg
+
+import (
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// Times a pair may be paired for a connect exchange. A pair normally only needs
+// one, as failed attempts are retried without pairing again, so more than this
+// means the clients keep re-targeting each other without converging.
+const maxPairExchanges = 5
+
+func makePairKey(a byte, b byte) [2]byte {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]byte{a, b}
+}
+
+// Count a connect exchange for the pair. Returns false if the pair stalled, in
+// which case it has been aborted. Expects the session mutex to be locked.
+func (session *NATNEGSession) countExchange(sender *NATNEGClient, destination *NATNEGClient, moduleName string) bool {
+	if session.Exchanges == nil {
+		session.Exchanges = map[[2]byte]int{}
+	}
+
+	key := makePairKey(sender.Index, destination.Index)
+	session.Exchanges[key]++
+	if session.Exchanges[key] <= maxPairExchanges {
+		return true
+	}
+
+	logging.Error(moduleName, "Pair", aurora.BrightCyan(sender.Index), "and", aurora.BrightCyan(destination.Index), "was paired", aurora.Cyan(session.Exchanges[key]-1), "times without completing, likely a state machine stall - aborting")
+	session.abortPair(sender, destination)
+	return false
+}
+
+// Stop negotiating between the pair for good and tell both clients to give up
+func (session *NATNEGSession) abortPair(a *NATNEGClient, b *NATNEGClient) {
+	a.Attempts[b.Index] = maxConnectAttempts
+	b.Attempts[a.Index] = maxConnectAttempts
+
+	for _, client := range []*NATNEGClient{a, b} {
+		client.ConnectingIndex = client.Index
+		client.ConnectAck = false
+		session.sendAbort(natnegConn, client, AbortReasonStalled)
+	}
+}
@@ -0,0 +1,46 @@
+package natneg
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPingPongStallAborted(t *testing.T) {
+	session, conn := newTestSession(0x057a1100)
+	defer closeTestSession(session)
+
+	addr0 := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}
+	addr1 := &net.UDPAddr{IP: net.IPv4(5, 6, 7, 8), Port: 5001}
+
+	session.Mutex.Lock()
+	defer session.Mutex.Unlock()
+
+	session.handleInit(conn, addr0, makeInitPacket(PortTypeNATNEG1, 0, 0, "mariokartwii"), "NATNEG:test", 3)
+	session.handleInit(conn, addr1, makeInitPacket(PortTypeNATNEG1, 1, 0, "mariokartwii"), "NATNEG:test", 3)
+
+	// Keep letting both clients go back to idle without completing, so they
+	// are paired with each other over and over
+	for i := 0; i < maxPairExchanges*2; i++ {
+		session.Clients[0].ConnectingIndex = 0
+		session.Clients[1].ConnectingIndex = 1
+		session.sendConnectRequests("NATNEG:test")
+	}
+
+	if exchanges := session.Exchanges[makePairKey(0, 1)]; exchanges != maxPairExchanges+1 {
+		t.Fatalf("expected the pair to be aborted after %d exchanges, got %d", maxPairExchanges, exchanges)
+	}
+
+	for i := byte(0); i < 2; i++ {
+		client := session.Clients[i]
+		if client.ConnectingIndex != i {
+			t.Errorf("client %d is still negotiating with %d", i, client.ConnectingIndex)
+		}
+		if client.Attempts[1-i] < maxConnectAttempts {
+			t.Errorf("client %d can still be paired again", i)
+		}
+	}
+
+	if count := conn.count(NNReportReply); count != 2 {
+		t.Fatalf("expected both clients to be told to give up, got %d packets", count)
+	}
+}
@@ -0,0 +1,91 @@
+package natneg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+func (session *NATNEGSession) handleStateUpdate(conn net.PacketConn, addr net.Addr, buffer []byte, moduleName string, version byte) {
+	// The state update uses the same layout as the init packet, minus the game name
+	// xx          - Port type
+	// xx          - Client index
+	// xx          - Use game port
+	// xx xx xx xx - Local IP
+	// xx xx       - Local port
+	if len(buffer) < 9 {
+		logging.Error(moduleName, "Invalid packet size")
+		return
+	}
+
+	portType := buffer[0]
+	clientIndex := buffer[1]
+	useGamePort := buffer[2]
+	localIPBytes := buffer[3:7]
+	localPort := binary.BigEndian.Uint16(buffer[7:9])
+
+	if portType > 0x03 {
+		logging.Error(moduleName, "Invalid port type")
+		return
+	}
+	if useGamePort > 1 {
+		logging.Error(moduleName, "Invalid", aurora.BrightGreen("Use Game Port"), "value")
+		return
+	}
+
+	client, exists := session.Clients[clientIndex]
+	if !exists {
+		logging.Error(moduleName, "State update for unknown client index", aurora.Cyan(clientIndex))
+		return
+	}
+
+	// Acknowledge the update the same way as an init, so the client stops retransmitting
+	session.sendInitAck(conn, addr, version, portType, clientIndex)
+
+	previousNegotiateIP := client.NegotiateIP
+	previousServerIP := client.ServerIP
+
+	if portType != PortTypeGamePort {
+		client.setEndpoint(portType, addr.String())
+	}
+	if localPort != 0 {
+		client.LocalIP = fmt.Sprintf("%d.%d.%d.%d:%d", localIPBytes[0], localIPBytes[1], localIPBytes[2], localIPBytes[3], localPort)
+	}
+	if useGamePort == 0 || portType == PortTypeGamePort {
+		client.ServerIP = addr.String()
+	}
+
+	if client.NegotiateIP == previousNegotiateIP && client.ServerIP == previousServerIP {
+		return
+	}
+
+	// Any endpoint observed by the peer belonged to the old mapping
+	client.ObservedIP = ""
+
+	logging.Notice(moduleName, "Client", aurora.Cyan(clientIndex), "mapping changed", aurora.BrightCyan(previousServerIP), "->", aurora.BrightCyan(client.ServerIP))
+
+	if !client.isMapped() {
+		return
+	}
+
+	// If the client is negotiating, give the peer the new endpoint and make it
+	// acknowledge again. A running exchange picks up the new address on its
+	// next retry, so the request is sent right away; otherwise the exchange is
+	// started again, which sends it and retries until the peer acknowledges.
+	if client.ConnectingIndex != client.Index {
+		if peer, exists := session.Clients[client.ConnectingIndex]; exists && peer.isMapped() {
+			peer.ConnectAck = false
+			if session.runningExchanges[makePairKey(client.Index, peer.Index)] {
+				client.sendConnectRequestPacket(connForPortType(peer.NegotiatePortType), peer, session.Version)
+			} else {
+				session.startExchange(client, peer)
+			}
+		}
+		return
+	}
+
+	session.sendConnectRequests(moduleName)
+}
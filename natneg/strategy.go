@@ -0,0 +1,94 @@
+package natneg
+
+import (
+	"net"
+	"sync"
+	"wwfc/metrics"
+)
+
+// How the endpoint given to a peer in a connect request was chosen
+const (
+	// The client isn't behind a NAT, so its endpoint is its local address
+	StrategyDirect = "direct"
+	// Both clients share a public address, so they reach each other on the LAN
+	StrategyLocalLAN = "local-lan"
+	// Both clients share a public address but no LAN endpoint is known, so the
+	// public one is used through NAT hairpinning
+	StrategyHairpin = "hairpin"
+	// The mapping of the game port seen at init, predicted to hold for the peer
+	StrategyPredicted = "predicted-port"
+	// An endpoint the client reported in a connect reply instead of the prediction
+	StrategyObserved = "observed"
+)
+
+var (
+	// Later strategies win when the two directions of a pair differ
+	strategyRanks = map[string]int{
+		StrategyDirect:    0,
+		StrategyPredicted: 1,
+		StrategyObserved:  2,
+		StrategyHairpin:   3,
+		StrategyLocalLAN:  4,
+	}
+
+	strategySuccesses      = map[string]uint64{}
+	strategySuccessesMutex = sync.Mutex{}
+)
+
+func endpointHost(endpoint string) string {
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	return host
+}
+
+// Get how the client's endpoint given to the destination is chosen. Mirrors
+// connectAddress.
+func (client *NATNEGClient) connectStrategy(destination *NATNEGClient) string {
+	if client.sharesPublicIP(destination) {
+		if client.hasLocalEndpoint() {
+			return StrategyLocalLAN
+		}
+		return StrategyHairpin
+	}
+
+	if client.ObservedIP != "" {
+		return StrategyObserved
+	}
+
+	if client.LocalIP != "" && endpointHost(client.ServerIP) == endpointHost(client.LocalIP) {
+		return StrategyDirect
+	}
+
+	return StrategyPredicted
+}
+
+// Get the strategy a negotiation between the pair relied on, from the
+// endpoints each was last given for the other
+func pairStrategy(a *NATNEGClient, b *NATNEGClient) string {
+	if strategyRanks[b.PeerStrategy] > strategyRanks[a.PeerStrategy] {
+		return b.PeerStrategy
+	}
+	return a.PeerStrategy
+}
+
+func recordStrategySuccess(strategy string) {
+	strategySuccessesMutex.Lock()
+	strategySuccesses[strategy]++
+	strategySuccessesMutex.Unlock()
+
+	metrics.Counter("natneg_strategy_successes_total", 1, "strategy", strategy)
+}
+
+// Get a copy of how many successful negotiations relied on each strategy
+func GetStrategySuccesses() map[string]uint64 {
+	strategySuccessesMutex.Lock()
+	defer strategySuccessesMutex.Unlock()
+
+	successes := map[string]uint64{}
+	for strategy, count := range strategySuccesses {
+		successes[strategy] = count
+	}
+	return successes
+}
@@ -0,0 +1,90 @@
+package natneg
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestStrategyAttribution(t *testing.T) {
+	tests := []struct {
+		name     string
+		cookie   uint32
+		addrs    [2]*net.UDPAddr
+		local    [2]net.IP
+		observed bool
+		expected string
+	}{
+		{
+			name:     "predicted",
+			cookie:   0x57a70001,
+			addrs:    [2]*net.UDPAddr{{IP: net.IPv4(1, 2, 3, 4), Port: 5000}, {IP: net.IPv4(5, 6, 7, 8), Port: 5001}},
+			local:    [2]net.IP{net.IPv4(192, 168, 1, 2), net.IPv4(192, 168, 1, 3)},
+			expected: StrategyPredicted,
+		},
+		{
+			name:     "direct",
+			cookie:   0x57a70002,
+			addrs:    [2]*net.UDPAddr{{IP: net.IPv4(1, 2, 3, 4), Port: 5000}, {IP: net.IPv4(5, 6, 7, 8), Port: 5001}},
+			local:    [2]net.IP{net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8)},
+			expected: StrategyDirect,
+		},
+		{
+			name:     "local-lan",
+			cookie:   0x57a70003,
+			addrs:    [2]*net.UDPAddr{{IP: net.IPv4(1, 2, 3, 4), Port: 5000}, {IP: net.IPv4(1, 2, 3, 4), Port: 5001}},
+			local:    [2]net.IP{net.IPv4(192, 168, 1, 2), net.IPv4(192, 168, 1, 3)},
+			expected: StrategyLocalLAN,
+		},
+		{
+			name:     "observed",
+			cookie:   0x57a70004,
+			addrs:    [2]*net.UDPAddr{{IP: net.IPv4(1, 2, 3, 4), Port: 5000}, {IP: net.IPv4(5, 6, 7, 8), Port: 5001}},
+			local:    [2]net.IP{net.IPv4(192, 168, 1, 2), net.IPv4(192, 168, 1, 3)},
+			observed: true,
+			expected: StrategyObserved,
+		},
+	}
+
+	for _, test := range tests {
+		before := GetStrategySuccesses()
+
+		session, conn := newTestSession(test.cookie)
+		session.Mutex.Lock()
+
+		for i := byte(0); i < 2; i++ {
+			packet := []byte{PortTypeNATNEG1, i, 0}
+			packet = append(packet, test.local[i].To4()...)
+			packet = binary.BigEndian.AppendUint16(packet, 0x1234)
+			packet = append(packet, []byte("mariokartwii\x00")...)
+			session.handleInit(conn, test.addrs[i], packet, "NATNEG:test", 3)
+		}
+
+		if test.observed {
+			// Client 0 reports being reached on a different port than predicted
+			reply := []byte{PortTypeNATNEG1, 0, 0, 1, 2, 3, 4, 0x13, 0x89}
+			session.handleConnectReply(conn, test.addrs[0], reply, "NATNEG:test", 3)
+			session.Clients[0].sendConnectRequestPacket(conn, session.Clients[1], 3)
+		}
+
+		for i := byte(0); i < 2; i++ {
+			session.Clients[i].ConnectAck = true
+			report := []byte{PortTypeNATNEG1, i, NNResultSuccess, NATTypeFullCone, 0, 0, 0, NATMappingConsistent, 0}
+			session.handleReport(conn, test.addrs[i], report, "NATNEG:test", 3)
+		}
+
+		session.Open = false
+		session.Mutex.Unlock()
+
+		after := GetStrategySuccesses()
+		for _, strategy := range []string{StrategyDirect, StrategyLocalLAN, StrategyPredicted, StrategyObserved} {
+			expected := uint64(0)
+			if strategy == test.expected {
+				expected = 1
+			}
+			if got := after[strategy] - before[strategy]; got != expected {
+				t.Errorf("%s: expected %d successes attributed to %s, got %d", test.name, expected, strategy, got)
+			}
+		}
+	}
+}
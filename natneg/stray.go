@@ -0,0 +1,89 @@
+package natneg
+
+import (
+	"net"
+	"sync"
+	"time"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// Sources that send this many rejected packets within the window are ignored
+// until the window ends
+const (
+	strayRejectLimit  = 10
+	strayRejectWindow = time.Minute
+)
+
+type straySource struct {
+	start    time.Time
+	rejected int
+}
+
+var (
+	// Packets with more stray bytes than this are rejected, 0 to only warn
+	maxStrayBytes = 0
+
+	straySources     = map[string]*straySource{}
+	straySourceMutex = sync.Mutex{}
+)
+
+func sourceHost(addr net.Addr) string {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr.IP.String()
+	}
+
+	return addr.String()
+}
+
+// Check the number of stray bytes after a packet. Returns false if the packet
+// should be rejected.
+func checkStrayBytes(addr net.Addr, stray int, moduleName string) bool {
+	if stray <= 0 {
+		return true
+	}
+
+	if maxStrayBytes == 0 || stray <= maxStrayBytes {
+		logging.Warn(moduleName, "Stray", aurora.BrightCyan(stray), "bytes after packet")
+		return true
+	}
+
+	logging.Error(moduleName, "Rejecting packet with", aurora.BrightCyan(stray), "stray bytes")
+
+	host := sourceHost(addr)
+	now := time.Now()
+
+	straySourceMutex.Lock()
+	defer straySourceMutex.Unlock()
+
+	source, exists := straySources[host]
+	if !exists || now.Sub(source.start) >= strayRejectWindow {
+		if len(straySources) > 4096 {
+			for key, other := range straySources {
+				if now.Sub(other.start) >= strayRejectWindow {
+					delete(straySources, key)
+				}
+			}
+		}
+
+		source = &straySource{start: now}
+		straySources[host] = source
+	}
+
+	source.rejected++
+	if source.rejected == strayRejectLimit {
+		logging.Warn(moduleName, "Ignoring", aurora.BrightCyan(host), "after repeated malformed packets")
+	}
+
+	return false
+}
+
+// Check if the source sent too many malformed packets recently
+func isStraySourceThrottled(addr net.Addr) bool {
+	straySourceMutex.Lock()
+	defer straySourceMutex.Unlock()
+
+	source, exists := straySources[sourceHost(addr)]
+	return exists && source.rejected >= strayRejectLimit && time.Since(source.start) < strayRejectWindow
+}
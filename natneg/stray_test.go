@@ -0,0 +1,48 @@
+package natneg
+
+import (
+	"net"
+	"testing"
+)
+
+func TestStrayBytesThreshold(t *testing.T) {
+	maxStrayBytes = 4
+	defer func() { maxStrayBytes = 0 }()
+
+	session, conn := newTestSession(0x57a40001)
+	defer closeTestSession(session)
+
+	addr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5000}
+	init := func(clientIndex byte, stray int) {
+		packet := append(createPacketHeader(3, NNInitRequest, session.Cookie), makeInitPacket(PortTypeNATNEG1, clientIndex, 0, "mariokartwii")...)
+		packet = append(packet, make([]byte, stray)...)
+		mutex.Lock()
+		sessions[session.Cookie] = session
+		mutex.Unlock()
+		handleConnection(conn, addr, packet)
+	}
+	defer func() {
+		mutex.Lock()
+		delete(sessions, session.Cookie)
+		mutex.Unlock()
+	}()
+
+	init(0, 4)
+	if _, exists := session.Clients[0]; !exists {
+		t.Fatal("packet with stray bytes below the threshold was rejected")
+	}
+
+	init(1, 5)
+	if _, exists := session.Clients[1]; exists {
+		t.Fatal("packet with stray bytes above the threshold was accepted")
+	}
+
+	// A source that keeps sending malformed packets is ignored for a while
+	for i := 1; i < strayRejectLimit; i++ {
+		init(1, 5)
+	}
+	init(2, 0)
+	if _, exists := session.Clients[2]; exists {
+		t.Fatal("valid packet from a throttled source was accepted")
+	}
+}
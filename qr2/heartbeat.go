@@ -7,6 +7,7 @@ import (
 	"strings"
 	"wwfc/common"
 	"wwfc/logging"
+	"wwfc/metrics"
 
 	"github.com/logrusorgru/aurora/v3"
 )
@@ -14,6 +15,7 @@ import (
 func heartbeat(moduleName string, conn net.PacketConn, addr net.Addr, buffer []byte) {
 	sessionId := binary.BigEndian.Uint32(buffer[1:5])
 	values := strings.Split(string(buffer[5:]), "\u0000")
+	metrics.Counter("qr2_heartbeats_total", 1)
 
 	payload := map[string]string{}
 	unknowns := []string{}
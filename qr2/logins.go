@@ -58,3 +58,23 @@ func Logout(profileID uint32) {
 
 	delete(logins, profileID)
 }
+
+func IsLoggedIn(profileID uint32) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	_, exists := logins[profileID]
+	return exists
+}
+
+// Get the address of the QR2 session registered by the profile
+func GetLoginAddress(profileID uint32) (string, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if login, exists := logins[profileID]; exists && login.Session != nil {
+		return login.Session.Addr.String(), true
+	}
+
+	return "", false
+}
@@ -295,6 +295,7 @@ func processClientMessage(moduleName string, sender, receiver *Session, message
 		mutex.Unlock()
 		cookie := binary.BigEndian.Uint32(message[0x6:0xA])
 		logging.Notice(moduleName, "Send NN cookie", aurora.Cyan(strconv.FormatUint(uint64(cookie), 16)), "to", aurora.BrightCyan(destPid))
+		reportNATNEGCookie(destPid, cookie)
 		return
 	}
 	defer mutex.Unlock()
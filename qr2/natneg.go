@@ -0,0 +1,37 @@
+package qr2
+
+import (
+	"strconv"
+	"sync"
+)
+
+var (
+	// Told the profile ID of each client sent a NATNEG cookie by another, so
+	// the matchmaker can prepare the session the pair will negotiate with
+	natnegCookieCallback      func(profileID uint32, cookie uint32)
+	natnegCookieCallbackMutex = sync.RWMutex{}
+)
+
+// Set the function told about forwarded NATNEG cookies, nil to stop
+func SetNATNEGCookieCallback(callback func(profileID uint32, cookie uint32)) {
+	natnegCookieCallbackMutex.Lock()
+	natnegCookieCallback = callback
+	natnegCookieCallbackMutex.Unlock()
+}
+
+// Expects the global mutex to not be locked, since the callback may take
+// locks held by callers into qr2
+func reportNATNEGCookie(destPid string, cookie uint32) {
+	profileID, err := strconv.ParseUint(destPid, 10, 32)
+	if err != nil {
+		return
+	}
+
+	natnegCookieCallbackMutex.RLock()
+	callback := natnegCookieCallback
+	natnegCookieCallbackMutex.RUnlock()
+
+	if callback != nil {
+		callback(uint32(profileID), cookie)
+	}
+}
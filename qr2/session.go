@@ -8,6 +8,7 @@ import (
 	"time"
 	"wwfc/common"
 	"wwfc/logging"
+	"wwfc/metrics"
 
 	"github.com/logrusorgru/aurora/v3"
 	"github.com/sasha-s/go-deadlock"
@@ -65,6 +66,7 @@ func removeSession(addr uint64) {
 	delete(sessionBySearchID, sessions[addr].SearchID)
 
 	delete(sessions, addr)
+	metrics.Gauge("qr2_sessions", float64(len(sessions)))
 }
 
 // Remove session from group. Expects the global mutex to already be locked.
@@ -140,6 +142,7 @@ func setSessionData(moduleName string, addr net.Addr, sessionId uint32, payload
 		}
 
 		sessions[lookupAddr] = session
+		metrics.Gauge("qr2_sessions", float64(len(sessions)))
 		return *session, true
 	}
 
@@ -276,3 +279,15 @@ func GetSearchID(addr uint64) uint64 {
 
 	return 0
 }
+
+// Get the game code of the logged in client behind the QR2 session at the address
+func GetGameCode(addr string) (string, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if session := sessions[makeLookupAddr(addr)]; session != nil && session.Login != nil {
+		return session.Login.GameCode, true
+	}
+
+	return "", false
+}